@@ -6,6 +6,8 @@ import (
 	"time"
 
 	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
 
 	"github.com/scriptoxin/yandex-liceum-go-calc/internal/evaluator"
 	pb "github.com/scriptoxin/yandex-liceum-go-calc/proto"
@@ -21,6 +23,11 @@ func main() {
 	for {
 		// Запрашиваем задачу у оркестратора
 		task, err := client.GetTask(context.Background(), &pb.Empty{})
+		if status.Code(err) == codes.NotFound {
+			// очередь пуста — ждём новых задач
+			time.Sleep(time.Second)
+			continue
+		}
 		if err != nil {
 			log.Printf("GetTask error: %v", err)
 			time.Sleep(time.Second)
@@ -40,7 +47,10 @@ func main() {
 			Id:    task.Id,
 			Value: result,
 		})
-		if err != nil {
+		if status.Code(err) == codes.Aborted {
+			// выражение отменили или аренда истекла — результат никому не нужен
+			log.Printf("task %s abandoned: %v", task.Id, status.Convert(err).Message())
+		} else if err != nil {
 			log.Printf("SubmitResult error: %v", err)
 		}
 
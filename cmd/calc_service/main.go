@@ -2,11 +2,19 @@ package main
 
 import (
 	"log"
+	"net"
 	"net/http"
+	"os"
+	"strconv"
+	"time"
+
+	"google.golang.org/grpc"
 
-	"github.com/gorilla/mux"
 	"github.com/scriptoxin/yandex-liceum-go-calc/internal/handlers"
+	"github.com/scriptoxin/yandex-liceum-go-calc/internal/orchestrator"
 	"github.com/scriptoxin/yandex-liceum-go-calc/pkg/db"
+	apperrors "github.com/scriptoxin/yandex-liceum-go-calc/pkg/errors"
+	pb "github.com/scriptoxin/yandex-liceum-go-calc/proto"
 )
 
 func main() {
@@ -15,18 +23,130 @@ func main() {
 		log.Fatalf("DB init failed: %v", err)
 	}
 
-	r := mux.NewRouter()
-	// публичные эндпойнты
-	r.HandleFunc("/api/v1/register", handlers.Register).Methods("POST")
-	r.HandleFunc("/api/v1/login", handlers.Login).Methods("POST")
+	// Пользователь из ADMIN_LOGIN получает роль администратора
+	if login := os.Getenv("ADMIN_LOGIN"); login != "" {
+		if _, err := db.Conn.Exec("UPDATE users SET role = ? WHERE login = ?", handlers.RoleAdmin, login); err != nil {
+			log.Fatalf("admin bootstrap failed: %v", err)
+		}
+	}
 
-	// защищённая часть
-	auth := r.PathPrefix("/api/v1").Subrouter()
-	auth.Use(handlers.AuthMiddleware)
-	auth.HandleFunc("/calculate", handlers.Calculate).Methods("POST")
-	auth.HandleFunc("/expressions", handlers.GetExpressions).Methods("GET")
-	auth.HandleFunc("/expressions/{id}", handlers.GetExpression).Methods("GET")
+	// Оркестратор раздаёт задачи агентам по gRPC
+	handlers.Orch = orchestrator.New()
+	// Кэш результатов: CACHE_SIZE записей в памяти, 0 — без кэша
+	cacheSize := orchestrator.DefaultCacheSize
+	if v := os.Getenv("CACHE_SIZE"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil {
+			log.Fatalf("CACHE_SIZE: %v", err)
+		}
+		cacheSize = n
+	}
+	if cacheSize > 0 {
+		handlers.Orch.Cache = orchestrator.NewCache(cacheSize, orchestrator.EvalMode)
+	}
+	// Сколько задач одного пользователя могут считаться одновременно, 0 — без ограничения
+	if v := os.Getenv("MAX_CONCURRENT_TASKS"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil {
+			log.Fatalf("MAX_CONCURRENT_TASKS: %v", err)
+		}
+		handlers.Orch.MaxConcurrent = n
+	}
+	// Вебхуки: WEBHOOK_MAX_ATTEMPTS попыток с паузой от WEBHOOK_BACKOFF, удваивающейся после каждой неудачи
+	webhooks := orchestrator.NewWebhooks()
+	if v := os.Getenv("WEBHOOK_MAX_ATTEMPTS"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil {
+			log.Fatalf("WEBHOOK_MAX_ATTEMPTS: %v", err)
+		}
+		webhooks.MaxAttempts = n
+	}
+	if v := os.Getenv("WEBHOOK_BACKOFF"); v != "" {
+		d, err := time.ParseDuration(v)
+		if err != nil {
+			log.Fatalf("WEBHOOK_BACKOFF: %v", err)
+		}
+		webhooks.Backoff = d
+	}
+	// WEBHOOK_ALLOW_PRIVATE=true разрешает вебхуки на localhost и во внутреннюю сеть — только для отладки
+	if v := os.Getenv("WEBHOOK_ALLOW_PRIVATE"); v != "" {
+		allow, err := strconv.ParseBool(v)
+		if err != nil {
+			log.Fatalf("WEBHOOK_ALLOW_PRIVATE: %v", err)
+		}
+		webhooks.AllowPrivate = allow
+	}
+	handlers.Orch.Webhooks = webhooks
+	if err := handlers.Orch.Recover(); err != nil {
+		log.Fatalf("recover failed: %v", err)
+	}
+	lis, err := net.Listen("tcp", ":50051")
+	if err != nil {
+		log.Fatalf("gRPC listen failed: %v", err)
+	}
+	grpcServer := grpc.NewServer(grpc.UnaryInterceptor(apperrors.UnaryServerInterceptor))
+	pb.RegisterCalculatorServer(grpcServer, handlers.Orch)
+	go func() {
+		log.Println("gRPC listening on :50051")
+		log.Fatal(grpcServer.Serve(lis))
+	}()
+
+	policy, err := handlers.LoadAuthPolicy()
+	if err != nil {
+		log.Fatalf("auth policy: %v", err)
+	}
+	handlers.Policy = policy
+	if handlers.Quotas, err = handlers.LoadQuotas(); err != nil {
+		log.Fatalf("quotas: %v", err)
+	}
+
+	// Отложенное удаление аккаунтов и истёкшие Idempotency-Key: раз в минуту чистим то, чей срок истёк
+	if v := os.Getenv("ACCOUNT_DELETE_GRACE"); v != "" {
+		if handlers.DeletionGrace, err = time.ParseDuration(v); err != nil {
+			log.Fatalf("ACCOUNT_DELETE_GRACE: %v", err)
+		}
+	}
+	if v := os.Getenv("IDEMPOTENCY_TTL"); v != "" {
+		if handlers.IdempotencyTTL, err = time.ParseDuration(v); err != nil {
+			log.Fatalf("IDEMPOTENCY_TTL: %v", err)
+		}
+	}
+	// Ограничения на размер выражений: длина в символах, глубина дерева, число узлов
+	for env, limit := range map[string]*int{
+		"EXPR_MAX_LENGTH": &handlers.ExprLimits.MaxLength,
+		"EXPR_MAX_DEPTH":  &handlers.ExprLimits.MaxDepth,
+		"EXPR_MAX_NODES":  &handlers.ExprLimits.MaxNodes,
+	} {
+		if v := os.Getenv(env); v != "" {
+			if *limit, err = strconv.Atoi(v); err != nil {
+				log.Fatalf("%s: %v", env, err)
+			}
+		}
+	}
+	go func() {
+		tick := time.NewTicker(time.Second)
+		for {
+			select {
+			case <-tick.C:
+			case <-webhooks.Queued():
+			}
+			webhooks.Deliver(time.Now())
+		}
+	}()
+	// Расписания проверяются каждую секунду
+	go func() {
+		for now := range time.Tick(time.Second) {
+			handlers.RunSchedules(now)
+		}
+	}()
+	go func() {
+		for range time.Tick(time.Minute) {
+			handlers.PurgeDeletedAccounts()
+			handlers.PurgeIdempotencyKeys()
+		}
+	}()
 
+	r := handlers.NewRouter()
 	log.Println("Server listening on :8080")
 	log.Fatal(http.ListenAndServe(":8080", r))
 }
@@ -1,103 +1,42 @@
 package evaluator
 
-import (
-	"errors"
-	"strconv"
-	"strings"
-	"unicode"
-)
+import "errors"
 
 // ErrInvalidExpression возвращается при некорректном выражении.
 var ErrInvalidExpression = errors.New("invalid expression")
 
-// Calc принимает арифметическое выражение, удаляет пробелы и вычисляет результат.
+// Calc вычисляет арифметическое выражение. Разбирает его тот же Parse, что и оркестратор,
+// поэтому оба принимают одни и те же выражения. Любая ошибка, в том числе деление
+// на ноль, — ErrInvalidExpression.
 func Calc(expression string) (float64, error) {
-	expression = strings.ReplaceAll(expression, " ", "")
-	result, pos, err := eval(expression, 0)
-	// Если вся строка не обработана, считаем, что выражение некорректно.
-	if pos < len(expression) || err != nil {
+	root, err := Parse(expression)
+	if err != nil {
+		return 0, ErrInvalidExpression
+	}
+	result, err := evalNode(root)
+	if err != nil {
 		return 0, ErrInvalidExpression
 	}
 	return result, nil
 }
 
-func eval(expression string, pos int) (float64, int, error) {
-	var numStack []float64
-	var opStack []byte
-
-	for pos < len(expression) {
-		char := expression[pos]
-		if unicode.IsDigit(rune(char)) || char == '.' {
-			value, newPos, err := parseNumber(expression, pos)
-			if err != nil {
-				return 0, pos, err
-			}
-			numStack = append(numStack, value)
-			pos = newPos
-		} else if char == '(' {
-			value, newPos, err := eval(expression, pos+1)
-			if err != nil {
-				return 0, pos, err
-			}
-			numStack = append(numStack, value)
-			pos = newPos
-		} else if char == ')' {
-			pos++ // пропускаем ')'
-			break
-		} else if isOperator(char) {
-			for len(opStack) > 0 && precedence(opStack[len(opStack)-1]) >= precedence(char) {
-				result, err := applyOperation(&numStack, &opStack)
-				if err != nil {
-					return 0, pos, err
-				}
-				numStack = append(numStack, result)
-			}
-			opStack = append(opStack, char)
-			pos++
-		} else {
-			return 0, pos, errors.New("invalid character in expression")
-		}
+// evalNode вычисляет дерево выражения снизу вверх.
+func evalNode(n *Node) (float64, error) {
+	if n.IsLeaf() {
+		return n.Value, nil
 	}
-
-	for len(opStack) > 0 {
-		result, err := applyOperation(&numStack, &opStack)
-		if err != nil {
-			return 0, pos, err
-		}
-		numStack = append(numStack, result)
-	}
-
-	if len(numStack) != 1 {
-		return 0, pos, errors.New("invalid expression")
-	}
-
-	return numStack[0], pos, nil
-}
-
-func parseNumber(expression string, pos int) (float64, int, error) {
-	startPos := pos
-	for pos < len(expression) && (unicode.IsDigit(rune(expression[pos])) || expression[pos] == '.') {
-		pos++
+	a, err := evalNode(n.Left)
+	if err != nil {
+		return 0, err
 	}
-	value, err := strconv.ParseFloat(expression[startPos:pos], 64)
+	b, err := evalNode(n.Right)
 	if err != nil {
-		return 0, pos, err
+		return 0, err
 	}
-	return value, pos, nil
+	return apply(n.Op, a, b)
 }
 
-func applyOperation(numStack *[]float64, opStack *[]byte) (float64, error) {
-	if len(*numStack) < 2 {
-		return 0, errors.New("not enough operands")
-	}
-
-	b := (*numStack)[len(*numStack)-1]
-	a := (*numStack)[len(*numStack)-2]
-	*numStack = (*numStack)[:len(*numStack)-2]
-
-	op := (*opStack)[len(*opStack)-1]
-	*opStack = (*opStack)[:len(*opStack)-1]
-
+func apply(op byte, a, b float64) (float64, error) {
 	switch op {
 	case '+':
 		return a + b, nil
@@ -114,18 +53,3 @@ func applyOperation(numStack *[]float64, opStack *[]byte) (float64, error) {
 		return 0, errors.New("unknown operator")
 	}
 }
-
-func isOperator(char byte) bool {
-	return char == '+' || char == '-' || char == '*' || char == '/'
-}
-
-func precedence(op byte) int {
-	switch op {
-	case '+', '-':
-		return 1
-	case '*', '/':
-		return 2
-	default:
-		return 0
-	}
-}
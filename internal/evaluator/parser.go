@@ -0,0 +1,199 @@
+package evaluator
+
+import (
+	"strconv"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// Node — узел дерева выражения. Лист хранит число, внутренний узел — операцию над Left и Right.
+type Node struct {
+	Op    byte
+	Value float64
+	Left  *Node
+	Right *Node
+}
+
+// IsLeaf сообщает, что узел уже является числом.
+func (n *Node) IsLeaf() bool {
+	return n.Left == nil && n.Right == nil
+}
+
+// Index нумерует узлы дерева в прямом порядке обхода (корень — 0).
+// Номера зависят только от текста выражения, поэтому совпадают при повторном разборе.
+func Index(root *Node) map[*Node]int {
+	index := make(map[*Node]int)
+	var walk func(n *Node)
+	walk = func(n *Node) {
+		index[n] = len(index)
+		if !n.IsLeaf() {
+			walk(n.Left)
+			walk(n.Right)
+		}
+	}
+	walk(root)
+	return index
+}
+
+// Canonical возвращает каноническую запись дерева: каждая операция в скобках,
+// числа в кратчайшей записи, операнды + и * упорядочены. Перестановка операндов
+// не меняет результат и в арифметике float64, поэтому у выражений с одинаковой
+// канонической записью одинаковый результат.
+func Canonical(n *Node) string {
+	if n.IsLeaf() {
+		return strconv.FormatFloat(n.Value, 'g', -1, 64)
+	}
+	left, right := Canonical(n.Left), Canonical(n.Right)
+	if (n.Op == '+' || n.Op == '*') && right < left {
+		left, right = right, left
+	}
+	return "(" + left + string(n.Op) + right + ")"
+}
+
+// Parse строит дерево выражения с учётом приоритета операций и скобок.
+// Язык: числа, + - * /, скобки и унарный минус (но не два минуса подряд: "--2"); Calc считает по этому же дереву.
+// Ошибка разбора — *SyntaxError с позицией в исходной строке.
+func Parse(expression string) (*Node, error) {
+	p := &parser{src: strings.ReplaceAll(expression, " ", "")}
+	// Пробелы выбрасываются, как в Calc, но для сообщений об ошибках
+	// запоминается, где каждый символ стоял в исходной строке
+	column := 0
+	for i := 0; i < len(expression); i++ {
+		if utf8.RuneStart(expression[i]) {
+			column++
+		}
+		if expression[i] != ' ' {
+			p.columns = append(p.columns, column)
+		}
+	}
+	p.end = column + 1
+
+	node, err := p.parseExpr()
+	if err != nil {
+		return nil, err
+	}
+	if p.pos < len(p.src) {
+		return nil, p.unexpected()
+	}
+	return node, nil
+}
+
+type parser struct {
+	src string
+	pos int
+	// columns — номер символа (с 1) в исходной строке для каждого байта src
+	columns []int
+	end     int
+}
+
+// errorAt возвращает ошибку разбора в позиции pos строки src.
+func (p *parser) errorAt(pos int, message string) *SyntaxError {
+	column := p.end
+	if pos < len(p.columns) {
+		column = p.columns[pos]
+	}
+	return &SyntaxError{Position: column, Message: message}
+}
+
+// unexpected — ошибка про текущий символ или про внезапный конец выражения.
+func (p *parser) unexpected() *SyntaxError {
+	if p.pos >= len(p.src) {
+		if len(p.src) == 0 {
+			return p.errorAt(p.pos, "expression is empty")
+		}
+		return p.errorAt(p.pos, "unexpected end of expression")
+	}
+	r, _ := utf8.DecodeRuneInString(p.src[p.pos:])
+	return p.errorAt(p.pos, "unexpected character "+strconv.QuoteRune(r))
+}
+
+func (p *parser) peek() byte {
+	if p.pos < len(p.src) {
+		return p.src[p.pos]
+	}
+	return 0
+}
+
+// parseExpr: term { (+|-) term }
+func (p *parser) parseExpr() (*Node, error) {
+	left, err := p.parseTerm()
+	if err != nil {
+		return nil, err
+	}
+	for p.peek() == '+' || p.peek() == '-' {
+		op := p.peek()
+		p.pos++
+		right, err := p.parseTerm()
+		if err != nil {
+			return nil, err
+		}
+		left = &Node{Op: op, Left: left, Right: right}
+	}
+	return left, nil
+}
+
+// parseTerm: factor { (*|/) factor }
+func (p *parser) parseTerm() (*Node, error) {
+	left, err := p.parseFactor()
+	if err != nil {
+		return nil, err
+	}
+	for p.peek() == '*' || p.peek() == '/' {
+		op := p.peek()
+		p.pos++
+		right, err := p.parseFactor()
+		if err != nil {
+			return nil, err
+		}
+		left = &Node{Op: op, Left: left, Right: right}
+	}
+	return left, nil
+}
+
+// parseFactor: число | ( expr ) | -factor
+func (p *parser) parseFactor() (*Node, error) {
+	char := p.peek()
+	switch {
+	case char == '-':
+		p.pos++
+		if p.peek() == '-' {
+			return nil, p.errorAt(p.pos, "double minus is not allowed")
+		}
+		operand, err := p.parseFactor()
+		if err != nil {
+			return nil, err
+		}
+		if operand.IsLeaf() {
+			return &Node{Value: -operand.Value}, nil
+		}
+		return &Node{Op: '-', Left: &Node{}, Right: operand}, nil
+	case char == '(':
+		open := p.pos
+		p.pos++
+		node, err := p.parseExpr()
+		if err != nil {
+			return nil, err
+		}
+		if p.peek() != ')' {
+			if p.pos >= len(p.src) {
+				return nil, p.errorAt(open, "unclosed parenthesis")
+			}
+			return nil, p.unexpected()
+		}
+		p.pos++
+		return node, nil
+	case unicode.IsDigit(rune(char)) || char == '.':
+		start := p.pos
+		for unicode.IsDigit(rune(p.peek())) || p.peek() == '.' {
+			p.pos++
+		}
+		value, err := strconv.ParseFloat(p.src[start:p.pos], 64)
+		if err != nil {
+			return nil, p.errorAt(start, "invalid number "+strconv.Quote(p.src[start:p.pos]))
+		}
+		return &Node{Value: value}, nil
+	default:
+		return nil, p.unexpected()
+	}
+}
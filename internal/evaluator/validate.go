@@ -0,0 +1,82 @@
+package evaluator
+
+import (
+	"strconv"
+	"unicode/utf8"
+)
+
+// SyntaxError — ошибка разбора выражения. Position — номер символа в исходной строке, с 1.
+type SyntaxError struct {
+	Position int
+	Message  string
+}
+
+func (e *SyntaxError) Error() string {
+	return e.Message + " at position " + strconv.Itoa(e.Position)
+}
+
+// Unwrap позволяет проверять ошибку через errors.Is(err, ErrInvalidExpression).
+func (e *SyntaxError) Unwrap() error {
+	return ErrInvalidExpression
+}
+
+// Limits — ограничения на размер выражения; ноль отключает проверку.
+type Limits struct {
+	// MaxLength — длина строки в символах
+	MaxLength int
+	// MaxDepth — глубина дерева: самая длинная цепочка операций, которые нельзя считать параллельно
+	MaxDepth int
+	// MaxNodes — число узлов дерева (чисел и операций)
+	MaxNodes int
+}
+
+// LimitError — выражение корректно, но больше, чем разрешают Limits.
+type LimitError struct {
+	// Limit — какое ограничение нарушено: length, depth или nodes
+	Limit string
+	Max   int
+	Got   int
+}
+
+func (e *LimitError) Error() string {
+	return "expression " + e.Limit + " " + strconv.Itoa(e.Got) + " exceeds the limit of " + strconv.Itoa(e.Max)
+}
+
+// Stats — размеры выражения, которые проверяет Validate.
+type Stats struct {
+	Length int `json:"length"`
+	Depth  int `json:"depth"`
+	Nodes  int `json:"nodes"`
+}
+
+// Validate разбирает выражение и проверяет его по limits.
+// Ошибка — *SyntaxError или *LimitError.
+func Validate(expression string, limits Limits) (*Node, Stats, error) {
+	stats := Stats{Length: utf8.RuneCountInString(expression)}
+	// Длину проверяем до разбора, чтобы не разбирать заведомо слишком длинную строку
+	if limits.MaxLength > 0 && stats.Length > limits.MaxLength {
+		return nil, stats, &LimitError{"length", limits.MaxLength, stats.Length}
+	}
+	root, err := Parse(expression)
+	if err != nil {
+		return nil, stats, err
+	}
+	stats.Depth, stats.Nodes = measure(root)
+	if limits.MaxDepth > 0 && stats.Depth > limits.MaxDepth {
+		return nil, stats, &LimitError{"depth", limits.MaxDepth, stats.Depth}
+	}
+	if limits.MaxNodes > 0 && stats.Nodes > limits.MaxNodes {
+		return nil, stats, &LimitError{"nodes", limits.MaxNodes, stats.Nodes}
+	}
+	return root, stats, nil
+}
+
+// measure возвращает глубину дерева (лист — 0) и число узлов.
+func measure(n *Node) (depth, nodes int) {
+	if n.IsLeaf() {
+		return 0, 1
+	}
+	ld, ln := measure(n.Left)
+	rd, rn := measure(n.Right)
+	return max(ld, rd) + 1, ln + rn + 1
+}
@@ -0,0 +1,59 @@
+package evaluator
+
+import (
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// Substitute подставляет в выражение значения переменных вместо их имён.
+// Имя — латинские буквы, цифры и _, начинается не с цифры. Значение берётся в скобки:
+// так не получится двойной минус, а "2 x" не склеится в одно число.
+// Неизвестная переменная — *SyntaxError с позицией имени в исходной строке.
+func Substitute(expression string, vars map[string]float64) (string, error) {
+	var b strings.Builder
+	column := 0
+	for i := 0; i < len(expression); {
+		if utf8.RuneStart(expression[i]) {
+			column++
+		}
+		if !isNameStart(expression[i]) {
+			b.WriteByte(expression[i])
+			i++
+			continue
+		}
+		start := i
+		for i < len(expression) && isNamePart(expression[i]) {
+			i++
+		}
+		name := expression[start:i]
+		value, ok := vars[name]
+		if !ok {
+			return "", &SyntaxError{Position: column, Message: "unknown variable " + strconv.Quote(name)}
+		}
+		column += i - start - 1
+		b.WriteString("(" + strconv.FormatFloat(value, 'f', -1, 64) + ")")
+	}
+	return b.String(), nil
+}
+
+// ValidName сообщает, годится ли name в имена переменных.
+func ValidName(name string) bool {
+	if name == "" || !isNameStart(name[0]) {
+		return false
+	}
+	for i := 1; i < len(name); i++ {
+		if !isNamePart(name[i]) {
+			return false
+		}
+	}
+	return true
+}
+
+func isNameStart(c byte) bool {
+	return c == '_' || (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z')
+}
+
+func isNamePart(c byte) bool {
+	return isNameStart(c) || (c >= '0' && c <= '9')
+}
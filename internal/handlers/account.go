@@ -0,0 +1,411 @@
+package handlers
+
+import (
+	"database/sql"
+	"encoding/json"
+	"log"
+	"net/http"
+	"slices"
+	"time"
+
+	"golang.org/x/crypto/bcrypt"
+
+	"github.com/scriptoxin/yandex-liceum-go-calc/internal/orchestrator"
+	"github.com/scriptoxin/yandex-liceum-go-calc/pkg/db"
+	apperrors "github.com/scriptoxin/yandex-liceum-go-calc/pkg/errors"
+	"github.com/scriptoxin/yandex-liceum-go-calc/pkg/jwt"
+)
+
+// DeletionGrace — сколько аккаунт живёт после запроса на удаление.
+// 0 — данные удаляются сразу.
+var DeletionGrace time.Duration
+
+type profileRequest struct {
+	Login       *string `json:"login"`
+	DisplayName *string `json:"display_name"`
+}
+
+type passwordRequest struct {
+	CurrentPassword string `json:"current_password"`
+	NewPassword     string `json:"new_password"`
+}
+
+type deleteAccountRequest struct {
+	Password string `json:"password"`
+}
+
+// GetMe — GET /api/v1/me
+func GetMe(w http.ResponseWriter, r *http.Request) {
+	uid := r.Context().Value("user_id").(int)
+
+	var (
+		login, displayName, role string
+		deleteAt                 sql.NullTime
+	)
+	err := db.Conn.QueryRow(
+		"SELECT login, display_name, role, delete_at FROM users WHERE id = ?", uid,
+	).Scan(&login, &displayName, &role, &deleteAt)
+	if err != nil {
+		apperrors.Write(w, r, apperrors.ErrNotFound)
+		return
+	}
+	out := map[string]interface{}{
+		"id":           uid,
+		"login":        login,
+		"display_name": displayName,
+		"role":         role,
+	}
+	if deleteAt.Valid {
+		out["delete_at"] = deleteAt.Time
+	}
+	json.NewEncoder(w).Encode(out)
+}
+
+// UpdateMe — PATCH /api/v1/me
+// Меняются только переданные поля: login и display_name.
+func UpdateMe(w http.ResponseWriter, r *http.Request) {
+	uid := r.Context().Value("user_id").(int)
+
+	var req profileRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		apperrors.Write(w, r, apperrors.ErrBadRequest)
+		return
+	}
+	if req.Login != nil {
+		if err := Policy.validateLogin(*req.Login); err != nil {
+			apperrors.Write(w, r, err)
+			return
+		}
+		if _, err := db.Conn.Exec("UPDATE users SET login = ? WHERE id = ?", *req.Login, uid); err != nil {
+			apperrors.Write(w, r, apperrors.ErrLoginTaken)
+			return
+		}
+	}
+	if req.DisplayName != nil {
+		if len(*req.DisplayName) > 64 {
+			apperrors.Write(w, r, apperrors.ErrInvalidField.WithField("display_name", "must be at most 64 characters"))
+			return
+		}
+		if _, err := db.Conn.Exec("UPDATE users SET display_name = ? WHERE id = ?", *req.DisplayName, uid); err != nil {
+			apperrors.Write(w, r, apperrors.ErrInternalServer)
+			return
+		}
+	}
+	GetMe(w, r)
+}
+
+// ChangePassword — POST /api/v1/me/password
+// Требует текущий пароль. Все выпущенные ранее JWT перестают действовать,
+// в ответе — новый токен.
+func ChangePassword(w http.ResponseWriter, r *http.Request) {
+	uid := r.Context().Value("user_id").(int)
+
+	var req passwordRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		apperrors.Write(w, r, apperrors.ErrBadRequest)
+		return
+	}
+	if !checkPassword(uid, req.CurrentPassword) {
+		apperrors.Write(w, r, apperrors.ErrWrongPassword.WithMessage("Current password is wrong"))
+		return
+	}
+	if err := Policy.validatePassword(req.NewPassword); err != nil {
+		apperrors.Write(w, r, err)
+		return
+	}
+	hash, err := bcrypt.GenerateFromPassword([]byte(req.NewPassword), bcrypt.DefaultCost)
+	if err != nil {
+		apperrors.Write(w, r, apperrors.ErrInternalServer)
+		return
+	}
+
+	var (
+		role    string
+		version int
+	)
+	err = db.Conn.QueryRow(
+		"UPDATE users SET password = ?, token_version = token_version + 1 WHERE id = ? RETURNING role, token_version",
+		string(hash), uid,
+	).Scan(&role, &version)
+	if err != nil {
+		apperrors.Write(w, r, apperrors.ErrInternalServer)
+		return
+	}
+	audit(r, uid, AuditPasswordChange, "", nil)
+	audit(r, uid, AuditTokensRevoked, "", map[string]interface{}{"reason": "password_change"})
+	token, err := jwt.Generate(uid, role, version)
+	if err != nil {
+		apperrors.Write(w, r, apperrors.ErrInternalServer)
+		return
+	}
+	json.NewEncoder(w).Encode(map[string]string{"token": token})
+}
+
+// DeleteMe — DELETE /api/v1/me
+// Удаляет аккаунт вместе с выражениями, API-ключами и кодами восстановления.
+// Если задан DeletionGrace, удаление откладывается и его можно отменить
+// через POST /api/v1/me/restore.
+func DeleteMe(w http.ResponseWriter, r *http.Request) {
+	uid := r.Context().Value("user_id").(int)
+
+	var req deleteAccountRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		apperrors.Write(w, r, apperrors.ErrBadRequest)
+		return
+	}
+	if !checkPassword(uid, req.Password) {
+		apperrors.Write(w, r, apperrors.ErrWrongPassword)
+		return
+	}
+
+	if DeletionGrace > 0 {
+		deleteAt := time.Now().Add(DeletionGrace).UTC()
+		if _, err := db.Conn.Exec("UPDATE users SET delete_at = ? WHERE id = ?", deleteAt, uid); err != nil {
+			apperrors.Write(w, r, apperrors.ErrInternalServer)
+			return
+		}
+		audit(r, uid, AuditAccountDelete, "", map[string]interface{}{"delete_at": deleteAt})
+		w.WriteHeader(http.StatusAccepted)
+		json.NewEncoder(w).Encode(map[string]interface{}{"delete_at": deleteAt})
+		return
+	}
+
+	if err := deleteUser(uid); err != nil {
+		apperrors.Write(w, r, apperrors.ErrInternalServer)
+		return
+	}
+	audit(r, uid, AuditAccountDelete, "", nil)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// RestoreMe — POST /api/v1/me/restore
+// Отменяет отложенное удаление аккаунта.
+func RestoreMe(w http.ResponseWriter, r *http.Request) {
+	uid := r.Context().Value("user_id").(int)
+
+	if _, err := db.Conn.Exec("UPDATE users SET delete_at = NULL WHERE id = ?", uid); err != nil {
+		apperrors.Write(w, r, apperrors.ErrInternalServer)
+		return
+	}
+	GetMe(w, r)
+}
+
+// PurgeDeletedAccounts удаляет аккаунты, у которых истёк срок отложенного удаления.
+func PurgeDeletedAccounts() {
+	rows, err := db.Conn.Query("SELECT id FROM users WHERE delete_at IS NOT NULL AND delete_at <= ?", time.Now().UTC())
+	if err != nil {
+		log.Printf("purge accounts: %v", err)
+		return
+	}
+	var ids []int
+	for rows.Next() {
+		var id int
+		rows.Scan(&id)
+		ids = append(ids, id)
+	}
+	rows.Close()
+
+	for _, id := range ids {
+		if err := deleteUser(id); err != nil {
+			log.Printf("purge account %d: %v", id, err)
+		}
+	}
+}
+
+// deleteUser удаляет пользователя и его данные в одной транзакции.
+// Выражения, пакеты и расписания в пространствах организаций остаются организации и
+// переходят к её владельцу. Если пользователь — единственный владелец организации,
+// владение переходит к её администратору (иначе к другому участнику); организация без
+// других участников удаляется вместе с данными. Незавершённые выражения, которые
+// удаляются, отменяются в оркестраторе после фиксации транзакции.
+func deleteUser(uid int) error {
+	heirs, dropped, err := orgSuccession(uid)
+	if err != nil {
+		return err
+	}
+	tx, err := db.Conn.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	for orgID, heir := range heirs {
+		if _, err := tx.Exec("UPDATE org_members SET role = ? WHERE org_id = ? AND user_id = ?", OrgOwner, orgID, heir); err != nil {
+			return err
+		}
+	}
+	// scope — удаляемые выражения, пакеты и расписания: личные, из удаляемых организаций
+	// и из организаций, где их некому передать
+	scope, args := "(user_id = ? AND org_id IS NULL)", []interface{}{uid}
+	for _, orgID := range dropped {
+		scope += " OR org_id = ?"
+		args = append(args, orgID)
+	}
+	owners, orphaned, err := orgDataOwners(tx, uid, dropped)
+	if err != nil {
+		return err
+	}
+	for _, orgID := range orphaned {
+		scope += " OR (user_id = ? AND org_id = ?)"
+		args = append(args, uid, orgID)
+	}
+
+	var live []string
+	if Orch != nil {
+		rows, err := tx.Query(
+			"SELECT id FROM expressions WHERE ("+scope+") AND status IN (?, ?)",
+			append(append([]interface{}{}, args...), orchestrator.StatusPending, orchestrator.StatusInProgress)...,
+		)
+		if err != nil {
+			return err
+		}
+		for rows.Next() {
+			var id string
+			rows.Scan(&id)
+			live = append(live, id)
+		}
+		rows.Close()
+	}
+
+	for _, q := range []string{
+		"DELETE FROM tasks WHERE expression_id IN (SELECT id FROM expressions WHERE " + scope + ")",
+		"DELETE FROM expressions WHERE " + scope,
+		"DELETE FROM batches WHERE " + scope,
+		"DELETE FROM schedules WHERE " + scope,
+	} {
+		if _, err := tx.Exec(q, args...); err != nil {
+			return err
+		}
+	}
+	for orgID, owner := range owners {
+		for _, table := range []string{"expressions", "batches", "schedules"} {
+			if _, err := tx.Exec("UPDATE "+table+" SET user_id = ? WHERE user_id = ? AND org_id = ?", owner, uid, orgID); err != nil {
+				return err
+			}
+		}
+	}
+	for _, orgID := range dropped {
+		for _, query := range []string{
+			"DELETE FROM invitations WHERE org_id = ?",
+			"DELETE FROM org_members WHERE org_id = ?",
+			"DELETE FROM organizations WHERE id = ?",
+		} {
+			if _, err := tx.Exec(query, orgID); err != nil {
+				return err
+			}
+		}
+	}
+	for _, query := range []string{
+		"DELETE FROM webhook_attempts WHERE delivery_id IN (SELECT id FROM webhook_deliveries WHERE user_id = ?)",
+		"DELETE FROM webhook_deliveries WHERE user_id = ?",
+		"DELETE FROM webhooks WHERE user_id = ?",
+		"DELETE FROM idempotency_keys WHERE user_id = ?",
+		"DELETE FROM api_keys WHERE user_id = ?",
+		"DELETE FROM recovery_codes WHERE user_id = ?",
+		"DELETE FROM org_members WHERE user_id = ?",
+		"DELETE FROM users WHERE id = ?",
+	} {
+		if _, err := tx.Exec(query, uid); err != nil {
+			return err
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+	for _, id := range live {
+		Orch.Cancel(id)
+	}
+	return nil
+}
+
+// orgDataOwners находит организации, где у uid есть выражения, пакеты или расписания,
+// кроме удаляемых, и кому они переходят: владельцу организации (наследник уже назначен
+// в этой транзакции) или, если владельца нет, администратору либо другому участнику.
+// orphaned — организации, где не осталось никого, кроме uid.
+func orgDataOwners(tx *sql.Tx, uid int, dropped []int) (owners map[int]int, orphaned []int, err error) {
+	rows, err := tx.Query(
+		`SELECT org_id FROM expressions WHERE user_id = ? AND org_id IS NOT NULL
+		UNION SELECT org_id FROM batches WHERE user_id = ? AND org_id IS NOT NULL
+		UNION SELECT org_id FROM schedules WHERE user_id = ? AND org_id IS NOT NULL`,
+		uid, uid, uid,
+	)
+	if err != nil {
+		return nil, nil, err
+	}
+	var orgs []int
+	for rows.Next() {
+		var id int
+		rows.Scan(&id)
+		if !slices.Contains(dropped, id) {
+			orgs = append(orgs, id)
+		}
+	}
+	rows.Close()
+
+	owners = map[int]int{}
+	for _, orgID := range orgs {
+		var owner int
+		err := tx.QueryRow(
+			"SELECT user_id FROM org_members WHERE org_id = ? AND user_id != ? ORDER BY role = ? DESC, role = ? DESC, rowid LIMIT 1",
+			orgID, uid, OrgOwner, OrgAdmin,
+		).Scan(&owner)
+		switch {
+		case err == sql.ErrNoRows:
+			orphaned = append(orphaned, orgID)
+		case err != nil:
+			return nil, nil, err
+		default:
+			owners[orgID] = owner
+		}
+	}
+	return owners, orphaned, nil
+}
+
+// orgSuccession находит организации, где uid — единственный владелец.
+// Возвращает, кому в каждой из них перейдёт владение, и организации без других участников.
+func orgSuccession(uid int) (map[int]int, []int, error) {
+	rows, err := db.Conn.Query(
+		`SELECT org_id FROM org_members m WHERE user_id = ? AND role = ? AND NOT EXISTS (
+			SELECT 1 FROM org_members o WHERE o.org_id = m.org_id AND o.user_id != m.user_id AND o.role = ?
+		)`,
+		uid, OrgOwner, OrgOwner,
+	)
+	if err != nil {
+		return nil, nil, err
+	}
+	var orgs []int
+	for rows.Next() {
+		var id int
+		rows.Scan(&id)
+		orgs = append(orgs, id)
+	}
+	rows.Close()
+
+	heirs := map[int]int{}
+	var dropped []int
+	for _, orgID := range orgs {
+		var heir int
+		err := db.Conn.QueryRow(
+			"SELECT user_id FROM org_members WHERE org_id = ? AND user_id != ? ORDER BY role = ? DESC, rowid LIMIT 1",
+			orgID, uid, OrgAdmin,
+		).Scan(&heir)
+		switch {
+		case err == sql.ErrNoRows:
+			dropped = append(dropped, orgID)
+		case err != nil:
+			return nil, nil, err
+		default:
+			heirs[orgID] = heir
+		}
+	}
+	return heirs, dropped, nil
+}
+
+// checkPassword сверяет пароль пользователя с хешем в БД.
+func checkPassword(uid int, password string) bool {
+	var hash string
+	if err := db.Conn.QueryRow("SELECT password FROM users WHERE id = ?", uid).Scan(&hash); err != nil {
+		return false
+	}
+	return bcrypt.CompareHashAndPassword([]byte(hash), []byte(password)) == nil
+}
@@ -0,0 +1,228 @@
+package handlers
+
+import (
+	"database/sql"
+	"encoding/json"
+	"net/http"
+	"strconv"
+
+	"github.com/gorilla/mux"
+
+	"github.com/scriptoxin/yandex-liceum-go-calc/internal/orchestrator"
+	"github.com/scriptoxin/yandex-liceum-go-calc/pkg/db"
+	apperrors "github.com/scriptoxin/yandex-liceum-go-calc/pkg/errors"
+)
+
+type roleRequest struct {
+	Role string `json:"role"`
+}
+
+// ListUsers — GET /api/v1/admin/users
+func ListUsers(w http.ResponseWriter, r *http.Request) {
+	rows, err := db.Conn.Query("SELECT id, login, role, disabled, weight, COALESCE(max_concurrent, 0), quota_per_minute, quota_pending, quota_stored FROM users ORDER BY id")
+	if err != nil {
+		apperrors.Write(w, r, apperrors.ErrInternalServer)
+		return
+	}
+	defer rows.Close()
+
+	list := []map[string]interface{}{}
+	for rows.Next() {
+		var (
+			id            int
+			login         string
+			role          string
+			disabled      bool
+			weight        float64
+			maxConcurrent int
+
+			perMinute, pending, stored sql.NullInt64
+		)
+		rows.Scan(&id, &login, &role, &disabled, &weight, &maxConcurrent, &perMinute, &pending, &stored)
+		list = append(list, map[string]interface{}{
+			"id":             id,
+			"login":          login,
+			"role":           role,
+			"disabled":       disabled,
+			"weight":         weight,
+			"max_concurrent": maxConcurrent,
+			"quota": quotaOverride{
+				PerMinute: nullIntPtr(perMinute),
+				Pending:   nullIntPtr(pending),
+				Stored:    nullIntPtr(stored),
+			},
+		})
+	}
+	json.NewEncoder(w).Encode(map[string]interface{}{"users": list})
+}
+
+// DisableUser — POST /api/v1/admin/users/{id}/disable
+func DisableUser(w http.ResponseWriter, r *http.Request) {
+	setDisabled(w, r, true)
+}
+
+// EnableUser — POST /api/v1/admin/users/{id}/enable
+func EnableUser(w http.ResponseWriter, r *http.Request) {
+	setDisabled(w, r, false)
+}
+
+func setDisabled(w http.ResponseWriter, r *http.Request, disabled bool) {
+	id, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		apperrors.Write(w, r, apperrors.ErrNotFound)
+		return
+	}
+	if id == r.Context().Value("user_id").(int) {
+		apperrors.Write(w, r, apperrors.ErrCannotChangeSelf)
+		return
+	}
+	action := AuditAdminEnable
+	if disabled {
+		action = AuditAdminDisable
+	}
+	if updateUser(w, r, "UPDATE users SET disabled = ? WHERE id = ?", disabled, id) {
+		audit(r, r.Context().Value("user_id").(int), action, strconv.Itoa(id), nil)
+	}
+}
+
+// SetUserRole — PUT /api/v1/admin/users/{id}/role
+// Свою роль сменить нельзя, последнего активного администратора — разжаловать тоже.
+func SetUserRole(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		apperrors.Write(w, r, apperrors.ErrNotFound)
+		return
+	}
+	var req roleRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		apperrors.Write(w, r, apperrors.ErrBadRequest)
+		return
+	}
+	if req.Role != RoleUser && req.Role != RoleAdmin && req.Role != RoleReadonly {
+		apperrors.Write(w, r, apperrors.ErrInvalidField.WithField("role", "must be user, admin or readonly"))
+		return
+	}
+	if id == r.Context().Value("user_id").(int) {
+		apperrors.Write(w, r, apperrors.ErrCannotChangeSelf)
+		return
+	}
+	// Проверка и смена роли — один запрос, чтобы два администратора, снимающие
+	// друг друга одновременно, не оставили систему без администраторов
+	res, err := db.Conn.Exec(
+		`UPDATE users SET role = ? WHERE id = ? AND (role != 'admin' OR ? = 'admin'
+			OR EXISTS (SELECT 1 FROM users WHERE role = 'admin' AND disabled = 0 AND id != ?))`,
+		req.Role, id, req.Role, id,
+	)
+	if err != nil {
+		apperrors.Write(w, r, apperrors.ErrInternalServer)
+		return
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		var exists bool
+		db.Conn.QueryRow("SELECT EXISTS (SELECT 1 FROM users WHERE id = ?)", id).Scan(&exists)
+		if exists {
+			apperrors.Write(w, r, apperrors.ErrLastAdmin)
+		} else {
+			apperrors.Write(w, r, apperrors.ErrNotFound)
+		}
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+	audit(r, r.Context().Value("user_id").(int), AuditAdminRole, strconv.Itoa(id), map[string]interface{}{"role": req.Role})
+}
+
+// SetUserScheduling — PUT /api/v1/admin/users/{id}/scheduling
+// {"weight":2,"max_concurrent":5}: доля агентов пользователя относительно других
+// и предел одновременно считающихся задач (0 — общий предел MAX_CONCURRENT_TASKS).
+func SetUserScheduling(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		apperrors.Write(w, r, apperrors.ErrNotFound)
+		return
+	}
+	var req orchestrator.UserLimits
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		apperrors.Write(w, r, apperrors.ErrBadRequest)
+		return
+	}
+	if req.Weight <= 0 || req.Weight > 100 {
+		apperrors.Write(w, r, apperrors.ErrInvalidField.WithField("weight", "must be greater than 0 and at most 100"))
+		return
+	}
+	if req.MaxConcurrent < 0 {
+		apperrors.Write(w, r, apperrors.ErrInvalidField.WithField("max_concurrent", "must not be negative"))
+		return
+	}
+	var maxConcurrent interface{}
+	if req.MaxConcurrent > 0 {
+		maxConcurrent = req.MaxConcurrent
+	}
+	res, err := db.Conn.Exec("UPDATE users SET weight = ?, max_concurrent = ? WHERE id = ?", req.Weight, maxConcurrent, id)
+	if err != nil {
+		apperrors.Write(w, r, apperrors.ErrInternalServer)
+		return
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		apperrors.Write(w, r, apperrors.ErrNotFound)
+		return
+	}
+	if Orch != nil {
+		Orch.SetUserLimits(id, req)
+	}
+	audit(r, r.Context().Value("user_id").(int), AuditAdminScheduling, strconv.Itoa(id),
+		map[string]interface{}{"weight": req.Weight, "max_concurrent": req.MaxConcurrent})
+	json.NewEncoder(w).Encode(map[string]interface{}{"scheduling": req})
+}
+
+// updateUser выполняет UPDATE по одному пользователю и отвечает 404, если его нет.
+func updateUser(w http.ResponseWriter, r *http.Request, query string, value interface{}, id int) bool {
+	res, err := db.Conn.Exec(query, value, id)
+	if err != nil {
+		apperrors.Write(w, r, apperrors.ErrInternalServer)
+		return false
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		apperrors.Write(w, r, apperrors.ErrNotFound)
+		return false
+	}
+	w.WriteHeader(http.StatusOK)
+	return true
+}
+
+// GetUserExpressions — GET /api/v1/admin/users/{id}/expressions
+func GetUserExpressions(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		apperrors.Write(w, r, apperrors.ErrNotFound)
+		return
+	}
+	writeExpressions(w, r, "user_id = ?", id)
+}
+
+// GetQueue — GET /api/v1/admin/queue
+func GetQueue(w http.ResponseWriter, r *http.Request) {
+	json.NewEncoder(w).Encode(map[string]interface{}{"tasks": Orch.Queue()})
+}
+
+// GetAgents — GET /api/v1/admin/agents
+func GetAgents(w http.ResponseWriter, r *http.Request) {
+	json.NewEncoder(w).Encode(map[string]interface{}{"agents": Orch.Agents()})
+}
+
+// GetCache — GET /api/v1/admin/cache
+// Счётчики кэша результатов: попадания, промахи, размер и число объединённых задач.
+func GetCache(w http.ResponseWriter, r *http.Request) {
+	json.NewEncoder(w).Encode(map[string]interface{}{"cache": Orch.CacheStats()})
+}
+
+// PurgeCache — DELETE /api/v1/admin/cache
+func PurgeCache(w http.ResponseWriter, r *http.Request) {
+	if Orch.Cache != nil {
+		if err := Orch.Cache.Purge(); err != nil {
+			apperrors.Write(w, r, apperrors.ErrInternalServer)
+			return
+		}
+	}
+	audit(r, r.Context().Value("user_id").(int), AuditCachePurge, "", nil)
+	w.WriteHeader(http.StatusNoContent)
+}
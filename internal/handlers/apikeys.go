@@ -0,0 +1,207 @@
+package handlers
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/gorilla/mux"
+
+	"github.com/scriptoxin/yandex-liceum-go-calc/pkg/db"
+	apperrors "github.com/scriptoxin/yandex-liceum-go-calc/pkg/errors"
+)
+
+// Области действия API-ключа.
+const (
+	ScopeRead   = "read"
+	ScopeSubmit = "submit"
+)
+
+// apiKeyPrefix начинается каждый ключ; по нему ключ легко узнать в логах и конфигах.
+const apiKeyPrefix = "ck_"
+
+var errInvalidAPIKey = errors.New("invalid api key")
+
+type apiKeyRequest struct {
+	Name      string     `json:"name"`
+	Scope     string     `json:"scope"`
+	ExpiresAt *time.Time `json:"expires_at"`
+}
+
+// RejectAPIKeys закрывает маршрут для запросов с API-ключом: управлять аккаунтом
+// и ключами можно только после входа по паролю, иначе утёкший ключ позволил бы
+// сменить пароль, включить 2FA или отозвать остальные ключи владельца.
+// Используется после AuthMiddleware.
+func RejectAPIKeys(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Context().Value("api_key_id") != nil {
+			apperrors.Write(w, r, apperrors.ErrSessionRequired)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// CreateAPIKey — POST /api/v1/api-keys
+// Ключ целиком возвращается только в этом ответе, в БД хранится его хеш.
+func CreateAPIKey(w http.ResponseWriter, r *http.Request) {
+	uid := r.Context().Value("user_id").(int)
+	role := r.Context().Value("role").(string)
+
+	var req apiKeyRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		apperrors.Write(w, r, apperrors.ErrBadRequest)
+		return
+	}
+	if req.Name == "" {
+		apperrors.Write(w, r, apperrors.ErrInvalidField.WithField("name", "is required"))
+		return
+	}
+	if req.Scope == "" {
+		req.Scope = ScopeRead
+	}
+	if req.Scope != ScopeRead && req.Scope != ScopeSubmit {
+		apperrors.Write(w, r, apperrors.ErrInvalidField.WithField("scope", "must be read or submit"))
+		return
+	}
+	if req.Scope == ScopeSubmit && role == RoleReadonly {
+		apperrors.Write(w, r, apperrors.ErrForbidden)
+		return
+	}
+	if req.ExpiresAt != nil && req.ExpiresAt.Before(time.Now()) {
+		apperrors.Write(w, r, apperrors.ErrInvalidField.WithField("expires_at", "must be in the future"))
+		return
+	}
+
+	buf := make([]byte, 24)
+	if _, err := rand.Read(buf); err != nil {
+		apperrors.Write(w, r, apperrors.ErrInternalServer)
+		return
+	}
+	key := apiKeyPrefix + hex.EncodeToString(buf)
+	prefix := key[:len(apiKeyPrefix)+8]
+
+	res, err := db.Conn.Exec(
+		"INSERT INTO api_keys(user_id, name, prefix, hash, scope, expires_at) VALUES(?, ?, ?, ?, ?, ?)",
+		uid, req.Name, prefix, hashAPIKey(key), req.Scope, req.ExpiresAt,
+	)
+	if err != nil {
+		apperrors.Write(w, r, apperrors.ErrInternalServer)
+		return
+	}
+	id, _ := res.LastInsertId()
+	audit(r, uid, AuditAPIKeyCreate, prefix, map[string]interface{}{"id": id, "scope": req.Scope})
+
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"id":         id,
+		"name":       req.Name,
+		"key":        key,
+		"prefix":     prefix,
+		"scope":      req.Scope,
+		"expires_at": req.ExpiresAt,
+	})
+}
+
+// ListAPIKeys — GET /api/v1/api-keys
+func ListAPIKeys(w http.ResponseWriter, r *http.Request) {
+	uid := r.Context().Value("user_id").(int)
+
+	rows, err := db.Conn.Query(
+		`SELECT id, name, prefix, scope, created_at, expires_at, last_used_at, revoked
+		 FROM api_keys WHERE user_id = ? ORDER BY id`,
+		uid,
+	)
+	if err != nil {
+		apperrors.Write(w, r, apperrors.ErrInternalServer)
+		return
+	}
+	defer rows.Close()
+
+	list := []map[string]interface{}{}
+	for rows.Next() {
+		var (
+			id                  int
+			name, prefix, scope string
+			created             time.Time
+			expires, lastUsed   sql.NullTime
+			revoked             bool
+		)
+		rows.Scan(&id, &name, &prefix, &scope, &created, &expires, &lastUsed, &revoked)
+		item := map[string]interface{}{
+			"id":         id,
+			"name":       name,
+			"prefix":     prefix,
+			"scope":      scope,
+			"created_at": created,
+			"revoked":    revoked,
+		}
+		if expires.Valid {
+			item["expires_at"] = expires.Time
+		}
+		if lastUsed.Valid {
+			item["last_used_at"] = lastUsed.Time
+		}
+		list = append(list, item)
+	}
+	json.NewEncoder(w).Encode(map[string]interface{}{"api_keys": list})
+}
+
+// RevokeAPIKey — DELETE /api/v1/api-keys/{id}
+func RevokeAPIKey(w http.ResponseWriter, r *http.Request) {
+	uid := r.Context().Value("user_id").(int)
+	id := mux.Vars(r)["id"]
+
+	res, err := db.Conn.Exec(
+		"UPDATE api_keys SET revoked = 1 WHERE id = ? AND user_id = ?",
+		id, uid,
+	)
+	if err != nil {
+		apperrors.Write(w, r, apperrors.ErrInternalServer)
+		return
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		apperrors.Write(w, r, apperrors.ErrNotFound)
+		return
+	}
+	audit(r, uid, AuditAPIKeyRevoke, id, nil)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// authAPIKey находит действующий ключ, отмечает его использование
+// и возвращает владельца, id ключа и область действия.
+func authAPIKey(key string) (uid int, keyID int, scope string, err error) {
+	var (
+		expires sql.NullTime
+		revoked bool
+	)
+	err = db.Conn.QueryRow(
+		"SELECT id, user_id, scope, expires_at, revoked FROM api_keys WHERE hash = ?",
+		hashAPIKey(key),
+	).Scan(&keyID, &uid, &scope, &expires, &revoked)
+	if err != nil || revoked || (expires.Valid && expires.Time.Before(time.Now())) {
+		return 0, 0, "", errInvalidAPIKey
+	}
+	db.Conn.Exec("UPDATE api_keys SET last_used_at = ? WHERE id = ?", time.Now().UTC(), keyID)
+	return uid, keyID, scope, nil
+}
+
+// scopeRole — роль, с которой действует запрос по ключу. Ключ не даёт прав
+// администратора: read работает как readonly, submit — как user, если сам
+// пользователь не readonly.
+func scopeRole(scope, userRole string) string {
+	if scope == ScopeSubmit && userRole != RoleReadonly {
+		return RoleUser
+	}
+	return RoleReadonly
+}
+
+func hashAPIKey(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(sum[:])
+}
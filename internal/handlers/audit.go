@@ -0,0 +1,199 @@
+package handlers
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"log"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+
+	"github.com/scriptoxin/yandex-liceum-go-calc/pkg/db"
+	apperrors "github.com/scriptoxin/yandex-liceum-go-calc/pkg/errors"
+)
+
+// Действия, которые попадают в журнал аудита.
+const (
+	AuditRegister        = "user.register"
+	AuditLoginSuccess    = "login.success"
+	AuditLoginFailure    = "login.failure"
+	AuditPasswordChange  = "user.password_change"
+	AuditTokensRevoked   = "token.revoke"
+	AuditAccountDelete   = "user.delete"
+	AuditAPIKeyCreate    = "api_key.create"
+	AuditAPIKeyRevoke    = "api_key.revoke"
+	AuditTwoFactorEnable = "2fa.enable"
+	AuditTwoFactorOff    = "2fa.disable"
+	AuditExprSubmit      = "expression.submit"
+	AuditBatchSubmit     = "expression.batch_submit"
+	AuditExprCancel      = "expression.cancel"
+	AuditExprDelete      = "expression.delete"
+	AuditAdminDisable    = "admin.user_disable"
+	AuditAdminEnable     = "admin.user_enable"
+	AuditAdminRole       = "admin.user_role"
+	AuditAdminScheduling = "admin.user_scheduling"
+	AuditCachePurge      = "admin.cache_purge"
+	AuditAdminQuota      = "admin.user_quota"
+	AuditScheduleCreate  = "schedule.create"
+	AuditScheduleUpdate  = "schedule.update"
+	AuditScheduleDelete  = "schedule.delete"
+	AuditWebhookCreate   = "webhook.create"
+	AuditWebhookDelete   = "webhook.delete"
+	AuditWebhookSecret   = "webhook.secret_rotate"
+)
+
+// auditMaxLimit — сколько событий максимум отдаётся одним JSON-ответом.
+const auditMaxLimit = 1000
+
+// RequestID берёт X-Request-ID из запроса или генерирует новый, кладёт его
+// в контекст и возвращает клиенту в том же заголовке.
+func RequestID(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id := r.Header.Get("X-Request-ID")
+		if id == "" || len(id) > 128 {
+			id = uuid.NewString()
+		}
+		w.Header().Set("X-Request-ID", id)
+		ctx := context.WithValue(r.Context(), "request_id", id)
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+// audit дописывает событие в журнал. uid == 0 — пользователь неизвестен
+// (например, неудачный вход с несуществующим логином).
+func audit(r *http.Request, uid int, action, target string, details map[string]interface{}) {
+	var user interface{}
+	if uid != 0 {
+		user = uid
+	}
+	var detailsJSON interface{}
+	if len(details) > 0 {
+		b, _ := json.Marshal(details)
+		detailsJSON = string(b)
+	}
+	requestID, _ := r.Context().Value("request_id").(string)
+	ip, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		ip = r.RemoteAddr
+	}
+	_, err = db.Conn.Exec(
+		`INSERT INTO audit_events(created_at, user_id, action, target, ip, user_agent, request_id, details)
+		 VALUES(?, ?, ?, ?, ?, ?, ?, ?)`,
+		time.Now().UTC(), user, action, target, ip, r.UserAgent(), requestID, detailsJSON,
+	)
+	if err != nil {
+		log.Printf("audit %s: %v", action, err)
+	}
+}
+
+// GetAuditEvents — GET /api/v1/admin/audit
+// Фильтры: user_id, action (точное значение или префикс с *), since, until (RFC 3339),
+// request_id, limit. С format=jsonl (или Accept: application/x-ndjson) отдаёт
+// все подходящие события потоком JSON Lines.
+func GetAuditEvents(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	var (
+		conds []string
+		args  []interface{}
+	)
+	if v := q.Get("user_id"); v != "" {
+		conds = append(conds, "user_id = ?")
+		args = append(args, v)
+	}
+	if v := q.Get("action"); v != "" {
+		if strings.HasSuffix(v, "*") {
+			conds = append(conds, "action LIKE ?")
+			args = append(args, strings.TrimSuffix(v, "*")+"%")
+		} else {
+			conds = append(conds, "action = ?")
+			args = append(args, v)
+		}
+	}
+	if v := q.Get("request_id"); v != "" {
+		conds = append(conds, "request_id = ?")
+		args = append(args, v)
+	}
+	for param, op := range map[string]string{"since": ">=", "until": "<"} {
+		if v := q.Get(param); v != "" {
+			t, err := time.Parse(time.RFC3339, v)
+			if err != nil {
+				apperrors.Write(w, r, apperrors.ErrInvalidField.WithField(param, "expected RFC 3339 time"))
+				return
+			}
+			conds = append(conds, "created_at "+op+" ?")
+			args = append(args, t.UTC())
+		}
+	}
+
+	jsonl := q.Get("format") == "jsonl" || r.Header.Get("Accept") == "application/x-ndjson"
+	query := "SELECT id, created_at, user_id, action, target, ip, user_agent, request_id, details FROM audit_events"
+	if len(conds) > 0 {
+		query += " WHERE " + strings.Join(conds, " AND ")
+	}
+	if jsonl {
+		query += " ORDER BY id"
+	} else {
+		limit := 100
+		if v, err := strconv.Atoi(q.Get("limit")); err == nil && v > 0 {
+			limit = v
+		}
+		if limit > auditMaxLimit {
+			limit = auditMaxLimit
+		}
+		query += " ORDER BY id DESC LIMIT " + strconv.Itoa(limit)
+	}
+
+	rows, err := db.Conn.Query(query, args...)
+	if err != nil {
+		apperrors.Write(w, r, apperrors.ErrInternalServer)
+		return
+	}
+	defer rows.Close()
+
+	if jsonl {
+		w.Header().Set("Content-Type", "application/x-ndjson")
+		w.Header().Set("Content-Disposition", `attachment; filename="audit.jsonl"`)
+		enc := json.NewEncoder(w)
+		for rows.Next() {
+			enc.Encode(scanAuditEvent(rows))
+		}
+		return
+	}
+	list := []map[string]interface{}{}
+	for rows.Next() {
+		list = append(list, scanAuditEvent(rows))
+	}
+	json.NewEncoder(w).Encode(map[string]interface{}{"events": list})
+}
+
+func scanAuditEvent(rows *sql.Rows) map[string]interface{} {
+	var (
+		id                                   int
+		created                              time.Time
+		user                                 sql.NullInt64
+		action, target, ip, agent, requestID string
+		details                              sql.NullString
+	)
+	rows.Scan(&id, &created, &user, &action, &target, &ip, &agent, &requestID, &details)
+	event := map[string]interface{}{
+		"id":         id,
+		"created_at": created,
+		"action":     action,
+		"target":     target,
+		"ip":         ip,
+		"user_agent": agent,
+		"request_id": requestID,
+	}
+	if user.Valid {
+		event["user_id"] = user.Int64
+	}
+	if details.Valid {
+		event["details"] = json.RawMessage(details.String)
+	}
+	return event
+}
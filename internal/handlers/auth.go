@@ -1,15 +1,29 @@
 package handlers
 
 import (
+	"database/sql"
 	"encoding/json"
 	"net/http"
+	"strconv"
+	"time"
 
 	"github.com/scriptoxin/yandex-liceum-go-calc/pkg/db"
+	apperrors "github.com/scriptoxin/yandex-liceum-go-calc/pkg/errors"
 	"github.com/scriptoxin/yandex-liceum-go-calc/pkg/jwt"
 
 	"golang.org/x/crypto/bcrypt"
 )
 
+// Роли пользователей. readonly может только читать свои выражения.
+const (
+	RoleUser     = "user"
+	RoleAdmin    = "admin"
+	RoleReadonly = "readonly"
+)
+
+// dummyHash — хеш, с которым сравнивается пароль неизвестного логина.
+var dummyHash, _ = bcrypt.GenerateFromPassword([]byte("dummy password"), bcrypt.DefaultCost)
+
 type authRequest struct {
 	Login    string `json:"login"`
 	Password string `json:"password"`
@@ -19,50 +33,133 @@ type authRequest struct {
 func Register(w http.ResponseWriter, r *http.Request) {
 	var req authRequest
 	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-		http.Error(w, "Invalid request", http.StatusBadRequest)
+		apperrors.Write(w, r, apperrors.ErrBadRequest)
+		return
+	}
+	if err := Policy.validateCredentials(req.Login, req.Password); err != nil {
+		apperrors.Write(w, r, err)
 		return
 	}
 	hash, err := bcrypt.GenerateFromPassword([]byte(req.Password), bcrypt.DefaultCost)
 	if err != nil {
-		http.Error(w, "Server error", http.StatusInternalServerError)
+		apperrors.Write(w, r, apperrors.ErrInternalServer)
 		return
 	}
-	_, err = db.Conn.Exec(
+	res, err := db.Conn.Exec(
 		"INSERT INTO users(login, password) VALUES(?, ?)",
 		req.Login, string(hash),
 	)
 	if err != nil {
-		http.Error(w, "Registration failed", http.StatusBadRequest)
+		apperrors.Write(w, r, apperrors.ErrLoginTaken)
 		return
 	}
+	id, _ := res.LastInsertId()
+	audit(r, int(id), AuditRegister, req.Login, nil)
 	w.WriteHeader(http.StatusOK)
 }
 
 // Login — POST /api/v1/login
+// После серии неудачных попыток аккаунт временно блокируется (см. AuthPolicy).
+// Если включена 2FA, вместо JWT возвращается challenge для POST /api/v1/login/2fa.
 func Login(w http.ResponseWriter, r *http.Request) {
 	var req authRequest
 	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-		http.Error(w, "Invalid request", http.StatusBadRequest)
+		apperrors.Write(w, r, apperrors.ErrBadRequest)
 		return
 	}
-	var id int
-	var hash string
+	var (
+		id          int
+		hash        string
+		disabled    bool
+		lockedUntil sql.NullTime
+		totpEnabled bool
+	)
 	err := db.Conn.QueryRow(
-		"SELECT id, password FROM users WHERE login = ?",
+		"SELECT id, password, disabled, locked_until, totp_enabled FROM users WHERE login = ?",
 		req.Login,
-	).Scan(&id, &hash)
+	).Scan(&id, &hash, &disabled, &lockedUntil, &totpEnabled)
 	if err != nil {
-		http.Error(w, "Invalid credentials", http.StatusUnauthorized)
+		// Сравниваем с заглушкой, чтобы по времени ответа нельзя было узнать, есть ли такой логин
+		bcrypt.CompareHashAndPassword(dummyHash, []byte(req.Password))
+		audit(r, 0, AuditLoginFailure, req.Login, map[string]interface{}{"reason": "unknown_login"})
+		apperrors.Write(w, r, apperrors.ErrInvalidCredentials)
+		return
+	}
+	if locked(w, r, lockedUntil) {
+		audit(r, id, AuditLoginFailure, req.Login, map[string]interface{}{"reason": "locked"})
 		return
 	}
 	if err := bcrypt.CompareHashAndPassword([]byte(hash), []byte(req.Password)); err != nil {
-		http.Error(w, "Invalid credentials", http.StatusUnauthorized)
+		recordLoginFailure(id)
+		audit(r, id, AuditLoginFailure, req.Login, map[string]interface{}{"reason": "wrong_password"})
+		apperrors.Write(w, r, apperrors.ErrInvalidCredentials)
+		return
+	}
+	if disabled {
+		audit(r, id, AuditLoginFailure, req.Login, map[string]interface{}{"reason": "disabled"})
+		apperrors.Write(w, r, apperrors.ErrAccountDisabled)
+		return
+	}
+	if totpEnabled {
+		challenge, err := jwt.GenerateChallenge(id)
+		if err != nil {
+			apperrors.Write(w, r, apperrors.ErrInternalServer)
+			return
+		}
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"two_factor_required": true,
+			"challenge":           challenge,
+		})
+		return
+	}
+	issueToken(w, r, id, "password")
+}
+
+// locked отвечает 429, если аккаунт заблокирован после неудачных входов.
+func locked(w http.ResponseWriter, r *http.Request, lockedUntil sql.NullTime) bool {
+	if !lockedUntil.Valid || !time.Now().Before(lockedUntil.Time) {
+		return false
+	}
+	w.Header().Set("Retry-After", strconv.Itoa(int(time.Until(lockedUntil.Time).Seconds())+1))
+	apperrors.Write(w, r, apperrors.ErrAccountLocked)
+	return true
+}
+
+// recordLoginFailure увеличивает счётчик неудачных входов и при необходимости блокирует аккаунт.
+// Счётчик увеличивается в самом UPDATE: параллельные попытки не затирают друг друга.
+func recordLoginFailure(id int) {
+	var failures int
+	err := db.Conn.QueryRow(
+		"UPDATE users SET failed_logins = failed_logins + 1 WHERE id = ? RETURNING failed_logins", id,
+	).Scan(&failures)
+	if err != nil {
+		return
+	}
+	if d := Policy.lockoutFor(failures); d > 0 {
+		db.Conn.Exec("UPDATE users SET locked_until = ? WHERE id = ?", time.Now().Add(d).UTC(), id)
+	}
+}
+
+// issueToken сбрасывает счётчик неудачных входов и отдаёт JWT пользователя.
+// method — чем подтверждён вход, для журнала аудита.
+func issueToken(w http.ResponseWriter, r *http.Request, id int, method string) {
+	var (
+		role    string
+		version int
+	)
+	err := db.Conn.QueryRow(
+		"UPDATE users SET failed_logins = 0, locked_until = NULL WHERE id = ? RETURNING role, token_version",
+		id,
+	).Scan(&role, &version)
+	if err != nil {
+		apperrors.Write(w, r, apperrors.ErrInternalServer)
 		return
 	}
-	token, err := jwt.Generate(id)
+	token, err := jwt.Generate(id, role, version)
 	if err != nil {
-		http.Error(w, "Server error", http.StatusInternalServerError)
+		apperrors.Write(w, r, apperrors.ErrInternalServer)
 		return
 	}
+	audit(r, id, AuditLoginSuccess, "", map[string]interface{}{"method": method})
 	json.NewEncoder(w).Encode(map[string]string{"token": token})
 }
@@ -0,0 +1,212 @@
+package handlers
+
+import (
+	"bytes"
+	"database/sql"
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/gorilla/mux"
+
+	"github.com/scriptoxin/yandex-liceum-go-calc/pkg/db"
+	apperrors "github.com/scriptoxin/yandex-liceum-go-calc/pkg/errors"
+)
+
+// maxBatchSize — сколько выражений можно отправить одним пакетом.
+const maxBatchSize = 1000
+
+type batchItem struct {
+	Expression string `json:"expression"`
+	Label      string `json:"label"`
+	Priority   int    `json:"priority"`
+}
+
+type batchRequest struct {
+	Expressions []batchItem `json:"expressions"`
+}
+
+// batchItemResult — id, выданный элементу пакета.
+type batchItemResult struct {
+	Index int    `json:"index"`
+	Label string `json:"label,omitempty"`
+	ID    string `json:"id"`
+}
+
+// CalculateBatch — POST /api/v1/calculate/batch
+// Принимает {"expressions":[{"expression":"2+2","label":"a"}, ...]} или просто массив.
+// Сначала проверяются все элементы: если хоть один некорректен, ничего не сохраняется
+// и в ответе 422 с ошибками по элементам в fields. Иначе все выражения сохраняются в одной транзакции.
+func CalculateBatch(w http.ResponseWriter, r *http.Request) {
+	uid := r.Context().Value("user_id").(int)
+	ws, ok := resolveWorkspace(w, r)
+	if !ok {
+		return
+	}
+
+	var (
+		req batchRequest
+		raw json.RawMessage
+	)
+	if err := json.NewDecoder(r.Body).Decode(&raw); err != nil {
+		apperrors.Write(w, r, apperrors.ErrBadRequest)
+		return
+	}
+	var err error
+	if trimmed := bytes.TrimSpace(raw); len(trimmed) > 0 && trimmed[0] == '[' {
+		err = json.Unmarshal(raw, &req.Expressions)
+	} else {
+		err = json.Unmarshal(raw, &req)
+	}
+	if err != nil {
+		apperrors.Write(w, r, apperrors.ErrBadRequest)
+		return
+	}
+	if len(req.Expressions) == 0 || len(req.Expressions) > maxBatchSize {
+		apperrors.Write(w, r, apperrors.ErrInvalidField.WithField("expressions", "must contain from 1 to 1000 items"))
+		return
+	}
+
+	// Ошибки по элементам возвращаются в fields: {"field":"expressions[3]","message":"...","position":5}
+	invalid := apperrors.ErrInvalidBatch
+	items := make([]batchItemResult, len(req.Expressions))
+	for i, item := range req.Expressions {
+		items[i] = batchItemResult{Index: i, Label: item.Label}
+		field := "expressions[" + strconv.Itoa(i) + "]"
+		if _, appErr := checkExpression(field, item.Expression); appErr != nil {
+			f := appErr.Fields[0]
+			invalid = invalid.WithFieldAt(f.Field, f.Message, f.Position)
+		} else if appErr := checkPriority(field+".priority", item.Priority); appErr != nil {
+			invalid = invalid.WithField(field+".priority", appErr.Fields[0].Message)
+		}
+	}
+	if len(invalid.Fields) > 0 {
+		apperrors.Write(w, r, invalid)
+		return
+	}
+	role, _ := r.Context().Value("role").(string)
+	var (
+		batchID string
+		ids     []string
+	)
+	appErr := withQuota(w, uid, role, len(req.Expressions), func() (err error) {
+		batchID, ids, err = insertBatch(r, uid, ws, req.Expressions)
+		return err
+	})
+	if appErr != nil {
+		apperrors.Write(w, r, appErr)
+		return
+	}
+	for i := range items {
+		items[i].ID = ids[i]
+	}
+	w.Header().Set("Location", "/api/v1/batches/"+batchID)
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(map[string]interface{}{"batch_id": batchID, "items": items})
+}
+
+// insertBatch сохраняет выражения одним пакетом в одной транзакции, пишет событие аудита
+// и передаёт их оркестратору. Возвращает id пакета и id выражений в порядке items.
+func insertBatch(r *http.Request, uid int, ws workspace, items []batchItem) (string, []string, error) {
+	batchID := uuid.NewString()
+	tx, err := db.Conn.Begin()
+	if err != nil {
+		return "", nil, err
+	}
+	defer tx.Rollback()
+	now := time.Now().UTC()
+	if _, err := tx.Exec(
+		"INSERT INTO batches(id, user_id, org_id, created_at) VALUES(?, ?, ?, ?)",
+		batchID, uid, ws.orgIDValue(), now,
+	); err != nil {
+		return "", nil, err
+	}
+	stmt, err := tx.Prepare(
+		"INSERT INTO expressions(id, user_id, org_id, expression, status, batch_id, label, priority, created_at) VALUES(?, ?, ?, ?, 'pending', ?, ?, ?, ?)",
+	)
+	if err != nil {
+		return "", nil, err
+	}
+	defer stmt.Close()
+	ids := make([]string, len(items))
+	for i, item := range items {
+		ids[i] = uuid.NewString()
+		if _, err := stmt.Exec(ids[i], uid, ws.orgIDValue(), item.Expression, batchID, item.Label, item.Priority, now); err != nil {
+			return "", nil, err
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		return "", nil, err
+	}
+	audit(r, uid, AuditBatchSubmit, batchID, map[string]interface{}{"count": len(items)})
+
+	if Orch != nil {
+		for i, item := range items {
+			Orch.SubmitPriority(ids[i], uid, item.Expression, item.Priority)
+		}
+	}
+	return batchID, ids, nil
+}
+
+// GetBatch — GET /api/v1/batches/{id}
+// Сводка по пакету: сколько выражений в каждом статусе и результаты по элементам.
+func GetBatch(w http.ResponseWriter, r *http.Request) {
+	uid := r.Context().Value("user_id").(int)
+	id := mux.Vars(r)["id"]
+
+	var (
+		owner   int
+		orgID   sql.NullInt64
+		created time.Time
+	)
+	err := db.Conn.QueryRow("SELECT user_id, org_id, created_at FROM batches WHERE id = ?", id).Scan(&owner, &orgID, &created)
+	if err == nil && !orgID.Valid && owner != uid {
+		err = sql.ErrNoRows
+	}
+	if err == nil && orgID.Valid && orgRole(int(orgID.Int64), uid) == "" {
+		err = sql.ErrNoRows
+	}
+	if err != nil {
+		apperrors.Write(w, r, apperrors.ErrNotFound)
+		return
+	}
+
+	rows, err := db.Conn.Query(
+		"SELECT id, label, status, result FROM expressions WHERE batch_id = ? ORDER BY rowid", id,
+	)
+	if err != nil {
+		apperrors.Write(w, r, apperrors.ErrInternalServer)
+		return
+	}
+	defer rows.Close()
+
+	counts := map[string]int{"pending": 0, "in_progress": 0, "done": 0, "error": 0, "cancelled": 0}
+	list := []map[string]interface{}{}
+	for rows.Next() {
+		var (
+			exprID, label, status string
+			res                   sql.NullFloat64
+		)
+		rows.Scan(&exprID, &label, &status, &res)
+		counts[status]++
+		item := map[string]interface{}{"id": exprID, "status": status}
+		if label != "" {
+			item["label"] = label
+		}
+		if res.Valid {
+			item["result"] = res.Float64
+		}
+		list = append(list, item)
+	}
+	finished := counts["done"] + counts["error"] + counts["cancelled"]
+	json.NewEncoder(w).Encode(map[string]interface{}{"batch": map[string]interface{}{
+		"id":         id,
+		"created_at": created,
+		"total":      len(list),
+		"counts":     counts,
+		"finished":   finished == len(list),
+		"items":      list,
+	}})
+}
@@ -5,125 +5,290 @@ import (
 	"database/sql"
 	"encoding/json"
 	"net/http"
+	"strconv"
+	"strings"
+	"time"
 
 	"github.com/google/uuid"
 	"github.com/gorilla/mux"
 
+	"github.com/scriptoxin/yandex-liceum-go-calc/internal/orchestrator"
 	"github.com/scriptoxin/yandex-liceum-go-calc/pkg/db"
+	apperrors "github.com/scriptoxin/yandex-liceum-go-calc/pkg/errors"
 	"github.com/scriptoxin/yandex-liceum-go-calc/pkg/jwt"
 )
 
+// Orch раздаёт задачи агентам; задаётся в main.
+var Orch *orchestrator.Orchestrator
+
+// calcMaxWait — предел ожидания результата в синхронном режиме Calculate.
+const calcMaxWait = time.Minute
+
 type calcRequest struct {
 	Expression string `json:"expression"`
+	// Priority — от -10 до 10, по умолчанию 0; упорядочивает выражения одного пользователя
+	Priority int `json:"priority"`
+	// CallbackURL — куда отправить итог (см. orchestrator.Webhooks)
+	CallbackURL string `json:"callback_url"`
 }
 
-// AuthMiddleware проверяет JWT и кладёт user_id в контекст
+// AuthMiddleware проверяет JWT (Authorization: Bearer ...) или API-ключ
+// (Authorization: ApiKey ...) и кладёт user_id и role в контекст.
+// Для открытия /ws JWT можно передать и подпротоколом (см. wsProtocolToken).
+// Запросы заблокированных пользователей и JWT, выпущенные до смены пароля, отклоняются.
 func AuthMiddleware(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		header := r.Header.Get("Authorization")
-		if len(header) < 8 || header[:7] != "Bearer " {
-			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		if token := wsProtocolToken(r); header == "" && token != "" {
+			header = "Bearer " + token
+		}
+		var (
+			uid     int
+			keyID   int
+			scope   string
+			version = -1
+		)
+		switch {
+		case strings.HasPrefix(header, "Bearer ") && len(header) > 7:
+			claims, err := jwt.Parse(header[7:])
+			if err != nil {
+				apperrors.Write(w, r, apperrors.ErrUnauthorized)
+				return
+			}
+			uid, version = claims.UserID, claims.Version
+		case strings.HasPrefix(header, "ApiKey ") && len(header) > 7:
+			var err error
+			uid, keyID, scope, err = authAPIKey(header[7:])
+			if err != nil {
+				apperrors.Write(w, r, apperrors.ErrUnauthorized)
+				return
+			}
+		default:
+			apperrors.Write(w, r, apperrors.ErrUnauthorized)
 			return
 		}
-		tokenStr := header[7:]
-		uid, err := jwt.Parse(tokenStr)
-		if err != nil {
-			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+
+		var (
+			role         string
+			disabled     bool
+			tokenVersion int
+		)
+		err := db.Conn.QueryRow(
+			"SELECT role, disabled, token_version FROM users WHERE id = ?", uid,
+		).Scan(&role, &disabled, &tokenVersion)
+		if err != nil || disabled || (version >= 0 && version != tokenVersion) {
+			apperrors.Write(w, r, apperrors.ErrUnauthorized)
 			return
 		}
 		ctx := context.WithValue(r.Context(), "user_id", uid)
+		if keyID != 0 {
+			role = scopeRole(scope, role)
+			ctx = context.WithValue(ctx, "api_key_id", keyID)
+		}
+		ctx = context.WithValue(ctx, "role", role)
 		next.ServeHTTP(w, r.WithContext(ctx))
 	})
 }
 
+// RequireRole пропускает запрос, только если роль из токена входит в roles.
+// Используется после AuthMiddleware.
+func RequireRole(roles ...string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			role, _ := r.Context().Value("role").(string)
+			for _, allowed := range roles {
+				if role == allowed {
+					next.ServeHTTP(w, r)
+					return
+				}
+			}
+			apperrors.Write(w, r, apperrors.ErrForbidden)
+		})
+	}
+}
+
 // Calculate — POST /api/v1/calculate
 // Генерируем UUID, сохраняем в SQLite, статус = pending
+// Выражение попадает в рабочее пространство из запроса (см. resolveWorkspace).
+// С ?wait=5s или Prefer: wait=5 ждёт результата: 200 с результатом,
+// а если не успели — 202 с id и заголовком Location.
+// Сверх ограничений пользователя (см. Quota) — 429.
 func Calculate(w http.ResponseWriter, r *http.Request) {
 	uid := r.Context().Value("user_id").(int)
+	ws, ok := resolveWorkspace(w, r)
+	if !ok {
+		return
+	}
+	wait, appErr := waitTimeout(r)
+	if appErr != nil {
+		apperrors.Write(w, r, appErr)
+		return
+	}
 
 	var req calcRequest
 	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-		http.Error(w, "Invalid request", http.StatusBadRequest)
+		apperrors.Write(w, r, apperrors.ErrBadRequest)
+		return
+	}
+	if _, appErr := checkExpression("expression", req.Expression); appErr != nil {
+		apperrors.Write(w, r, appErr)
 		return
 	}
+	if appErr := checkPriority("priority", req.Priority); appErr != nil {
+		apperrors.Write(w, r, appErr)
+		return
+	}
+	if req.CallbackURL != "" {
+		if appErr := checkCallbackURL("callback_url", req.CallbackURL); appErr != nil {
+			apperrors.Write(w, r, appErr)
+			return
+		}
+	}
+	role, _ := r.Context().Value("role").(string)
+	var id string
+	appErr = withQuota(w, uid, role, 1, func() (err error) {
+		id, err = insertExpression(r, uid, ws, req.Expression, req.Priority, req.CallbackURL)
+		return err
+	})
+	if appErr != nil {
+		apperrors.Write(w, r, appErr)
+		return
+	}
+
+	if Orch == nil {
+		json.NewEncoder(w).Encode(map[string]string{"id": id})
+		return
+	}
+	// Подписываемся до Submit: простое выражение может досчитаться сразу
+	var done <-chan struct{}
+	if wait > 0 {
+		var cancel func()
+		done, cancel = Orch.Done(id)
+		defer cancel()
+	}
+	// Разбиваем на задачи для агентов; ошибку разбора оркестратор сам запишет в БД
+	Orch.SubmitPriority(id, uid, req.Expression, req.Priority)
+	if wait == 0 {
+		json.NewEncoder(w).Encode(map[string]string{"id": id})
+		return
+	}
+
+	timer := time.NewTimer(wait)
+	defer timer.Stop()
+	select {
+	case <-done:
+		var (
+			status string
+			res    sql.NullFloat64
+		)
+		if err := db.Conn.QueryRow("SELECT status, result FROM expressions WHERE id = ?", id).Scan(&status, &res); err != nil {
+			apperrors.Write(w, r, apperrors.ErrInternalServer)
+			return
+		}
+		out := map[string]interface{}{"id": id, "status": status}
+		if res.Valid {
+			out["result"] = res.Float64
+		}
+		json.NewEncoder(w).Encode(out)
+	case <-timer.C:
+		w.Header().Set("Location", "/api/v1/expressions/"+id)
+		w.WriteHeader(http.StatusAccepted)
+		json.NewEncoder(w).Encode(map[string]string{"id": id})
+	case <-r.Context().Done():
+	}
+}
 
+// insertExpression сохраняет новое выражение со статусом pending и пишет событие аудита.
+// Оркестратору его передаёт вызывающий. callbackURL может быть пустым.
+func insertExpression(r *http.Request, uid int, ws workspace, expr string, priority int, callbackURL string) (string, error) {
 	// Генерируем уникальный ID задачи
 	id := uuid.NewString()
-
-	// Сохраняем в БД
+	var callback interface{}
+	if callbackURL != "" {
+		callback = callbackURL
+	}
 	_, err := db.Conn.Exec(
-		"INSERT INTO expressions(id, user_id, expression, status) VALUES(?, ?, ?, ?)",
-		id, uid, req.Expression, "pending",
+		"INSERT INTO expressions(id, user_id, org_id, expression, status, priority, callback_url, created_at) VALUES(?, ?, ?, ?, ?, ?, ?, ?)",
+		id, uid, ws.orgIDValue(), expr, "pending", priority, callback, time.Now().UTC(),
 	)
 	if err != nil {
-		http.Error(w, "Server error", http.StatusInternalServerError)
-		return
+		return "", err
 	}
+	audit(r, uid, AuditExprSubmit, id, nil)
+	return id, nil
+}
 
-	// TODO: отправить task по gRPC агентам
+// checkPriority проверяет, что приоритет в допустимых границах.
+func checkPriority(field string, priority int) *apperrors.AppError {
+	if priority < orchestrator.MinPriority || priority > orchestrator.MaxPriority {
+		return apperrors.ErrInvalidField.WithField(field, "must be from -10 to 10")
+	}
+	return nil
+}
 
-	json.NewEncoder(w).Encode(map[string]string{"id": id})
+// waitTimeout читает время ожидания из ?wait= (длительность, например 5s)
+// или из заголовка Prefer: wait=N (секунды, RFC 7240). Без них — 0.
+func waitTimeout(r *http.Request) (time.Duration, *apperrors.AppError) {
+	var wait time.Duration
+	if v := r.URL.Query().Get("wait"); v != "" {
+		d, err := time.ParseDuration(v)
+		if err != nil {
+			// допускаем и просто число секунд
+			n, nErr := strconv.Atoi(v)
+			if nErr != nil {
+				return 0, apperrors.ErrInvalidField.WithField("wait", "must be a duration, e.g. 5s")
+			}
+			d = time.Duration(n) * time.Second
+		}
+		wait = d
+	} else {
+		for _, pref := range strings.Split(r.Header.Get("Prefer"), ",") {
+			pref = strings.TrimSpace(pref)
+			if !strings.HasPrefix(pref, "wait=") {
+				continue
+			}
+			n, err := strconv.Atoi(strings.TrimPrefix(pref, "wait="))
+			if err != nil {
+				return 0, apperrors.ErrInvalidField.WithField("Prefer", "wait must be a number of seconds")
+			}
+			wait = time.Duration(n) * time.Second
+		}
+	}
+	if wait < 0 {
+		return 0, apperrors.ErrInvalidField.WithField("wait", "must not be negative")
+	}
+	if wait > calcMaxWait {
+		wait = calcMaxWait
+	}
+	return wait, nil
 }
 
 // GetExpressions — GET /api/v1/expressions
 func GetExpressions(w http.ResponseWriter, r *http.Request) {
-	uid := r.Context().Value("user_id").(int)
-
-	rows, err := db.Conn.Query(
-		"SELECT id, status, result FROM expressions WHERE user_id = ?",
-		uid,
-	)
-	if err != nil {
-		http.Error(w, "Server error", http.StatusInternalServerError)
+	ws, ok := resolveWorkspace(w, r)
+	if !ok {
 		return
 	}
-	defer rows.Close()
-
-	var list []map[string]interface{}
-	for rows.Next() {
-		var (
-			id     string
-			status string
-			res    sql.NullFloat64
-		)
-		rows.Scan(&id, &status, &res)
-		item := map[string]interface{}{
-			"id":     id,
-			"status": status,
-		}
-		if res.Valid {
-			item["result"] = res.Float64
-		}
-		list = append(list, item)
-	}
-	json.NewEncoder(w).Encode(map[string]interface{}{"expressions": list})
+	cond, args := ws.cond()
+	writeExpressions(w, r, cond, args...)
 }
 
 // GetExpression — GET /api/v1/expressions/{id}
 func GetExpression(w http.ResponseWriter, r *http.Request) {
-	uid := r.Context().Value("user_id").(int)
+	ws, ok := resolveWorkspace(w, r)
+	if !ok {
+		return
+	}
 	id := mux.Vars(r)["id"]
+	cond, args := ws.cond()
 
-	var (
-		expr   string
-		status string
-		res    sql.NullFloat64
-	)
-	err := db.Conn.QueryRow(
-		"SELECT expression, status, result FROM expressions WHERE id = ? AND user_id = ?",
-		id, uid,
-	).Scan(&expr, &status, &res)
+	out, err := scanExpression(db.Conn.QueryRow(
+		"SELECT "+expressionColumns+" FROM expressions WHERE id = ? AND "+cond,
+		append([]interface{}{id}, args...)...,
+	))
 	if err != nil {
-		http.Error(w, "Not found", http.StatusNotFound)
+		apperrors.Write(w, r, apperrors.ErrNotFound)
 		return
 	}
-
-	out := map[string]interface{}{
-		"id":     id,
-		"status": status,
-	}
-	if res.Valid {
-		out["result"] = res.Float64
-	}
 	json.NewEncoder(w).Encode(map[string]interface{}{"expression": out})
 }
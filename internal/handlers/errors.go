@@ -0,0 +1,23 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+
+	apperrors "github.com/scriptoxin/yandex-liceum-go-calc/pkg/errors"
+)
+
+// ListErrors — GET /api/v1/errors
+// Каталог кодов ошибок с HTTP-статусами и соответствующими кодами gRPC.
+func ListErrors(w http.ResponseWriter, r *http.Request) {
+	list := []map[string]interface{}{}
+	for _, e := range apperrors.Catalogue() {
+		list = append(list, map[string]interface{}{
+			"code":      e.Code,
+			"status":    e.Status,
+			"message":   e.Message,
+			"grpc_code": apperrors.GRPCCode(e.Status).String(),
+		})
+	}
+	json.NewEncoder(w).Encode(map[string]interface{}{"errors": list})
+}
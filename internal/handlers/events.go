@@ -0,0 +1,157 @@
+package handlers
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/gorilla/mux"
+
+	"github.com/scriptoxin/yandex-liceum-go-calc/internal/orchestrator"
+	"github.com/scriptoxin/yandex-liceum-go-calc/pkg/db"
+	apperrors "github.com/scriptoxin/yandex-liceum-go-calc/pkg/errors"
+)
+
+// sseHeartbeat — как часто в поток пишется комментарий, чтобы прокси не закрывали соединение.
+var sseHeartbeat = 15 * time.Second
+
+// StreamExpressions — GET /api/v1/expressions/stream
+// Server-Sent Events о смене статусов всех выражений рабочего пространства
+// (?workspace=, как у GET /expressions): личного или организации, включая
+// выражения других её участников.
+// Если пропущенные события по Last-Event-ID восстановить нельзя, первым
+// приходит событие reset: клиенту нужно перечитать список.
+func StreamExpressions(w http.ResponseWriter, r *http.Request) {
+	if Orch == nil {
+		apperrors.Write(w, r, apperrors.ErrNotFound)
+		return
+	}
+	ws, ok := resolveWorkspace(w, r)
+	if !ok {
+		return
+	}
+
+	events, cancel, complete := Orch.Bus.Subscribe(r.Header.Get("Last-Event-ID"), ws.matches)
+	defer cancel()
+
+	flusher, ok := startSSE(w, r)
+	if !ok {
+		return
+	}
+	if !complete {
+		fmt.Fprint(w, "event: reset\ndata: {}\n\n")
+		flusher.Flush()
+	}
+	serveSSE(w, r, flusher, events, false)
+}
+
+// matches сообщает, относится ли событие шины к выражению этого пространства.
+func (ws workspace) matches(e orchestrator.Event) bool {
+	if ws.orgID == 0 {
+		return e.OrgID == 0 && e.UserID == ws.userID
+	}
+	return e.OrgID == ws.orgID
+}
+
+// ExpressionEvents — GET /api/v1/expressions/{id}/events
+// Server-Sent Events об одном выражении. Первым приходит текущий статус
+// (кроме переподключения без потерь); поток закрывается после done или error.
+func ExpressionEvents(w http.ResponseWriter, r *http.Request) {
+	if Orch == nil {
+		apperrors.Write(w, r, apperrors.ErrNotFound)
+		return
+	}
+	ws, ok := resolveWorkspace(w, r)
+	if !ok {
+		return
+	}
+	id := mux.Vars(r)["id"]
+	cond, args := ws.cond()
+
+	// Подписываемся до чтения текущего статуса, чтобы не пропустить переход между ними
+	events, cancel, complete := Orch.Bus.Subscribe(r.Header.Get("Last-Event-ID"), func(e orchestrator.Event) bool {
+		return e.ExpressionID == id
+	})
+	defer cancel()
+
+	var (
+		status string
+		res    sql.NullFloat64
+	)
+	err := db.Conn.QueryRow(
+		"SELECT status, result FROM expressions WHERE id = ? AND "+cond,
+		append([]interface{}{id}, args...)...,
+	).Scan(&status, &res)
+	if err != nil {
+		apperrors.Write(w, r, apperrors.ErrNotFound)
+		return
+	}
+
+	flusher, ok := startSSE(w, r)
+	if !ok {
+		return
+	}
+	current := orchestrator.Event{ExpressionID: id, Status: status}
+	if res.Valid {
+		current.Result = &res.Float64
+	}
+	// Итоговый статус отдаём всегда: иначе переподключившийся клиент ждал бы вечно
+	if r.Header.Get("Last-Event-ID") == "" || !complete || current.Final() {
+		writeSSE(w, current)
+		flusher.Flush()
+		if current.Final() {
+			return
+		}
+	}
+	serveSSE(w, r, flusher, events, true)
+}
+
+// startSSE отправляет заголовки потока событий.
+func startSSE(w http.ResponseWriter, r *http.Request) (http.Flusher, bool) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		apperrors.Write(w, r, apperrors.ErrInternalServer.WithMessage("Streaming is not supported"))
+		return nil, false
+	}
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("X-Accel-Buffering", "no")
+	w.WriteHeader(http.StatusOK)
+	flusher.Flush()
+	return flusher, true
+}
+
+// serveSSE пишет события, пока клиент не отключится или шина не закроет канал.
+// С untilFinal поток завершается после первого итогового события.
+func serveSSE(w http.ResponseWriter, r *http.Request, flusher http.Flusher, events <-chan orchestrator.Event, untilFinal bool) {
+	heartbeat := time.NewTicker(sseHeartbeat)
+	defer heartbeat.Stop()
+	for {
+		select {
+		case e, ok := <-events:
+			if !ok {
+				return
+			}
+			writeSSE(w, e)
+			flusher.Flush()
+			if untilFinal && e.Final() {
+				return
+			}
+		case <-heartbeat.C:
+			fmt.Fprint(w, ": ping\n\n")
+			flusher.Flush()
+		case <-r.Context().Done():
+			return
+		}
+	}
+}
+
+func writeSSE(w http.ResponseWriter, e orchestrator.Event) {
+	data, _ := json.Marshal(e)
+	if e.ID != "" {
+		fmt.Fprintf(w, "id: %s\n", e.ID)
+	}
+	fmt.Fprintf(w, "event: status\ndata: %s\n\n", data)
+}
@@ -0,0 +1,372 @@
+package handlers
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"io"
+	"log"
+	"mime"
+	"net/http"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/scriptoxin/yandex-liceum-go-calc/pkg/db"
+	apperrors "github.com/scriptoxin/yandex-liceum-go-calc/pkg/errors"
+	"github.com/scriptoxin/yandex-liceum-go-calc/pkg/xlsx"
+)
+
+// exportColumns — столбцы выгрузки в CSV и XLSX. Импорт читает из них expression, label и priority,
+// так что выгрузку можно загрузить на другом сервере как есть.
+var exportColumns = []string{
+	"id", "expression", "label", "priority", "status", "result", "error",
+	"batch_id", "schedule_id", "created_at", "started_at", "finished_at",
+}
+
+// exportTypes — Content-Type выгрузки по формату.
+var exportTypes = map[string]string{
+	"csv":   "text/csv; charset=utf-8",
+	"jsonl": "application/x-ndjson",
+	"xlsx":  "application/vnd.openxmlformats-officedocument.spreadsheetml.sheet",
+}
+
+// Ограничения импорта: размер файла, число выражений и сколько ошибок попадёт в ответ.
+const (
+	maxImportSize   = 10 << 20
+	maxImportRows   = 10000
+	maxImportErrors = 100
+)
+
+// ExportExpressions — GET /api/v1/expressions/export?format=csv|jsonl|xlsx
+// Отдаёт все выражения рабочего пространства (по умолчанию CSV), старые сверху, потоком —
+// без страниц. Фильтры те же, что у списка: status, since, until, q.
+// В JSONL каждая строка — запись в том же виде, что и в GET /expressions/{id}.
+func ExportExpressions(w http.ResponseWriter, r *http.Request) {
+	ws, ok := resolveWorkspace(w, r)
+	if !ok {
+		return
+	}
+	format := r.URL.Query().Get("format")
+	if format == "" {
+		format = "csv"
+	}
+	contentType, ok := exportTypes[format]
+	if !ok {
+		apperrors.Write(w, r, apperrors.ErrInvalidField.WithField("format", "must be csv, jsonl or xlsx"))
+		return
+	}
+	cond, args := ws.cond()
+	where, args, appErr := expressionFilters(r, cond, args)
+	if appErr != nil {
+		apperrors.Write(w, r, appErr)
+		return
+	}
+	rows, err := db.Conn.Query("SELECT "+expressionColumns+" FROM expressions WHERE "+where+" ORDER BY created_at, rowid", args...)
+	if err != nil {
+		apperrors.Write(w, r, apperrors.ErrInternalServer)
+		return
+	}
+	defer rows.Close()
+
+	w.Header().Set("Content-Type", contentType)
+	w.Header().Set("Content-Disposition", `attachment; filename="expressions-`+time.Now().UTC().Format("20060102")+"."+format+`"`)
+
+	var (
+		writeRow func(map[string]interface{}) error
+		finish   = func() error { return nil }
+	)
+	switch format {
+	case "jsonl":
+		enc := json.NewEncoder(w)
+		writeRow = func(e map[string]interface{}) error { return enc.Encode(e) }
+	case "csv":
+		cw := csv.NewWriter(w)
+		cw.Write(exportColumns)
+		writeRow = func(e map[string]interface{}) error {
+			record := make([]string, len(exportColumns))
+			for i, c := range exportColumns {
+				record[i] = exportCell(e[c])
+			}
+			return cw.Write(record)
+		}
+		finish = func() error {
+			cw.Flush()
+			return cw.Error()
+		}
+	case "xlsx":
+		xw := xlsx.NewWriter(w, "expressions")
+		header := make([]interface{}, len(exportColumns))
+		for i, c := range exportColumns {
+			header[i] = c
+		}
+		xw.WriteRow(header...)
+		writeRow = func(e map[string]interface{}) error {
+			cells := make([]interface{}, len(exportColumns))
+			for i, c := range exportColumns {
+				cells[i] = e[c]
+			}
+			return xw.WriteRow(cells...)
+		}
+		finish = xw.Close
+	}
+
+	// Заголовки уже отправлены: ошибку посреди выгрузки остаётся только записать в лог
+	for rows.Next() {
+		e, err := scanExpression(rows)
+		if err == nil {
+			err = writeRow(e)
+		}
+		if err != nil {
+			log.Printf("export expressions: %v", err)
+			return
+		}
+	}
+	if err := finish(); err != nil {
+		log.Printf("export expressions: %v", err)
+	}
+}
+
+// formulaPrefixes — с этих символов Excel и LibreOffice начинают формулу.
+const formulaPrefixes = "=+-@\t\r"
+
+// exportCell — значение ячейки CSV: числа без лишних нулей, время в RFC 3339.
+// Текст, который табличный редактор принял бы за формулу (например, выражение "-2+3"
+// или метка "=HYPERLINK(...)"), экранируется апострофом; parseImportCSV его снимает.
+func exportCell(v interface{}) string {
+	switch v := v.(type) {
+	case nil:
+		return ""
+	case string:
+		return escapeFormula(v)
+	case float64:
+		return strconv.FormatFloat(v, 'g', -1, 64)
+	case int:
+		return strconv.Itoa(v)
+	case time.Time:
+		return v.UTC().Format(time.RFC3339Nano)
+	default:
+		b, _ := json.Marshal(v)
+		return escapeFormula(string(b))
+	}
+}
+
+// escapeFormula добавляет апостроф перед текстом, начинающимся с formulaPrefixes
+// или с самого апострофа — иначе unescapeFormula сняла бы его у значения вроде "'=1".
+func escapeFormula(s string) string {
+	if s != "" && strings.IndexByte("'"+formulaPrefixes, s[0]) >= 0 {
+		return "'" + s
+	}
+	return s
+}
+
+// unescapeFormula снимает апостроф, добавленный escapeFormula.
+func unescapeFormula(s string) string {
+	if len(s) > 1 && s[0] == '\'' && strings.IndexByte("'"+formulaPrefixes, s[1]) >= 0 {
+		return s[1:]
+	}
+	return s
+}
+
+// importLine — выражение из файла импорта и номер строки, на которой оно записано.
+type importLine struct {
+	batchItem
+	line int
+}
+
+// ImportExpressions — POST /api/v1/expressions/import
+// Принимает CSV (с заголовком, нужен столбец expression; label и priority — по желанию)
+// или JSONL ({"expression":"2+2","label":"a","priority":1} в каждой строке) — телом запроса
+// или файлом file в multipart/form-data. Формат берётся из ?format=, расширения файла
+// или Content-Type. Как и пакет, проверяется целиком: при ошибках — 422 с полями "line N",
+// иначе все выражения уходят на вычисление одним пакетом.
+func ImportExpressions(w http.ResponseWriter, r *http.Request) {
+	uid := r.Context().Value("user_id").(int)
+	ws, ok := resolveWorkspace(w, r)
+	if !ok {
+		return
+	}
+	data, format, appErr := readImport(w, r)
+	if appErr != nil {
+		apperrors.Write(w, r, appErr)
+		return
+	}
+	var lines []importLine
+	if format == "csv" {
+		lines, appErr = parseImportCSV(data)
+	} else {
+		lines, appErr = parseImportJSONL(data)
+	}
+	if appErr != nil {
+		apperrors.Write(w, r, appErr)
+		return
+	}
+	if len(lines) == 0 {
+		apperrors.Write(w, r, apperrors.ErrInvalidField.WithField("file", "contains no expressions"))
+		return
+	}
+	if len(lines) > maxImportRows {
+		apperrors.Write(w, r, apperrors.ErrInvalidField.WithField("file", "must contain at most 10000 expressions"))
+		return
+	}
+
+	invalid := apperrors.ErrInvalidBatch
+	items := make([]batchItem, len(lines))
+	for i, l := range lines {
+		items[i] = l.batchItem
+		if len(invalid.Fields) >= maxImportErrors {
+			break
+		}
+		field := "line " + strconv.Itoa(l.line)
+		if _, appErr := checkExpression(field, l.Expression); appErr != nil {
+			f := appErr.Fields[0]
+			invalid = invalid.WithFieldAt(f.Field, f.Message, f.Position)
+		} else if appErr := checkPriority(field, l.Priority); appErr != nil {
+			invalid = invalid.WithField(field, "priority "+appErr.Fields[0].Message)
+		}
+	}
+	if len(invalid.Fields) > 0 {
+		apperrors.Write(w, r, invalid)
+		return
+	}
+	role, _ := r.Context().Value("role").(string)
+	var batchID string
+	appErr = withQuota(w, uid, role, len(items), func() (err error) {
+		batchID, _, err = insertBatch(r, uid, ws, items)
+		return err
+	})
+	if appErr != nil {
+		apperrors.Write(w, r, appErr)
+		return
+	}
+	w.Header().Set("Location", "/api/v1/batches/"+batchID)
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(map[string]interface{}{"batch_id": batchID, "count": len(items)})
+}
+
+// readImport читает файл импорта (не больше maxImportSize) и определяет его формат.
+func readImport(w http.ResponseWriter, r *http.Request) ([]byte, string, *apperrors.AppError) {
+	r.Body = http.MaxBytesReader(w, r.Body, maxImportSize+1<<20)
+	format := r.URL.Query().Get("format")
+	mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+
+	var body io.Reader = r.Body
+	if mediaType == "multipart/form-data" {
+		file, header, err := r.FormFile("file")
+		if err != nil {
+			var tooLarge *http.MaxBytesError
+			if errors.As(err, &tooLarge) {
+				return nil, "", apperrors.ErrPayloadTooLarge
+			}
+			return nil, "", apperrors.ErrInvalidField.WithField("file", "is required")
+		}
+		defer file.Close()
+		body = file
+		if format == "" {
+			format = strings.TrimPrefix(strings.ToLower(path.Ext(header.Filename)), ".")
+		}
+		mediaType = header.Header.Get("Content-Type")
+	}
+	data, err := io.ReadAll(io.LimitReader(body, maxImportSize+1))
+	if err != nil || len(data) > maxImportSize {
+		return nil, "", apperrors.ErrPayloadTooLarge
+	}
+
+	switch {
+	case format == "csv" || format == "jsonl":
+	case format == "ndjson":
+		format = "jsonl"
+	case format != "" && format != "txt":
+		return nil, "", apperrors.ErrInvalidField.WithField("format", "must be csv or jsonl")
+	case strings.HasPrefix(mediaType, "text/csv"):
+		format = "csv"
+	case strings.Contains(mediaType, "ndjson") || strings.Contains(mediaType, "jsonl") || strings.Contains(mediaType, "json-lines"):
+		format = "jsonl"
+	case bytes.HasPrefix(bytes.TrimSpace(data), []byte("{")):
+		// по содержимому: JSONL начинается с объекта
+		format = "jsonl"
+	default:
+		format = "csv"
+	}
+	return data, format, nil
+}
+
+// parseImportCSV разбирает CSV с заголовком. Пустые строки пропускаются.
+func parseImportCSV(data []byte) ([]importLine, *apperrors.AppError) {
+	cr := csv.NewReader(bytes.NewReader(bytes.TrimPrefix(data, []byte("\ufeff"))))
+	cr.FieldsPerRecord = -1
+	header, err := cr.Read()
+	if err != nil {
+		return nil, apperrors.ErrInvalidField.WithField("file", "expected a CSV header with an expression column")
+	}
+	columns := map[string]int{}
+	for i, name := range header {
+		columns[strings.ToLower(strings.TrimSpace(name))] = i
+	}
+	exprCol, ok := columns["expression"]
+	if !ok {
+		return nil, apperrors.ErrInvalidField.WithField("file", "expected a CSV header with an expression column")
+	}
+	cell := func(record []string, name string) string {
+		if i, ok := columns[name]; ok && i < len(record) {
+			return strings.TrimSpace(unescapeFormula(record[i]))
+		}
+		return ""
+	}
+
+	var lines []importLine
+	for {
+		record, err := cr.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			var parseErr *csv.ParseError
+			if errors.As(err, &parseErr) {
+				return nil, apperrors.ErrInvalidField.WithField("line "+strconv.Itoa(parseErr.Line), parseErr.Err.Error())
+			}
+			return nil, apperrors.ErrInvalidField.WithField("file", err.Error())
+		}
+		line, _ := cr.FieldPos(0)
+		l := importLine{line: line}
+		if exprCol < len(record) {
+			l.Expression = unescapeFormula(record[exprCol])
+		}
+		l.Label = cell(record, "label")
+		if v := cell(record, "priority"); v != "" {
+			n, err := strconv.Atoi(v)
+			if err != nil {
+				return nil, apperrors.ErrInvalidField.WithField("line "+strconv.Itoa(line), "priority must be a number")
+			}
+			l.Priority = n
+		}
+		lines = append(lines, l)
+	}
+	return lines, nil
+}
+
+// parseImportJSONL разбирает JSONL: по объекту на строку, лишние поля (например,
+// status и result из выгрузки) не учитываются. Пустые строки пропускаются.
+func parseImportJSONL(data []byte) ([]importLine, *apperrors.AppError) {
+	sc := bufio.NewScanner(bytes.NewReader(data))
+	sc.Buffer(make([]byte, 64*1024), maxImportSize)
+	var lines []importLine
+	for n := 1; sc.Scan(); n++ {
+		text := bytes.TrimSpace(sc.Bytes())
+		if len(text) == 0 {
+			continue
+		}
+		l := importLine{line: n}
+		if err := json.Unmarshal(text, &l.batchItem); err != nil {
+			return nil, apperrors.ErrInvalidField.WithField("line "+strconv.Itoa(n), "invalid JSON")
+		}
+		lines = append(lines, l)
+	}
+	if err := sc.Err(); err != nil {
+		return nil, apperrors.ErrInvalidField.WithField("file", err.Error())
+	}
+	return lines, nil
+}
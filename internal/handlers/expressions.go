@@ -0,0 +1,138 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+
+	"github.com/gorilla/mux"
+
+	"github.com/scriptoxin/yandex-liceum-go-calc/internal/orchestrator"
+	"github.com/scriptoxin/yandex-liceum-go-calc/pkg/db"
+	apperrors "github.com/scriptoxin/yandex-liceum-go-calc/pkg/errors"
+)
+
+// CancelExpression — POST /api/v1/expressions/{id}/cancel
+// Отменить выражение может только его автор и только пока оно не досчитано.
+func CancelExpression(w http.ResponseWriter, r *http.Request) {
+	uid := r.Context().Value("user_id").(int)
+	id := mux.Vars(r)["id"]
+
+	status, ok := ownExpressionStatus(w, r, uid, id)
+	if !ok {
+		return
+	}
+	if status != orchestrator.StatusPending && status != orchestrator.StatusInProgress {
+		apperrors.Write(w, r, apperrors.ErrAlreadyFinished)
+		return
+	}
+	cancelExpression(id)
+	audit(r, uid, AuditExprCancel, id, nil)
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"expression": map[string]string{"id": id, "status": orchestrator.StatusCancelled},
+	})
+}
+
+// DeleteExpression — DELETE /api/v1/expressions/{id}
+// Удаляет выражение автора; если оно ещё считается, сначала отменяет.
+func DeleteExpression(w http.ResponseWriter, r *http.Request) {
+	uid := r.Context().Value("user_id").(int)
+	id := mux.Vars(r)["id"]
+
+	status, ok := ownExpressionStatus(w, r, uid, id)
+	if !ok {
+		return
+	}
+	if status == orchestrator.StatusPending || status == orchestrator.StatusInProgress {
+		cancelExpression(id)
+	}
+	db.Conn.Exec("DELETE FROM tasks WHERE expression_id = ?", id)
+	if _, err := db.Conn.Exec("DELETE FROM expressions WHERE id = ? AND user_id = ?", id, uid); err != nil {
+		apperrors.Write(w, r, apperrors.ErrInternalServer)
+		return
+	}
+	audit(r, uid, AuditExprDelete, id, nil)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// DeleteExpressions — DELETE /api/v1/expressions?status=done,error&batch_id=...&workspace=...
+// Удаляет выражения автора, подходящие под фильтр. Без фильтра ничего не удаляет:
+// чтобы очистить всё, передайте status со всеми статусами.
+func DeleteExpressions(w http.ResponseWriter, r *http.Request) {
+	uid := r.Context().Value("user_id").(int)
+	q := r.URL.Query()
+
+	conds := []string{"user_id = ?"}
+	args := []interface{}{uid}
+	if v := q.Get("status"); v != "" {
+		statuses := strings.Split(v, ",")
+		conds = append(conds, "status IN (?"+strings.Repeat(", ?", len(statuses)-1)+")")
+		for _, s := range statuses {
+			args = append(args, strings.TrimSpace(s))
+		}
+	}
+	if v := q.Get("batch_id"); v != "" {
+		conds = append(conds, "batch_id = ?")
+		args = append(args, v)
+	}
+	if v := q.Get("workspace"); v != "" {
+		ws, ok := resolveWorkspace(w, r)
+		if !ok {
+			return
+		}
+		conds = append(conds, "org_id IS ?")
+		args = append(args, ws.orgIDValue())
+	}
+	if len(conds) == 1 {
+		apperrors.Write(w, r, apperrors.ErrInvalidField.WithField("status", "at least one filter is required"))
+		return
+	}
+	where := strings.Join(conds, " AND ")
+
+	// Сначала останавливаем то, что ещё считается
+	rows, err := db.Conn.Query("SELECT id FROM expressions WHERE "+where+" AND status IN ('pending', 'in_progress')", args...)
+	if err != nil {
+		apperrors.Write(w, r, apperrors.ErrInternalServer)
+		return
+	}
+	var running []string
+	for rows.Next() {
+		var id string
+		rows.Scan(&id)
+		running = append(running, id)
+	}
+	rows.Close()
+	for _, id := range running {
+		cancelExpression(id)
+	}
+
+	db.Conn.Exec("DELETE FROM tasks WHERE expression_id IN (SELECT id FROM expressions WHERE "+where+")", args...)
+	res, err := db.Conn.Exec("DELETE FROM expressions WHERE "+where, args...)
+	if err != nil {
+		apperrors.Write(w, r, apperrors.ErrInternalServer)
+		return
+	}
+	n, _ := res.RowsAffected()
+	audit(r, uid, AuditExprDelete, "", map[string]interface{}{"filter": r.URL.RawQuery, "count": n})
+	json.NewEncoder(w).Encode(map[string]interface{}{"deleted": n})
+}
+
+// ownExpressionStatus возвращает статус выражения, если его автор — uid.
+// Чужие выражения (в том числе общие в организации) для этих действий не видны: 404.
+func ownExpressionStatus(w http.ResponseWriter, r *http.Request, uid int, id string) (string, bool) {
+	var status string
+	err := db.Conn.QueryRow("SELECT status FROM expressions WHERE id = ? AND user_id = ?", id, uid).Scan(&status)
+	if err != nil {
+		apperrors.Write(w, r, apperrors.ErrNotFound)
+		return "", false
+	}
+	return status, true
+}
+
+// cancelExpression останавливает выражение в оркестраторе, а если его там нет
+// (например, оркестратор не запущен) — просто меняет статус в БД.
+func cancelExpression(id string) {
+	if Orch == nil || !Orch.Cancel(id) {
+		orchestrator.MarkCancelled(id)
+	}
+}
@@ -0,0 +1,174 @@
+package handlers
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"errors"
+	"io"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/scriptoxin/yandex-liceum-go-calc/pkg/db"
+	apperrors "github.com/scriptoxin/yandex-liceum-go-calc/pkg/errors"
+)
+
+// IdempotencyTTL — сколько хранится ответ на запрос с Idempotency-Key; задаётся в main.
+var IdempotencyTTL = 24 * time.Hour
+
+// maxIdempotencyKey — предельная длина заголовка Idempotency-Key.
+const maxIdempotencyKey = 255
+
+// maxIdempotentBody — предельный размер тела запроса с Idempotency-Key:
+// тело читается целиком до обработчика, поэтому предел — самый большой
+// из маршрутов под Idempotent (импорт, см. readImport).
+const maxIdempotentBody = maxImportSize + 1<<20
+
+// Idempotent делает повторы запроса с тем же заголовком Idempotency-Key безопасными:
+// первый ответ сохраняется (для каждого пользователя отдельно) и отдаётся повторно
+// с заголовком Idempotent-Replayed: true. Тот же ключ с другим телом — 409.
+// Сохраняются только окончательные ответы (см. finalStatus), после остальных
+// ключ освобождается и запрос можно повторить.
+// Используется после AuthMiddleware.
+func Idempotent(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		key := r.Header.Get("Idempotency-Key")
+		if key == "" {
+			next.ServeHTTP(w, r)
+			return
+		}
+		if len(key) > maxIdempotencyKey {
+			apperrors.Write(w, r, apperrors.ErrInvalidField.WithField("Idempotency-Key", "must be at most 255 characters"))
+			return
+		}
+		uid := r.Context().Value("user_id").(int)
+
+		body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, maxIdempotentBody))
+		if err != nil {
+			var tooLarge *http.MaxBytesError
+			if errors.As(err, &tooLarge) {
+				apperrors.Write(w, r, apperrors.ErrPayloadTooLarge)
+				return
+			}
+			apperrors.Write(w, r, apperrors.ErrBadRequest)
+			return
+		}
+		r.Body = io.NopCloser(bytes.NewReader(body))
+		fingerprint := requestFingerprint(r, body)
+
+		// Ключ занимается вставкой строки: из параллельных запросов пройдёт один
+		now := time.Now().UTC()
+		db.Conn.Exec("DELETE FROM idempotency_keys WHERE user_id = ? AND key = ? AND created_at < ?", uid, key, now.Add(-IdempotencyTTL))
+		res, err := db.Conn.Exec(
+			"INSERT OR IGNORE INTO idempotency_keys(user_id, key, fingerprint, created_at) VALUES(?, ?, ?, ?)",
+			uid, key, fingerprint, now,
+		)
+		if err != nil {
+			apperrors.Write(w, r, apperrors.ErrInternalServer)
+			return
+		}
+		if n, _ := res.RowsAffected(); n == 0 {
+			replayIdempotent(w, r, uid, key, fingerprint)
+			return
+		}
+
+		rec := &responseRecorder{ResponseWriter: w, status: http.StatusOK}
+		next.ServeHTTP(rec, r)
+
+		if !finalStatus(rec.status) {
+			db.Conn.Exec("DELETE FROM idempotency_keys WHERE user_id = ? AND key = ?", uid, key)
+			return
+		}
+		_, err = db.Conn.Exec(
+			"UPDATE idempotency_keys SET status = ?, content_type = ?, location = ?, body = ? WHERE user_id = ? AND key = ?",
+			rec.status, w.Header().Get("Content-Type"), w.Header().Get("Location"), rec.body.Bytes(), uid, key,
+		)
+		if err != nil {
+			log.Printf("idempotency %d/%s: %v", uid, key, err)
+		}
+	})
+}
+
+// finalStatus сообщает, окончателен ли ответ: успех или ошибка в самом запросе,
+// которая повторится при повторе. 409, 429 и 5xx зависят от состояния сервера
+// и после них запрос с тем же ключом должен выполниться заново.
+func finalStatus(status int) bool {
+	switch status {
+	case http.StatusBadRequest, http.StatusRequestEntityTooLarge, http.StatusUnprocessableEntity:
+		return true
+	}
+	return status >= 200 && status < 300
+}
+
+// replayIdempotent отдаёт сохранённый ответ на запрос с уже известным ключом.
+func replayIdempotent(w http.ResponseWriter, r *http.Request, uid int, key, fingerprint string) {
+	var (
+		saved, contentType, location string
+		status                       int
+		body                         []byte
+	)
+	err := db.Conn.QueryRow(
+		"SELECT fingerprint, status, content_type, location, body FROM idempotency_keys WHERE user_id = ? AND key = ?",
+		uid, key,
+	).Scan(&saved, &status, &contentType, &location, &body)
+	switch {
+	case err == sql.ErrNoRows:
+		// первый запрос только что завершился неокончательным ответом и освободил ключ
+		apperrors.Write(w, r, apperrors.ErrIdempotencyInProgress)
+	case err != nil:
+		apperrors.Write(w, r, apperrors.ErrInternalServer)
+	case saved != fingerprint:
+		apperrors.Write(w, r, apperrors.ErrIdempotencyMismatch)
+	case status == 0:
+		apperrors.Write(w, r, apperrors.ErrIdempotencyInProgress)
+	default:
+		if contentType != "" {
+			w.Header().Set("Content-Type", contentType)
+		}
+		if location != "" {
+			w.Header().Set("Location", location)
+		}
+		w.Header().Set("Idempotent-Replayed", "true")
+		w.WriteHeader(status)
+		w.Write(body)
+	}
+}
+
+// requestFingerprint — хеш метода, пути, рабочего пространства и тела запроса.
+func requestFingerprint(r *http.Request, body []byte) string {
+	ws := r.URL.Query().Get("workspace")
+	if ws == "" {
+		ws = r.Header.Get("X-Workspace")
+	}
+	h := sha256.New()
+	io.WriteString(h, strings.Join([]string{r.Method, r.URL.Path, ws}, "\n")+"\n")
+	h.Write(body)
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// PurgeIdempotencyKeys удаляет ключи старше IdempotencyTTL.
+func PurgeIdempotencyKeys() {
+	if _, err := db.Conn.Exec("DELETE FROM idempotency_keys WHERE created_at < ?", time.Now().UTC().Add(-IdempotencyTTL)); err != nil {
+		log.Printf("purge idempotency keys: %v", err)
+	}
+}
+
+// responseRecorder пропускает ответ клиенту и запоминает статус и тело.
+type responseRecorder struct {
+	http.ResponseWriter
+	status int
+	body   bytes.Buffer
+}
+
+func (rec *responseRecorder) WriteHeader(status int) {
+	rec.status = status
+	rec.ResponseWriter.WriteHeader(status)
+}
+
+func (rec *responseRecorder) Write(b []byte) (int, error) {
+	rec.body.Write(b)
+	return rec.ResponseWriter.Write(b)
+}
@@ -0,0 +1,266 @@
+package handlers
+
+import (
+	"database/sql"
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/scriptoxin/yandex-liceum-go-calc/pkg/db"
+	apperrors "github.com/scriptoxin/yandex-liceum-go-calc/pkg/errors"
+)
+
+// Размер страницы списка выражений: по умолчанию и предельный.
+const (
+	defaultPageSize = 50
+	maxPageSize     = 200
+)
+
+// expressionColumns — столбцы, которые читает scanExpression.
+const expressionColumns = "id, expression, status, result, error, label, batch_id, " +
+	"created_at, started_at, finished_at, task_count, compute_ms, agents, cached, priority, " +
+	"schedule_id, scheduled_for"
+
+// sortColumns — по каким полям можно сортировать список выражений.
+var sortColumns = map[string]string{
+	"created_at":    "created_at",
+	"finished_at":   "finished_at",
+	"scheduled_for": "scheduled_for",
+}
+
+// rowScanner — общее у *sql.Row и *sql.Rows.
+type rowScanner interface {
+	Scan(dest ...interface{}) error
+}
+
+// scanExpression читает полную запись выражения (expressionColumns).
+// Для досчитанных добавляет duration_ms (от создания до итога),
+// для взятых в работу — queue_ms (сколько выражение ждало первого агента).
+func scanExpression(row rowScanner, extra ...interface{}) (map[string]interface{}, error) {
+	var (
+		id, expr, status, label    string
+		res                        sql.NullFloat64
+		errText, batchID, agents   sql.NullString
+		scheduleID                 sql.NullString
+		created, started, finished sql.NullTime
+		scheduledFor               sql.NullTime
+		tasks                      int
+		computeMS                  int64
+		cached                     bool
+		priority                   int
+	)
+	dest := append([]interface{}{
+		&id, &expr, &status, &res, &errText, &label, &batchID,
+		&created, &started, &finished, &tasks, &computeMS, &agents, &cached, &priority,
+		&scheduleID, &scheduledFor,
+	}, extra...)
+	if err := row.Scan(dest...); err != nil {
+		return nil, err
+	}
+	out := map[string]interface{}{
+		"id":         id,
+		"expression": expr,
+		"status":     status,
+		"task_count": tasks,
+		"compute_ms": computeMS,
+		"agents":     []string{},
+		"cached":     cached,
+		"priority":   priority,
+	}
+	if res.Valid {
+		out["result"] = res.Float64
+	}
+	if errText.Valid {
+		out["error"] = errText.String
+	}
+	if label != "" {
+		out["label"] = label
+	}
+	if batchID.Valid {
+		out["batch_id"] = batchID.String
+	}
+	if scheduleID.Valid {
+		out["schedule_id"] = scheduleID.String
+		out["scheduled_for"] = scheduledFor.Time
+	}
+	if created.Valid {
+		out["created_at"] = created.Time
+	}
+	if started.Valid {
+		out["started_at"] = started.Time
+		if created.Valid {
+			out["queue_ms"] = started.Time.Sub(created.Time).Milliseconds()
+		}
+	}
+	if agents.Valid {
+		var list []string
+		if json.Unmarshal([]byte(agents.String), &list) == nil {
+			out["agents"] = list
+		}
+	}
+	if finished.Valid {
+		out["finished_at"] = finished.Time
+		if created.Valid {
+			out["duration_ms"] = finished.Time.Sub(created.Time).Milliseconds()
+		}
+	}
+	return out, nil
+}
+
+// listCursor — позиция в списке: значение поля сортировки и id последней выданной записи.
+type listCursor struct {
+	Sort string `json:"s"`
+	Key  string `json:"k"`
+	ID   string `json:"id"`
+}
+
+func (c listCursor) encode() string {
+	b, _ := json.Marshal(c)
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+func decodeCursor(s string) (listCursor, bool) {
+	var c listCursor
+	b, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil || json.Unmarshal(b, &c) != nil {
+		return c, false
+	}
+	return c, true
+}
+
+// expressionFilters добавляет к условию cond фильтры из запроса: status (через запятую),
+// since и until (RFC 3339, по времени создания), q (поиск по тексту и метке).
+func expressionFilters(r *http.Request, cond string, args []interface{}) (string, []interface{}, *apperrors.AppError) {
+	q := r.URL.Query()
+	conds := []string{cond}
+
+	if v := q.Get("status"); v != "" {
+		statuses := strings.Split(v, ",")
+		conds = append(conds, "status IN (?"+strings.Repeat(", ?", len(statuses)-1)+")")
+		for _, s := range statuses {
+			args = append(args, strings.TrimSpace(s))
+		}
+	}
+	for param, op := range map[string]string{"since": ">=", "until": "<"} {
+		if v := q.Get(param); v != "" {
+			t, err := time.Parse(time.RFC3339, v)
+			if err != nil {
+				return "", nil, apperrors.ErrInvalidField.WithField(param, "expected RFC 3339 time")
+			}
+			conds = append(conds, "created_at "+op+" ?")
+			args = append(args, t.UTC())
+		}
+	}
+	if v := q.Get("q"); v != "" {
+		pattern := "%" + strings.NewReplacer(`\`, `\\`, "%", `\%`, "_", `\_`).Replace(v) + "%"
+		conds = append(conds, `(expression LIKE ? ESCAPE '\' OR label LIKE ? ESCAPE '\')`)
+		args = append(args, pattern, pattern)
+	}
+	return strings.Join(conds, " AND "), args, nil
+}
+
+// writeExpressions отдаёт страницу выражений, подходящих под условие cond и фильтры
+// из запроса (см. expressionFilters). Ещё параметры: sort (created_at, finished_at,
+// scheduled_for; с минусом — по убыванию), limit и cursor (next_cursor из предыдущего ответа).
+// В ответе также total и counts — сколько всего записей подходит под фильтры.
+func writeExpressions(w http.ResponseWriter, r *http.Request, cond string, args ...interface{}) {
+	q := r.URL.Query()
+	where, args, appErr := expressionFilters(r, cond, args)
+	if appErr != nil {
+		apperrors.Write(w, r, appErr)
+		return
+	}
+
+	sort := q.Get("sort")
+	if sort == "" {
+		sort = "-created_at"
+	}
+	column, ok := sortColumns[strings.TrimPrefix(sort, "-")]
+	if !ok {
+		apperrors.Write(w, r, apperrors.ErrInvalidField.WithField("sort", "must be created_at, finished_at or scheduled_for, optionally with -"))
+		return
+	}
+	dir, cmp := "ASC", ">"
+	if strings.HasPrefix(sort, "-") {
+		dir, cmp = "DESC", "<"
+	}
+	limit := defaultPageSize
+	if v := q.Get("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n <= 0 {
+			apperrors.Write(w, r, apperrors.ErrInvalidField.WithField("limit", "must be a positive number"))
+			return
+		}
+		limit = min(n, maxPageSize)
+	}
+
+	// Итоги по статусам считаются по фильтрам, без учёта курсора
+	counts := map[string]int{}
+	total := 0
+	rows, err := db.Conn.Query("SELECT status, COUNT(*) FROM expressions WHERE "+where+" GROUP BY status", args...)
+	if err != nil {
+		apperrors.Write(w, r, apperrors.ErrInternalServer)
+		return
+	}
+	for rows.Next() {
+		var (
+			status string
+			n      int
+		)
+		rows.Scan(&status, &n)
+		counts[status] = n
+		total += n
+	}
+	rows.Close()
+
+	// NULL (например, ещё не досчитанные при сортировке по finished_at) идут как пустая строка
+	key := "COALESCE(" + column + ", '')"
+	pageWhere, pageArgs := where, args
+	if v := q.Get("cursor"); v != "" {
+		c, ok := decodeCursor(v)
+		if !ok || c.Sort != sort {
+			apperrors.Write(w, r, apperrors.ErrInvalidField.WithField("cursor", "is invalid or was issued for another sort"))
+			return
+		}
+		pageWhere += " AND (" + key + " " + cmp + " ? OR (" + key + " = ? AND id " + cmp + " ?))"
+		pageArgs = append(append([]interface{}{}, args...), c.Key, c.Key, c.ID)
+	}
+	rows, err = db.Conn.Query(
+		"SELECT "+expressionColumns+", "+key+" FROM expressions WHERE "+pageWhere+
+			" ORDER BY "+key+" "+dir+", id "+dir+" LIMIT "+strconv.Itoa(limit+1),
+		pageArgs...,
+	)
+	if err != nil {
+		apperrors.Write(w, r, apperrors.ErrInternalServer)
+		return
+	}
+	defer rows.Close()
+
+	list := []map[string]interface{}{}
+	var keys []string
+	for rows.Next() {
+		var sortKey string
+		item, err := scanExpression(rows, &sortKey)
+		if err != nil {
+			apperrors.Write(w, r, apperrors.ErrInternalServer)
+			return
+		}
+		list = append(list, item)
+		keys = append(keys, sortKey)
+	}
+
+	out := map[string]interface{}{
+		"total":  total,
+		"counts": counts,
+	}
+	// Лишняя запись означает, что есть следующая страница
+	if len(list) > limit {
+		list = list[:limit]
+		out["next_cursor"] = listCursor{Sort: sort, Key: keys[limit-1], ID: list[limit-1]["id"].(string)}.encode()
+	}
+	out["expressions"] = list
+	json.NewEncoder(w).Encode(out)
+}
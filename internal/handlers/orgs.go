@@ -0,0 +1,354 @@
+package handlers
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gorilla/mux"
+
+	"github.com/scriptoxin/yandex-liceum-go-calc/pkg/db"
+	apperrors "github.com/scriptoxin/yandex-liceum-go-calc/pkg/errors"
+)
+
+// Роли участников организации. owner и admin управляют составом.
+const (
+	OrgOwner  = "owner"
+	OrgAdmin  = "admin"
+	OrgMember = "member"
+)
+
+// invitationTTL — срок действия кода приглашения по умолчанию.
+const invitationTTL = 7 * 24 * time.Hour
+
+type orgRequest struct {
+	Name string `json:"name"`
+}
+
+type invitationRequest struct {
+	Role      string `json:"role"`
+	ExpiresIn string `json:"expires_in"`
+}
+
+type acceptRequest struct {
+	Code string `json:"code"`
+}
+
+// workspace — пространство, в котором выполняется запрос:
+// личное (orgID == 0) или общее пространство организации.
+type workspace struct {
+	userID int
+	orgID  int
+}
+
+// cond возвращает условие WHERE для выражений этого пространства.
+func (ws workspace) cond() (string, []interface{}) {
+	if ws.orgID == 0 {
+		return "user_id = ? AND org_id IS NULL", []interface{}{ws.userID}
+	}
+	return "org_id = ?", []interface{}{ws.orgID}
+}
+
+// orgIDValue — значение столбца org_id: NULL для личного пространства.
+func (ws workspace) orgIDValue() interface{} {
+	if ws.orgID == 0 {
+		return nil
+	}
+	return ws.orgID
+}
+
+// resolveWorkspace берёт пространство из параметра ?workspace= или заголовка
+// X-Workspace (id организации). Без них — личное пространство.
+// Если пользователь не состоит в организации, отвечает 403 и возвращает false.
+func resolveWorkspace(w http.ResponseWriter, r *http.Request) (workspace, bool) {
+	ws := workspace{userID: r.Context().Value("user_id").(int)}
+	value := r.URL.Query().Get("workspace")
+	if value == "" {
+		value = r.Header.Get("X-Workspace")
+	}
+	if value == "" || value == "personal" {
+		return ws, true
+	}
+	orgID, err := strconv.Atoi(value)
+	if err != nil || orgRole(orgID, ws.userID) == "" {
+		apperrors.Write(w, r, apperrors.ErrNotAMember)
+		return ws, false
+	}
+	ws.orgID = orgID
+	return ws, true
+}
+
+// orgRole возвращает роль пользователя в организации или пустую строку.
+func orgRole(orgID, uid int) string {
+	var role string
+	db.Conn.QueryRow("SELECT role FROM org_members WHERE org_id = ? AND user_id = ?", orgID, uid).Scan(&role)
+	return role
+}
+
+// CreateOrg — POST /api/v1/orgs
+// Создатель становится владельцем.
+func CreateOrg(w http.ResponseWriter, r *http.Request) {
+	uid := r.Context().Value("user_id").(int)
+
+	var req orgRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		apperrors.Write(w, r, apperrors.ErrBadRequest)
+		return
+	}
+	if req.Name == "" {
+		apperrors.Write(w, r, apperrors.ErrInvalidField.WithField("name", "is required"))
+		return
+	}
+	tx, err := db.Conn.Begin()
+	if err != nil {
+		apperrors.Write(w, r, apperrors.ErrInternalServer)
+		return
+	}
+	defer tx.Rollback()
+	res, err := tx.Exec("INSERT INTO organizations(name) VALUES(?)", req.Name)
+	if err != nil {
+		apperrors.Write(w, r, apperrors.ErrInternalServer)
+		return
+	}
+	id, _ := res.LastInsertId()
+	if _, err := tx.Exec("INSERT INTO org_members(org_id, user_id, role) VALUES(?, ?, ?)", id, uid, OrgOwner); err != nil {
+		apperrors.Write(w, r, apperrors.ErrInternalServer)
+		return
+	}
+	if err := tx.Commit(); err != nil {
+		apperrors.Write(w, r, apperrors.ErrInternalServer)
+		return
+	}
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(map[string]interface{}{"id": id, "name": req.Name, "role": OrgOwner})
+}
+
+// ListOrgs — GET /api/v1/orgs
+func ListOrgs(w http.ResponseWriter, r *http.Request) {
+	uid := r.Context().Value("user_id").(int)
+
+	rows, err := db.Conn.Query(
+		`SELECT o.id, o.name, m.role FROM organizations o
+		 JOIN org_members m ON m.org_id = o.id
+		 WHERE m.user_id = ? ORDER BY o.id`,
+		uid,
+	)
+	if err != nil {
+		apperrors.Write(w, r, apperrors.ErrInternalServer)
+		return
+	}
+	defer rows.Close()
+
+	list := []map[string]interface{}{}
+	for rows.Next() {
+		var (
+			id         int
+			name, role string
+		)
+		rows.Scan(&id, &name, &role)
+		list = append(list, map[string]interface{}{"id": id, "name": name, "role": role})
+	}
+	json.NewEncoder(w).Encode(map[string]interface{}{"orgs": list})
+}
+
+// ListOrgMembers — GET /api/v1/orgs/{id}/members
+func ListOrgMembers(w http.ResponseWriter, r *http.Request) {
+	orgID, ok := orgFromPath(w, r, OrgOwner, OrgAdmin, OrgMember)
+	if !ok {
+		return
+	}
+	rows, err := db.Conn.Query(
+		`SELECT u.id, u.login, m.role FROM org_members m
+		 JOIN users u ON u.id = m.user_id
+		 WHERE m.org_id = ? ORDER BY u.id`,
+		orgID,
+	)
+	if err != nil {
+		apperrors.Write(w, r, apperrors.ErrInternalServer)
+		return
+	}
+	defer rows.Close()
+
+	list := []map[string]interface{}{}
+	for rows.Next() {
+		var (
+			id          int
+			login, role string
+		)
+		rows.Scan(&id, &login, &role)
+		list = append(list, map[string]interface{}{"id": id, "login": login, "role": role})
+	}
+	json.NewEncoder(w).Encode(map[string]interface{}{"members": list})
+}
+
+// RemoveOrgMember — DELETE /api/v1/orgs/{id}/members/{user_id}
+// Участник может выйти сам; исключать участников могут owner и admin,
+// администраторов — только owner. Владельца исключить нельзя.
+func RemoveOrgMember(w http.ResponseWriter, r *http.Request) {
+	uid := r.Context().Value("user_id").(int)
+	target, err := strconv.Atoi(mux.Vars(r)["user_id"])
+	if err != nil {
+		apperrors.Write(w, r, apperrors.ErrNotFound)
+		return
+	}
+	roles := []string{OrgOwner, OrgAdmin}
+	if target == uid {
+		roles = append(roles, OrgMember)
+	}
+	orgID, ok := orgFromPath(w, r, roles...)
+	if !ok {
+		return
+	}
+	switch orgRole(orgID, target) {
+	case OrgOwner:
+		apperrors.Write(w, r, apperrors.ErrOwnerCannotLeave)
+		return
+	case OrgAdmin:
+		if target != uid && orgRole(orgID, uid) != OrgOwner {
+			apperrors.Write(w, r, apperrors.ErrForbidden)
+			return
+		}
+	}
+	res, err := db.Conn.Exec("DELETE FROM org_members WHERE org_id = ? AND user_id = ?", orgID, target)
+	if err != nil {
+		apperrors.Write(w, r, apperrors.ErrInternalServer)
+		return
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		apperrors.Write(w, r, apperrors.ErrNotFound)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// CreateInvitation — POST /api/v1/orgs/{id}/invitations
+// Возвращает одноразовый код; в БД хранится только его хеш.
+func CreateInvitation(w http.ResponseWriter, r *http.Request) {
+	uid := r.Context().Value("user_id").(int)
+	orgID, ok := orgFromPath(w, r, OrgOwner, OrgAdmin)
+	if !ok {
+		return
+	}
+	var req invitationRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		apperrors.Write(w, r, apperrors.ErrBadRequest)
+		return
+	}
+	if req.Role == "" {
+		req.Role = OrgMember
+	}
+	if req.Role != OrgMember && req.Role != OrgAdmin {
+		apperrors.Write(w, r, apperrors.ErrInvalidField.WithField("role", "must be member or admin"))
+		return
+	}
+	ttl := invitationTTL
+	if req.ExpiresIn != "" {
+		d, err := time.ParseDuration(req.ExpiresIn)
+		if err != nil || d <= 0 {
+			apperrors.Write(w, r, apperrors.ErrInvalidField.WithField("expires_in", "must be a positive duration, e.g. 48h"))
+			return
+		}
+		ttl = d
+	}
+
+	buf := make([]byte, 16)
+	if _, err := rand.Read(buf); err != nil {
+		apperrors.Write(w, r, apperrors.ErrInternalServer)
+		return
+	}
+	code := hex.EncodeToString(buf)
+	expiresAt := time.Now().Add(ttl).UTC()
+	_, err := db.Conn.Exec(
+		"INSERT INTO invitations(org_id, hash, role, created_by, expires_at) VALUES(?, ?, ?, ?, ?)",
+		orgID, hashInvitation(code), req.Role, uid, expiresAt,
+	)
+	if err != nil {
+		apperrors.Write(w, r, apperrors.ErrInternalServer)
+		return
+	}
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(map[string]interface{}{"code": code, "role": req.Role, "expires_at": expiresAt})
+}
+
+// AcceptInvitation — POST /api/v1/invitations/accept
+func AcceptInvitation(w http.ResponseWriter, r *http.Request) {
+	uid := r.Context().Value("user_id").(int)
+
+	var req acceptRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		apperrors.Write(w, r, apperrors.ErrBadRequest)
+		return
+	}
+	tx, err := db.Conn.Begin()
+	if err != nil {
+		apperrors.Write(w, r, apperrors.ErrInternalServer)
+		return
+	}
+	defer tx.Rollback()
+
+	var (
+		orgID     int
+		role      string
+		expiresAt time.Time
+	)
+	// Код гасится тем же запросом, которым читается: второй раз он не сработает
+	err = tx.QueryRow(
+		`UPDATE invitations SET used_by = ?, used_at = CURRENT_TIMESTAMP
+		 WHERE hash = ? AND used_by IS NULL RETURNING org_id, role, expires_at`,
+		uid, hashInvitation(req.Code),
+	).Scan(&orgID, &role, &expiresAt)
+	if err == sql.ErrNoRows || (err == nil && expiresAt.Before(time.Now())) {
+		apperrors.Write(w, r, apperrors.ErrInvalidInvitation)
+		return
+	}
+	if err != nil {
+		apperrors.Write(w, r, apperrors.ErrInternalServer)
+		return
+	}
+	if orgRole(orgID, uid) != "" {
+		apperrors.Write(w, r, apperrors.ErrAlreadyMember)
+		return
+	}
+	if _, err := tx.Exec("INSERT INTO org_members(org_id, user_id, role) VALUES(?, ?, ?)", orgID, uid, role); err != nil {
+		apperrors.Write(w, r, apperrors.ErrInternalServer)
+		return
+	}
+	if err := tx.Commit(); err != nil {
+		apperrors.Write(w, r, apperrors.ErrInternalServer)
+		return
+	}
+	json.NewEncoder(w).Encode(map[string]interface{}{"org_id": orgID, "role": role})
+}
+
+// orgFromPath достаёт {id} организации из пути и проверяет, что роль
+// текущего пользователя в ней входит в roles.
+func orgFromPath(w http.ResponseWriter, r *http.Request, roles ...string) (int, bool) {
+	uid := r.Context().Value("user_id").(int)
+	orgID, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		apperrors.Write(w, r, apperrors.ErrNotFound)
+		return 0, false
+	}
+	role := orgRole(orgID, uid)
+	if role == "" {
+		apperrors.Write(w, r, apperrors.ErrNotFound)
+		return 0, false
+	}
+	for _, allowed := range roles {
+		if role == allowed {
+			return orgID, true
+		}
+	}
+	apperrors.Write(w, r, apperrors.ErrForbidden.WithMessage("Not enough rights in this organization"))
+	return 0, false
+}
+
+func hashInvitation(code string) string {
+	sum := sha256.Sum256([]byte(code))
+	return hex.EncodeToString(sum[:])
+}
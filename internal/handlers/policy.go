@@ -0,0 +1,225 @@
+package handlers
+
+import (
+	"net"
+	"net/http"
+	"os"
+	"regexp"
+	"strconv"
+	"sync"
+	"time"
+	"unicode"
+
+	apperrors "github.com/scriptoxin/yandex-liceum-go-calc/pkg/errors"
+)
+
+// AuthPolicy — правила для логинов, паролей, блокировки и частоты запросов.
+type AuthPolicy struct {
+	LoginPattern      *regexp.Regexp
+	PasswordMinLength int
+	// PasswordMixed требует в пароле и буквы, и цифры.
+	PasswordMixed bool
+
+	// После LockoutThreshold неудачных входов подряд аккаунт блокируется на
+	// LockoutBase, и каждая следующая ошибка удваивает срок, но не больше LockoutMax.
+	LockoutThreshold int
+	LockoutBase      time.Duration
+	LockoutMax       time.Duration
+
+	// С одного IP не больше ThrottleRequests запросов на /login и /register за ThrottleWindow.
+	ThrottleRequests int
+	ThrottleWindow   time.Duration
+}
+
+// Policy — действующие правила; main загружает их из окружения.
+var Policy = DefaultAuthPolicy()
+
+// DefaultAuthPolicy возвращает правила по умолчанию.
+func DefaultAuthPolicy() AuthPolicy {
+	return AuthPolicy{
+		LoginPattern:      regexp.MustCompile(`^[A-Za-z0-9_.-]{3,32}$`),
+		PasswordMinLength: 8,
+		PasswordMixed:     true,
+		LockoutThreshold:  5,
+		LockoutBase:       30 * time.Second,
+		LockoutMax:        time.Hour,
+		ThrottleRequests:  20,
+		ThrottleWindow:    time.Minute,
+	}
+}
+
+// LoadAuthPolicy читает правила из переменных окружения, остальное берёт по умолчанию:
+// LOGIN_PATTERN, PASSWORD_MIN_LENGTH, PASSWORD_REQUIRE_MIXED, LOCKOUT_THRESHOLD,
+// LOCKOUT_BASE, LOCKOUT_MAX, AUTH_THROTTLE_REQUESTS, AUTH_THROTTLE_WINDOW.
+func LoadAuthPolicy() (AuthPolicy, error) {
+	p := DefaultAuthPolicy()
+	var err error
+	if v := os.Getenv("LOGIN_PATTERN"); v != "" {
+		if p.LoginPattern, err = regexp.Compile(v); err != nil {
+			return p, err
+		}
+	}
+	ints := map[string]*int{
+		"PASSWORD_MIN_LENGTH":    &p.PasswordMinLength,
+		"LOCKOUT_THRESHOLD":      &p.LockoutThreshold,
+		"AUTH_THROTTLE_REQUESTS": &p.ThrottleRequests,
+	}
+	for name, dst := range ints {
+		if v := os.Getenv(name); v != "" {
+			if *dst, err = strconv.Atoi(v); err != nil {
+				return p, err
+			}
+		}
+	}
+	durations := map[string]*time.Duration{
+		"LOCKOUT_BASE":         &p.LockoutBase,
+		"LOCKOUT_MAX":          &p.LockoutMax,
+		"AUTH_THROTTLE_WINDOW": &p.ThrottleWindow,
+	}
+	for name, dst := range durations {
+		if v := os.Getenv(name); v != "" {
+			if *dst, err = time.ParseDuration(v); err != nil {
+				return p, err
+			}
+		}
+	}
+	if v := os.Getenv("PASSWORD_REQUIRE_MIXED"); v != "" {
+		if p.PasswordMixed, err = strconv.ParseBool(v); err != nil {
+			return p, err
+		}
+	}
+	return p, nil
+}
+
+// validateCredentials проверяет логин и пароль при регистрации.
+func (p AuthPolicy) validateCredentials(login, password string) *apperrors.AppError {
+	if err := p.validateLogin(login); err != nil {
+		return err
+	}
+	return p.validatePassword(password)
+}
+
+// validateLogin проверяет логин по LoginPattern.
+func (p AuthPolicy) validateLogin(login string) *apperrors.AppError {
+	if !p.LoginPattern.MatchString(login) {
+		return apperrors.ErrInvalidLogin.WithField("login", "must match "+p.LoginPattern.String())
+	}
+	return nil
+}
+
+// validatePassword проверяет длину и состав пароля.
+func (p AuthPolicy) validatePassword(password string) *apperrors.AppError {
+	if len([]rune(password)) < p.PasswordMinLength {
+		return apperrors.ErrPasswordTooShort.WithField("password", "must be at least "+strconv.Itoa(p.PasswordMinLength)+" characters")
+	}
+	if p.PasswordMixed {
+		var letter, digit bool
+		for _, c := range password {
+			letter = letter || unicode.IsLetter(c)
+			digit = digit || unicode.IsDigit(c)
+		}
+		if !letter || !digit {
+			return apperrors.ErrPasswordTooWeak.WithField("password", "must contain letters and digits")
+		}
+	}
+	return nil
+}
+
+// lockoutFor возвращает срок блокировки после failures неудачных входов подряд.
+func (p AuthPolicy) lockoutFor(failures int) time.Duration {
+	if p.LockoutThreshold <= 0 || failures < p.LockoutThreshold {
+		return 0
+	}
+	d := p.LockoutBase
+	for i := p.LockoutThreshold; i < failures && d < p.LockoutMax; i++ {
+		d *= 2
+	}
+	if d > p.LockoutMax {
+		d = p.LockoutMax
+	}
+	return d
+}
+
+// throttle считает запросы по ключу (IP, пользователь) в фиксированном окне.
+type throttle struct {
+	mu      sync.Mutex
+	windows map[string]*throttleWindow
+}
+
+type throttleWindow struct {
+	start time.Time
+	count int
+}
+
+func newThrottle() *throttle {
+	return &throttle{windows: make(map[string]*throttleWindow)}
+}
+
+// allow учитывает запрос и возвращает, сколько ждать, если лимит исчерпан.
+func (t *throttle) allow(key string, limit int, window time.Duration) time.Duration {
+	if _, reset, ok := t.take(key, 1, limit, window); !ok {
+		return reset
+	}
+	return 0
+}
+
+// give возвращает n учтённых запросов в текущее окно ключа.
+func (t *throttle) give(key string, n int) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if w, ok := t.windows[key]; ok {
+		w.count = max(w.count-n, 0)
+	}
+}
+
+// take учитывает сразу n запросов, если они укладываются в limit, и возвращает,
+// сколько запросов ещё осталось в окне и через сколько оно сменится.
+// С n = 0 только сообщает текущее состояние.
+func (t *throttle) take(key string, n, limit int, window time.Duration) (remaining int, reset time.Duration, ok bool) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	now := time.Now()
+	for k, w := range t.windows {
+		if now.Sub(w.start) >= window {
+			delete(t.windows, k)
+		}
+	}
+	w, exists := t.windows[key]
+	if !exists {
+		if n == 0 {
+			return limit, window, true
+		}
+		w = &throttleWindow{start: now}
+		t.windows[key] = w
+	}
+	reset = w.start.Add(window).Sub(now)
+	if w.count+n > limit {
+		return limit - w.count, reset, false
+	}
+	w.count += n
+	return limit - w.count, reset, true
+}
+
+// ThrottleAuth возвращает middleware, которое ограничивает частоту запросов к /login
+// и /register с одного IP. Запросы считаются вместе для всех обёрнутых им обработчиков;
+// у каждого вызова ThrottleAuth свой счётчик.
+func ThrottleAuth() func(http.Handler) http.Handler {
+	limiter := newThrottle()
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if Policy.ThrottleRequests > 0 {
+				ip, _, err := net.SplitHostPort(r.RemoteAddr)
+				if err != nil {
+					ip = r.RemoteAddr
+				}
+				if wait := limiter.allow(ip, Policy.ThrottleRequests, Policy.ThrottleWindow); wait > 0 {
+					w.Header().Set("Retry-After", strconv.Itoa(int(wait.Seconds())+1))
+					apperrors.Write(w, r, apperrors.ErrRateLimited)
+					return
+				}
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
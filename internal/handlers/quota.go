@@ -0,0 +1,314 @@
+package handlers
+
+import (
+	"database/sql"
+	"encoding/json"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gorilla/mux"
+
+	"github.com/scriptoxin/yandex-liceum-go-calc/pkg/db"
+	apperrors "github.com/scriptoxin/yandex-liceum-go-calc/pkg/errors"
+)
+
+// quotaWindow — окно, в котором считается ограничение PerMinute.
+const quotaWindow = time.Minute
+
+// pendingRetryAfter — через сколько предлагаем повторить, если упёрлись в Pending:
+// точного срока нет, место освободится, когда досчитается одно из выражений.
+const pendingRetryAfter = 5 * time.Second
+
+// Quota — ограничения на отправку выражений, 0 — без ограничения.
+type Quota struct {
+	// PerMinute — сколько выражений можно отправить за минуту
+	PerMinute int `json:"per_minute"`
+	// Pending — сколько выражений могут одновременно ждать результата
+	Pending int `json:"pending"`
+	// Stored — сколько выражений всего может храниться у пользователя
+	Stored int `json:"stored"`
+}
+
+// quotaOverride — ограничения пользователя, заданные администратором; nil — как у роли.
+type quotaOverride struct {
+	PerMinute *int `json:"per_minute"`
+	Pending   *int `json:"pending"`
+	Stored    *int `json:"stored"`
+}
+
+// Quotas — ограничения по ролям; main загружает их из окружения.
+// Роли, которой здесь нет, ничего не ограничено.
+var Quotas = map[string]Quota{}
+
+// SubmitCounter считает отправленные за минуту выражения каждого пользователя.
+type SubmitCounter struct {
+	*throttle
+}
+
+// NewSubmitCounter возвращает пустой счётчик.
+func NewSubmitCounter() *SubmitCounter {
+	return &SubmitCounter{newThrottle()}
+}
+
+// SubmitRate — счётчик ограничения PerMinute, общий для API и расписаний;
+// как и Quotas, его можно заменить своим (тесты начинают с пустого).
+var SubmitRate = NewSubmitCounter()
+
+// LoadQuotas читает ограничения ролей user и admin из переменных окружения
+// QUOTA_<РОЛЬ>_PER_MINUTE, QUOTA_<РОЛЬ>_PENDING и QUOTA_<РОЛЬ>_STORED.
+func LoadQuotas() (map[string]Quota, error) {
+	quotas := map[string]Quota{}
+	for _, role := range []string{RoleUser, RoleAdmin} {
+		var q Quota
+		prefix := "QUOTA_" + strings.ToUpper(role) + "_"
+		for name, dst := range map[string]*int{
+			"PER_MINUTE": &q.PerMinute,
+			"PENDING":    &q.Pending,
+			"STORED":     &q.Stored,
+		} {
+			if v := os.Getenv(prefix + name); v != "" {
+				n, err := strconv.Atoi(v)
+				if err != nil {
+					return nil, err
+				}
+				*dst = n
+			}
+		}
+		quotas[role] = q
+	}
+	return quotas, nil
+}
+
+// apply заменяет ограничения роли заданными для пользователя.
+func (o quotaOverride) apply(q Quota) Quota {
+	if o.PerMinute != nil {
+		q.PerMinute = *o.PerMinute
+	}
+	if o.Pending != nil {
+		q.Pending = *o.Pending
+	}
+	if o.Stored != nil {
+		q.Stored = *o.Stored
+	}
+	return q
+}
+
+// loadQuotaOverride читает ограничения пользователя из таблицы users.
+func loadQuotaOverride(uid int) (quotaOverride, error) {
+	var perMinute, pending, stored sql.NullInt64
+	err := db.Conn.QueryRow(
+		"SELECT quota_per_minute, quota_pending, quota_stored FROM users WHERE id = ?", uid,
+	).Scan(&perMinute, &pending, &stored)
+	return quotaOverride{
+		PerMinute: nullIntPtr(perMinute),
+		Pending:   nullIntPtr(pending),
+		Stored:    nullIntPtr(stored),
+	}, err
+}
+
+func nullIntPtr(v sql.NullInt64) *int {
+	if !v.Valid {
+		return nil
+	}
+	n := int(v.Int64)
+	return &n
+}
+
+// quotaFor возвращает действующие ограничения пользователя.
+func quotaFor(uid int, role string) (Quota, error) {
+	override, err := loadQuotaOverride(uid)
+	if err != nil {
+		return Quota{}, err
+	}
+	return override.apply(Quotas[role]), nil
+}
+
+// countExpressions считает выражения пользователя: все или только ещё не досчитанные.
+func countExpressions(uid int, pendingOnly bool) (int, error) {
+	query := "SELECT COUNT(*) FROM expressions WHERE user_id = ?"
+	if pendingOnly {
+		query += " AND status IN ('pending', 'in_progress')"
+	}
+	var n int
+	err := db.Conn.QueryRow(query, uid).Scan(&n)
+	return n, err
+}
+
+// quotaLocks разбивают пользователей на группы: пока группа заблокирована, между
+// подсчётом выражений в reserveQuota и их записью в БД никто из неё не отправит новые.
+var quotaLocks [64]sync.Mutex
+
+// lockQuota блокирует группу пользователя uid и возвращает функцию разблокировки.
+func lockQuota(uid int) func() {
+	m := &quotaLocks[uint(uid)%uint(len(quotaLocks))]
+	m.Lock()
+	return m.Unlock
+}
+
+// withQuota проверяет, можно ли пользователю отправить ещё n выражений, и вызывает insert,
+// который их сохраняет. Если insert не удался, выражения за минуту возвращаются в лимит,
+// а ответом становится ErrInternalServer.
+func withQuota(w http.ResponseWriter, uid int, role string, n int, insert func() error) *apperrors.AppError {
+	unlock := lockQuota(uid)
+	defer unlock()
+	taken, appErr := reserveQuota(w, uid, role, n, 0)
+	if appErr != nil {
+		return appErr
+	}
+	if err := insert(); err != nil {
+		refundQuota(uid, taken)
+		return apperrors.ErrInternalServer
+	}
+	return nil
+}
+
+// refundQuota возвращает в лимит за минуту taken выражений, которые так и не были сохранены.
+func refundQuota(uid, taken int) {
+	if taken > 0 {
+		SubmitRate.give(strconv.Itoa(uid), taken)
+	}
+}
+
+// reserveQuota проверяет, можно ли пользователю отправить ещё n выражений сверх queued
+// уже одобренных, но не сохранённых (запуски расписания пишутся одной транзакцией),
+// и учитывает их в ограничении за минуту; taken — сколько учтено. Вызывающий держит
+// lockQuota(uid), пока выражения не записаны. Если w не nil, в ответ пишутся
+// заголовки X-RateLimit-*, а при отказе — Retry-After.
+func reserveQuota(w http.ResponseWriter, uid int, role string, n, queued int) (taken int, appErr *apperrors.AppError) {
+	q, err := quotaFor(uid, role)
+	if err != nil {
+		return 0, apperrors.ErrInternalServer
+	}
+	if q.Stored > 0 {
+		stored, err := countExpressions(uid, false)
+		if err != nil {
+			return 0, apperrors.ErrInternalServer
+		}
+		if stored+queued+n > q.Stored {
+			return 0, apperrors.ErrQuotaExceeded.WithField("stored",
+				"at most "+strconv.Itoa(q.Stored)+" stored expressions, delete old ones first")
+		}
+	}
+	if q.Pending > 0 {
+		pending, err := countExpressions(uid, true)
+		if err != nil {
+			return 0, apperrors.ErrInternalServer
+		}
+		if pending+queued+n > q.Pending {
+			setRetryAfter(w, pendingRetryAfter)
+			return 0, apperrors.ErrQuotaExceeded.WithField("pending",
+				"at most "+strconv.Itoa(q.Pending)+" expressions may wait for a result")
+		}
+	}
+	if q.PerMinute > 0 {
+		remaining, reset, ok := SubmitRate.take(strconv.Itoa(uid), n, q.PerMinute, quotaWindow)
+		if w != nil {
+			w.Header().Set("X-RateLimit-Limit", strconv.Itoa(q.PerMinute))
+			w.Header().Set("X-RateLimit-Remaining", strconv.Itoa(remaining))
+			w.Header().Set("X-RateLimit-Reset", strconv.Itoa(seconds(reset)))
+		}
+		if !ok {
+			setRetryAfter(w, reset)
+			return 0, apperrors.ErrRateLimited
+		}
+		taken = n
+	}
+	return taken, nil
+}
+
+func setRetryAfter(w http.ResponseWriter, d time.Duration) {
+	if w != nil {
+		w.Header().Set("Retry-After", strconv.Itoa(seconds(d)))
+	}
+}
+
+// seconds округляет длительность вверх до целых секунд.
+func seconds(d time.Duration) int {
+	return int((d + time.Second - 1) / time.Second)
+}
+
+// usageEntry — использование одного ограничения; limit — null, если его нет.
+func usageEntry(limit, used int) map[string]interface{} {
+	out := map[string]interface{}{"used": used, "limit": nil}
+	if limit > 0 {
+		out["limit"] = limit
+		out["remaining"] = max(limit-used, 0)
+	}
+	return out
+}
+
+// GetUsage — GET /api/v1/me/usage
+// Действующие ограничения пользователя и сколько из них уже израсходовано.
+func GetUsage(w http.ResponseWriter, r *http.Request) {
+	uid := r.Context().Value("user_id").(int)
+	role, _ := r.Context().Value("role").(string)
+
+	q, err := quotaFor(uid, role)
+	if err != nil {
+		apperrors.Write(w, r, apperrors.ErrInternalServer)
+		return
+	}
+	stored, err := countExpressions(uid, false)
+	if err != nil {
+		apperrors.Write(w, r, apperrors.ErrInternalServer)
+		return
+	}
+	pending, err := countExpressions(uid, true)
+	if err != nil {
+		apperrors.Write(w, r, apperrors.ErrInternalServer)
+		return
+	}
+	perMinute := usageEntry(q.PerMinute, 0)
+	if q.PerMinute > 0 {
+		remaining, reset, _ := SubmitRate.take(strconv.Itoa(uid), 0, q.PerMinute, quotaWindow)
+		perMinute = usageEntry(q.PerMinute, q.PerMinute-remaining)
+		perMinute["reset_in"] = seconds(reset)
+	}
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"role":       role,
+		"per_minute": perMinute,
+		"pending":    usageEntry(q.Pending, pending),
+		"stored":     usageEntry(q.Stored, stored),
+	})
+}
+
+// SetUserQuota — PUT /api/v1/admin/users/{id}/quota
+// {"per_minute":10,"pending":50,"stored":null}: ограничения пользователя вместо ограничений
+// его роли; null или отсутствующее поле — как у роли, 0 — без ограничения.
+func SetUserQuota(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		apperrors.Write(w, r, apperrors.ErrNotFound)
+		return
+	}
+	var req quotaOverride
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		apperrors.Write(w, r, apperrors.ErrBadRequest)
+		return
+	}
+	for field, v := range map[string]*int{"per_minute": req.PerMinute, "pending": req.Pending, "stored": req.Stored} {
+		if v != nil && *v < 0 {
+			apperrors.Write(w, r, apperrors.ErrInvalidField.WithField(field, "must not be negative"))
+			return
+		}
+	}
+	res, err := db.Conn.Exec(
+		"UPDATE users SET quota_per_minute = ?, quota_pending = ?, quota_stored = ? WHERE id = ?",
+		req.PerMinute, req.Pending, req.Stored, id,
+	)
+	if err != nil {
+		apperrors.Write(w, r, apperrors.ErrInternalServer)
+		return
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		apperrors.Write(w, r, apperrors.ErrNotFound)
+		return
+	}
+	audit(r, r.Context().Value("user_id").(int), AuditAdminQuota, strconv.Itoa(id),
+		map[string]interface{}{"per_minute": req.PerMinute, "pending": req.Pending, "stored": req.Stored})
+	json.NewEncoder(w).Encode(map[string]interface{}{"quota": req})
+}
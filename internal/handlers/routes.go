@@ -0,0 +1,93 @@
+package handlers
+
+import (
+	"net/http"
+
+	"github.com/gorilla/mux"
+)
+
+// NewRouter собирает все маршруты REST API.
+// Изменяющие запросы (кроме собственного аккаунта и API-ключей) доступны только ролям user и admin:
+// readonly-пользователь и ключ со scope read их не пройдут. Аккаунт и API-ключи
+// меняются только со входом по паролю (RejectAPIKeys).
+func NewRouter() *mux.Router {
+	r := mux.NewRouter()
+	r.Use(RequestID)
+	// публичные эндпойнты, с ограничением частоты запросов с одного IP
+	throttled := ThrottleAuth()
+	r.Handle("/api/v1/register", throttled(http.HandlerFunc(Register))).Methods("POST")
+	r.Handle("/api/v1/login", throttled(http.HandlerFunc(Login))).Methods("POST")
+	r.Handle("/api/v1/login/2fa", throttled(http.HandlerFunc(LoginTwoFactor))).Methods("POST")
+	r.HandleFunc("/api/v1/errors", ListErrors).Methods("GET")
+
+	// защищённая часть
+	auth := r.PathPrefix("/api/v1").Subrouter()
+	auth.Use(AuthMiddleware)
+	writers := RequireRole(RoleUser, RoleAdmin)
+	auth.Handle("/calculate", writers(Idempotent(http.HandlerFunc(Calculate)))).Methods("POST")
+	auth.Handle("/calculate/batch", writers(Idempotent(http.HandlerFunc(CalculateBatch)))).Methods("POST")
+	auth.HandleFunc("/validate", ValidateExpression).Methods("POST")
+	auth.HandleFunc("/batches/{id}", GetBatch).Methods("GET")
+	auth.HandleFunc("/expressions", GetExpressions).Methods("GET")
+	auth.HandleFunc("/expressions/stream", StreamExpressions).Methods("GET")
+	auth.HandleFunc("/expressions/export", ExportExpressions).Methods("GET")
+	auth.Handle("/expressions/import", writers(Idempotent(http.HandlerFunc(ImportExpressions)))).Methods("POST")
+	auth.HandleFunc("/expressions/{id}", GetExpression).Methods("GET")
+	auth.HandleFunc("/expressions/{id}/events", ExpressionEvents).Methods("GET")
+	auth.HandleFunc("/expressions/{id}/trace", ExpressionTrace).Methods("GET")
+	auth.Handle("/expressions/{id}/cancel", writers(http.HandlerFunc(CancelExpression))).Methods("POST")
+	auth.Handle("/expressions/{id}", writers(http.HandlerFunc(DeleteExpression))).Methods("DELETE")
+	auth.Handle("/expressions", writers(http.HandlerFunc(DeleteExpressions))).Methods("DELETE")
+	auth.Handle("/schedules", writers(http.HandlerFunc(CreateSchedule))).Methods("POST")
+	auth.HandleFunc("/schedules", ListSchedules).Methods("GET")
+	auth.HandleFunc("/schedules/{id}", GetSchedule).Methods("GET")
+	auth.Handle("/schedules/{id}", writers(http.HandlerFunc(UpdateSchedule))).Methods("PATCH")
+	auth.Handle("/schedules/{id}", writers(http.HandlerFunc(DeleteSchedule))).Methods("DELETE")
+	auth.HandleFunc("/schedules/{id}/runs", ScheduleRuns).Methods("GET")
+	auth.Handle("/webhooks", writers(http.HandlerFunc(CreateWebhook))).Methods("POST")
+	auth.HandleFunc("/webhooks", ListWebhooks).Methods("GET")
+	auth.HandleFunc("/webhooks/secret", GetWebhookSecret).Methods("GET")
+	auth.Handle("/webhooks/secret/rotate", writers(http.HandlerFunc(RotateWebhookSecret))).Methods("POST")
+	auth.HandleFunc("/webhooks/deliveries", ListWebhookDeliveries).Methods("GET")
+	auth.HandleFunc("/webhooks/deliveries/{id}", GetWebhookDelivery).Methods("GET")
+	auth.Handle("/webhooks/deliveries/{id}/retry", writers(http.HandlerFunc(RetryWebhookDelivery))).Methods("POST")
+	auth.Handle("/webhooks/{id}", writers(http.HandlerFunc(DeleteWebhook))).Methods("DELETE")
+	auth.HandleFunc("/ws", WebSocket).Methods("GET")
+	auth.HandleFunc("/me", GetMe).Methods("GET")
+	auth.Handle("/me", RejectAPIKeys(http.HandlerFunc(UpdateMe))).Methods("PATCH")
+	auth.Handle("/me", RejectAPIKeys(http.HandlerFunc(DeleteMe))).Methods("DELETE")
+	auth.HandleFunc("/me/usage", GetUsage).Methods("GET")
+	auth.HandleFunc("/me/stats", GetStats).Methods("GET")
+	auth.Handle("/me/password", RejectAPIKeys(http.HandlerFunc(ChangePassword))).Methods("POST")
+	auth.Handle("/me/restore", RejectAPIKeys(http.HandlerFunc(RestoreMe))).Methods("POST")
+	auth.Handle("/me/2fa/setup", RejectAPIKeys(http.HandlerFunc(SetupTwoFactor))).Methods("POST")
+	auth.Handle("/me/2fa/enable", RejectAPIKeys(http.HandlerFunc(EnableTwoFactor))).Methods("POST")
+	auth.Handle("/me/2fa/disable", RejectAPIKeys(http.HandlerFunc(DisableTwoFactor))).Methods("POST")
+	auth.Handle("/orgs", writers(http.HandlerFunc(CreateOrg))).Methods("POST")
+	auth.HandleFunc("/orgs", ListOrgs).Methods("GET")
+	auth.HandleFunc("/orgs/{id}/members", ListOrgMembers).Methods("GET")
+	auth.Handle("/orgs/{id}/members/{user_id}", writers(http.HandlerFunc(RemoveOrgMember))).Methods("DELETE")
+	auth.Handle("/orgs/{id}/invitations", writers(http.HandlerFunc(CreateInvitation))).Methods("POST")
+	auth.Handle("/invitations/accept", writers(http.HandlerFunc(AcceptInvitation))).Methods("POST")
+	auth.Handle("/api-keys", RejectAPIKeys(http.HandlerFunc(CreateAPIKey))).Methods("POST")
+	auth.Handle("/api-keys", RejectAPIKeys(http.HandlerFunc(ListAPIKeys))).Methods("GET")
+	auth.Handle("/api-keys/{id}", RejectAPIKeys(http.HandlerFunc(RevokeAPIKey))).Methods("DELETE")
+
+	// администрирование
+	admin := auth.PathPrefix("/admin").Subrouter()
+	admin.Use(RequireRole(RoleAdmin))
+	admin.HandleFunc("/users", ListUsers).Methods("GET")
+	admin.HandleFunc("/users/{id}/disable", DisableUser).Methods("POST")
+	admin.HandleFunc("/users/{id}/enable", EnableUser).Methods("POST")
+	admin.HandleFunc("/users/{id}/role", SetUserRole).Methods("PUT")
+	admin.HandleFunc("/users/{id}/scheduling", SetUserScheduling).Methods("PUT")
+	admin.HandleFunc("/users/{id}/quota", SetUserQuota).Methods("PUT")
+	admin.HandleFunc("/users/{id}/expressions", GetUserExpressions).Methods("GET")
+	admin.HandleFunc("/queue", GetQueue).Methods("GET")
+	admin.HandleFunc("/agents", GetAgents).Methods("GET")
+	admin.HandleFunc("/cache", GetCache).Methods("GET")
+	admin.HandleFunc("/cache", PurgeCache).Methods("DELETE")
+	admin.HandleFunc("/audit", GetAuditEvents).Methods("GET")
+
+	return r
+}
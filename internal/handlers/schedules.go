@@ -0,0 +1,590 @@
+package handlers
+
+import (
+	"database/sql"
+	"encoding/json"
+	"log"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/gorilla/mux"
+
+	"github.com/scriptoxin/yandex-liceum-go-calc/internal/evaluator"
+	"github.com/scriptoxin/yandex-liceum-go-calc/pkg/cron"
+	"github.com/scriptoxin/yandex-liceum-go-calc/pkg/db"
+	apperrors "github.com/scriptoxin/yandex-liceum-go-calc/pkg/errors"
+)
+
+// Что делать с запусками, пропущенными, пока оркестратор не работал.
+const (
+	// MissedSkip — пропущенные не выполняются, следующий запуск — по расписанию
+	MissedSkip = "skip"
+	// MissedCatchUp — выполняется каждый пропущенный запуск (не больше maxCatchUp)
+	MissedCatchUp = "catch_up"
+)
+
+// scheduleGrace — насколько запуск может опоздать и ещё не считаться пропущенным.
+const scheduleGrace = time.Minute
+
+// maxCatchUp — сколько последних пропущенных запусков навёрстывается; более ранние теряются.
+const maxCatchUp = 100
+
+// minScheduleInterval — самый частый запуск по интервалу.
+const minScheduleInterval = time.Second
+
+// scheduleColumns — столбцы, которые читает scanSchedule.
+const scheduleColumns = "id, user_id, org_id, name, expression, variables, cron, interval_ms, missed, " +
+	"priority, enabled, next_run, last_run, created_at, skipped_runs, last_skip_reason, last_skipped_at"
+
+// scheduleRequest — тело POST и PATCH /schedules; в PATCH меняются только переданные поля.
+type scheduleRequest struct {
+	Name       *string            `json:"name"`
+	Expression *string            `json:"expression"`
+	Variables  map[string]float64 `json:"variables"`
+	// Cron — выражение из пяти полей (UTC) или @hourly, @daily...; либо Cron, либо Interval
+	Cron *string `json:"cron"`
+	// Interval — длительность, например 15m или 1h
+	Interval *string `json:"interval"`
+	Missed   *string `json:"missed"`
+	Priority *int    `json:"priority"`
+	Enabled  *bool   `json:"enabled"`
+}
+
+// schedule — расписание вычисления выражения.
+type schedule struct {
+	ID         string
+	UserID     int
+	OrgID      sql.NullInt64
+	Name       string
+	Expression string
+	Variables  map[string]float64
+	Cron       string
+	Interval   time.Duration
+	Missed     string
+	Priority   int
+	Enabled    bool
+	NextRun    sql.NullTime
+	LastRun    sql.NullTime
+	CreatedAt  time.Time
+	// SkippedRuns — сколько запусков пропущено из-за ограничений владельца; в выражения они не попадают
+	SkippedRuns    int
+	LastSkipReason string
+	LastSkippedAt  sql.NullTime
+}
+
+// scheduleMu не даёт двум RunSchedules запустить одно расписание дважды, PATCH —
+// записать next_run, прочитанный до того, как RunSchedules его сдвинул, а DELETE —
+// удалить расписание, пока по нему создаются выражения.
+var scheduleMu sync.Mutex
+
+func scanSchedule(row rowScanner) (*schedule, error) {
+	var (
+		s         schedule
+		variables sql.NullString
+		cronSpec  sql.NullString
+		interval  sql.NullInt64
+	)
+	err := row.Scan(&s.ID, &s.UserID, &s.OrgID, &s.Name, &s.Expression, &variables, &cronSpec, &interval,
+		&s.Missed, &s.Priority, &s.Enabled, &s.NextRun, &s.LastRun, &s.CreatedAt,
+		&s.SkippedRuns, &s.LastSkipReason, &s.LastSkippedAt)
+	if err != nil {
+		return nil, err
+	}
+	s.Variables = map[string]float64{}
+	if variables.Valid {
+		json.Unmarshal([]byte(variables.String), &s.Variables)
+	}
+	s.Cron = cronSpec.String
+	s.Interval = time.Duration(interval.Int64) * time.Millisecond
+	return &s, nil
+}
+
+// toJSON — представление расписания в ответах API.
+func (s *schedule) toJSON() map[string]interface{} {
+	out := map[string]interface{}{
+		"id":         s.ID,
+		"name":       s.Name,
+		"expression": s.Expression,
+		"variables":  s.Variables,
+		"missed":     s.Missed,
+		"priority":   s.Priority,
+		"enabled":    s.Enabled,
+		"created_at": s.CreatedAt,
+	}
+	if s.Cron != "" {
+		out["cron"] = s.Cron
+	} else {
+		out["interval"] = s.Interval.String()
+	}
+	if s.Enabled && s.NextRun.Valid {
+		out["next_run"] = s.NextRun.Time
+	}
+	if s.LastRun.Valid {
+		out["last_run"] = s.LastRun.Time
+	}
+	out["skipped_runs"] = s.SkippedRuns
+	if s.LastSkippedAt.Valid {
+		out["last_skip"] = map[string]interface{}{"at": s.LastSkippedAt.Time, "reason": s.LastSkipReason}
+	}
+	return out
+}
+
+// next возвращает первый запуск строго после t или нулевое время, если его не будет.
+func (s *schedule) next(t time.Time) time.Time {
+	if s.Cron != "" {
+		c, err := cron.Parse(s.Cron)
+		if err != nil {
+			return time.Time{}
+		}
+		return c.Next(t)
+	}
+	return t.Add(s.Interval)
+}
+
+// apply переносит в расписание поля запроса и проверяет результат.
+// Возвращает true, если поменялось время запусков и next_run нужно пересчитать.
+func (s *schedule) apply(req scheduleRequest) (bool, *apperrors.AppError) {
+	if req.Name != nil {
+		s.Name = *req.Name
+	}
+	if req.Expression != nil {
+		s.Expression = *req.Expression
+	}
+	if req.Variables != nil {
+		s.Variables = req.Variables
+	}
+	if req.Missed != nil {
+		s.Missed = *req.Missed
+	}
+	if req.Priority != nil {
+		s.Priority = *req.Priority
+	}
+	timing := false
+	if req.Enabled != nil {
+		timing = timing || s.Enabled != *req.Enabled
+		s.Enabled = *req.Enabled
+	}
+	if req.Cron != nil && req.Interval != nil {
+		return false, apperrors.ErrInvalidField.WithField("cron", "set either cron or interval, not both")
+	}
+	if req.Cron != nil {
+		c, err := cron.Parse(*req.Cron)
+		if err != nil {
+			return false, apperrors.ErrInvalidField.WithField("cron", err.Error())
+		}
+		if c.Next(time.Now()).IsZero() {
+			return false, apperrors.ErrInvalidField.WithField("cron", "never fires")
+		}
+		s.Cron, s.Interval, timing = *req.Cron, 0, true
+	}
+	if req.Interval != nil {
+		d, err := time.ParseDuration(*req.Interval)
+		if err != nil || d < minScheduleInterval {
+			return false, apperrors.ErrInvalidField.WithField("interval", "must be a duration of at least 1s, e.g. 15m")
+		}
+		s.Cron, s.Interval, timing = "", d, true
+	}
+
+	if s.Cron == "" && s.Interval == 0 {
+		return false, apperrors.ErrInvalidField.WithField("cron", "either cron or interval is required")
+	}
+	if s.Missed != MissedSkip && s.Missed != MissedCatchUp {
+		return false, apperrors.ErrInvalidField.WithField("missed", "must be skip or catch_up")
+	}
+	if appErr := checkPriority("priority", s.Priority); appErr != nil {
+		return false, appErr
+	}
+	for name := range s.Variables {
+		if !evaluator.ValidName(name) {
+			return false, apperrors.ErrInvalidField.WithField("variables."+name,
+				"name must consist of latin letters, digits and _ and not start with a digit")
+		}
+	}
+	expr, err := evaluator.Substitute(s.Expression, s.Variables)
+	if err != nil {
+		message, position := describeExpressionError(err)
+		return false, apperrors.ErrInvalidExpression.WithFieldAt("expression", message, position)
+	}
+	if _, appErr := checkExpression("expression", expr); appErr != nil {
+		return false, appErr
+	}
+	return timing, nil
+}
+
+// save записывает расписание в БД.
+func (s *schedule) save(query string, args ...interface{}) error {
+	variables, _ := json.Marshal(s.Variables)
+	var cronSpec, interval interface{}
+	if s.Cron != "" {
+		cronSpec = s.Cron
+	} else {
+		interval = s.Interval.Milliseconds()
+	}
+	var nextRun interface{}
+	if s.NextRun.Valid {
+		nextRun = s.NextRun.Time
+	}
+	_, err := db.Conn.Exec(query, append([]interface{}{
+		s.Name, s.Expression, string(variables), cronSpec, interval, s.Missed, s.Priority, s.Enabled, nextRun,
+	}, args...)...)
+	return err
+}
+
+// CreateSchedule — POST /api/v1/schedules
+// {"name":"hourly","expression":"a*b+1","variables":{"a":2,"b":3},"cron":"0 * * * *"}
+// или с "interval":"15m" вместо cron. missed — skip (по умолчанию) или catch_up.
+// Выражения создаются в рабочем пространстве из запроса.
+func CreateSchedule(w http.ResponseWriter, r *http.Request) {
+	uid := r.Context().Value("user_id").(int)
+	ws, ok := resolveWorkspace(w, r)
+	if !ok {
+		return
+	}
+	var req scheduleRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		apperrors.Write(w, r, apperrors.ErrBadRequest)
+		return
+	}
+	if req.Expression == nil {
+		apperrors.Write(w, r, apperrors.ErrInvalidField.WithField("expression", "is required"))
+		return
+	}
+	s := &schedule{
+		ID:        uuid.NewString(),
+		UserID:    uid,
+		Missed:    MissedSkip,
+		Enabled:   true,
+		Variables: map[string]float64{},
+		CreatedAt: time.Now().UTC(),
+	}
+	if ws.orgID != 0 {
+		s.OrgID = sql.NullInt64{Int64: int64(ws.orgID), Valid: true}
+	}
+	if _, appErr := s.apply(req); appErr != nil {
+		apperrors.Write(w, r, appErr)
+		return
+	}
+	s.NextRun = sql.NullTime{Time: s.next(s.CreatedAt), Valid: true}
+	err := s.save(
+		"INSERT INTO schedules(name, expression, variables, cron, interval_ms, missed, priority, enabled, next_run, "+
+			"id, user_id, org_id, created_at) VALUES(?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)",
+		s.ID, uid, ws.orgIDValue(), s.CreatedAt,
+	)
+	if err != nil {
+		apperrors.Write(w, r, apperrors.ErrInternalServer)
+		return
+	}
+	audit(r, uid, AuditScheduleCreate, s.ID, nil)
+	w.Header().Set("Location", "/api/v1/schedules/"+s.ID)
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(map[string]interface{}{"schedule": s.toJSON()})
+}
+
+// ListSchedules — GET /api/v1/schedules
+func ListSchedules(w http.ResponseWriter, r *http.Request) {
+	ws, ok := resolveWorkspace(w, r)
+	if !ok {
+		return
+	}
+	cond, args := ws.cond()
+	rows, err := db.Conn.Query("SELECT "+scheduleColumns+" FROM schedules WHERE "+cond+" ORDER BY created_at", args...)
+	if err != nil {
+		apperrors.Write(w, r, apperrors.ErrInternalServer)
+		return
+	}
+	defer rows.Close()
+
+	list := []map[string]interface{}{}
+	for rows.Next() {
+		s, err := scanSchedule(rows)
+		if err != nil {
+			apperrors.Write(w, r, apperrors.ErrInternalServer)
+			return
+		}
+		list = append(list, s.toJSON())
+	}
+	json.NewEncoder(w).Encode(map[string]interface{}{"schedules": list})
+}
+
+// findSchedule ищет расписание из пути запроса в рабочем пространстве; если его нет, отвечает 404.
+func findSchedule(w http.ResponseWriter, r *http.Request) (*schedule, bool) {
+	ws, ok := resolveWorkspace(w, r)
+	if !ok {
+		return nil, false
+	}
+	cond, args := ws.cond()
+	s, err := scanSchedule(db.Conn.QueryRow(
+		"SELECT "+scheduleColumns+" FROM schedules WHERE id = ? AND "+cond,
+		append([]interface{}{mux.Vars(r)["id"]}, args...)...,
+	))
+	if err != nil {
+		apperrors.Write(w, r, apperrors.ErrNotFound)
+		return nil, false
+	}
+	return s, true
+}
+
+// GetSchedule — GET /api/v1/schedules/{id}
+func GetSchedule(w http.ResponseWriter, r *http.Request) {
+	s, ok := findSchedule(w, r)
+	if !ok {
+		return
+	}
+	json.NewEncoder(w).Encode(map[string]interface{}{"schedule": s.toJSON()})
+}
+
+// UpdateSchedule — PATCH /api/v1/schedules/{id}
+// Меняются только переданные поля; новые variables действуют со следующего запуска.
+// При смене cron, interval или включении расписания следующий запуск считается от текущего момента.
+func UpdateSchedule(w http.ResponseWriter, r *http.Request) {
+	var req scheduleRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		apperrors.Write(w, r, apperrors.ErrBadRequest)
+		return
+	}
+	scheduleMu.Lock()
+	defer scheduleMu.Unlock()
+	s, ok := findSchedule(w, r)
+	if !ok {
+		return
+	}
+	timing, appErr := s.apply(req)
+	if appErr != nil {
+		apperrors.Write(w, r, appErr)
+		return
+	}
+	if timing {
+		s.NextRun = sql.NullTime{Time: s.next(time.Now().UTC()), Valid: true}
+	}
+	err := s.save(
+		"UPDATE schedules SET name = ?, expression = ?, variables = ?, cron = ?, interval_ms = ?, missed = ?, "+
+			"priority = ?, enabled = ?, next_run = ? WHERE id = ?",
+		s.ID,
+	)
+	if err != nil {
+		apperrors.Write(w, r, apperrors.ErrInternalServer)
+		return
+	}
+	audit(r, r.Context().Value("user_id").(int), AuditScheduleUpdate, s.ID, nil)
+	json.NewEncoder(w).Encode(map[string]interface{}{"schedule": s.toJSON()})
+}
+
+// DeleteSchedule — DELETE /api/v1/schedules/{id}
+// Уже созданные по расписанию выражения остаются.
+func DeleteSchedule(w http.ResponseWriter, r *http.Request) {
+	scheduleMu.Lock()
+	defer scheduleMu.Unlock()
+	s, ok := findSchedule(w, r)
+	if !ok {
+		return
+	}
+	if _, err := db.Conn.Exec("DELETE FROM schedules WHERE id = ?", s.ID); err != nil {
+		apperrors.Write(w, r, apperrors.ErrInternalServer)
+		return
+	}
+	audit(r, r.Context().Value("user_id").(int), AuditScheduleDelete, s.ID, nil)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// ScheduleRuns — GET /api/v1/schedules/{id}/runs
+// История запусков — выражения, созданные по расписанию, с теми же фильтрами и страницами,
+// что у GET /expressions; по умолчанию новые сверху.
+func ScheduleRuns(w http.ResponseWriter, r *http.Request) {
+	s, ok := findSchedule(w, r)
+	if !ok {
+		return
+	}
+	writeExpressions(w, r, "schedule_id = ?", s.ID)
+}
+
+// RunSchedules создаёт выражения по расписаниям, чей срок наступил к моменту now.
+// main вызывает её раз в секунду. Следующий запуск хранится в БД вместе с созданными
+// выражениями, поэтому после перезапуска расписания продолжаются с того же места,
+// а пропущенные за время простоя запуски обрабатываются по политике missed.
+func RunSchedules(now time.Time) {
+	scheduleMu.Lock()
+	defer scheduleMu.Unlock()
+
+	now = now.UTC()
+	rows, err := db.Conn.Query(
+		"SELECT "+scheduleColumns+" FROM schedules WHERE enabled = 1 AND next_run <= ? ORDER BY next_run", now,
+	)
+	if err != nil {
+		log.Printf("schedules: %v", err)
+		return
+	}
+	var due []*schedule
+	for rows.Next() {
+		s, err := scanSchedule(rows)
+		if err != nil {
+			log.Printf("schedules: %v", err)
+			continue
+		}
+		due = append(due, s)
+	}
+	rows.Close()
+
+	for _, s := range due {
+		if err := runSchedule(s, now); err != nil {
+			log.Printf("schedule %s: %v", s.ID, err)
+		}
+	}
+}
+
+// runSchedule выполняет наступившие запуски одного расписания и сдвигает next_run.
+// Каждый запуск проходит через ограничения владельца расписания, как отправка
+// через API; запуск сверх них пропускается: выражение не создаётся, а в расписании
+// растёт счётчик skipped_runs и запоминается причина.
+func runSchedule(s *schedule, now time.Time) error {
+	times := dueTimes(s, now)
+
+	var role string
+	if err := db.Conn.QueryRow("SELECT role FROM users WHERE id = ?", s.UserID).Scan(&role); err != nil {
+		return err
+	}
+	unlock := lockQuota(s.UserID)
+	defer unlock()
+	skipped := make([]*apperrors.AppError, len(times))
+	accepted, taken := 0, 0
+	for i := range times {
+		var n int
+		n, skipped[i] = reserveQuota(nil, s.UserID, role, 1, accepted)
+		taken += n
+		switch skipped[i] {
+		case nil:
+			accepted++
+		case apperrors.ErrInternalServer:
+			refundQuota(s.UserID, taken)
+			return skipped[i]
+		}
+	}
+	if err := insertRuns(s, now, times, skipped); err != nil {
+		refundQuota(s.UserID, taken)
+		return err
+	}
+	return nil
+}
+
+// insertRuns одной транзакцией сохраняет запуски times, кроме пропущенных, сдвигает next_run
+// и передаёт новые выражения оркестратору.
+func insertRuns(s *schedule, now time.Time, times []time.Time, skipped []*apperrors.AppError) error {
+
+	expr, substErr := evaluator.Substitute(s.Expression, s.Variables)
+	tx, err := db.Conn.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var orgID interface{}
+	if s.OrgID.Valid {
+		orgID = s.OrgID.Int64
+	}
+	var (
+		ids        []string
+		skips      int
+		skipReason string
+	)
+	for i, at := range times {
+		if skipped[i] != nil {
+			skips++
+			skipReason = skipError(skipped[i])
+			continue
+		}
+		id := uuid.NewString()
+		// Переменная, которой больше нет, — ошибка этого запуска, а не всего расписания
+		if substErr != nil {
+			_, err = tx.Exec(
+				"INSERT INTO expressions(id, user_id, org_id, expression, status, error, label, priority, "+
+					"schedule_id, scheduled_for, created_at, finished_at) VALUES(?, ?, ?, ?, 'error', ?, ?, ?, ?, ?, ?, ?)",
+				id, s.UserID, orgID, s.Expression, substErr.Error(), s.Name, s.Priority, s.ID, at, now, now,
+			)
+		} else {
+			ids = append(ids, id)
+			_, err = tx.Exec(
+				"INSERT INTO expressions(id, user_id, org_id, expression, status, label, priority, "+
+					"schedule_id, scheduled_for, created_at) VALUES(?, ?, ?, ?, 'pending', ?, ?, ?, ?, ?)",
+				id, s.UserID, orgID, expr, s.Name, s.Priority, s.ID, at, now,
+			)
+		}
+		if err != nil {
+			return err
+		}
+	}
+
+	last := s.NextRun.Time
+	for !last.IsZero() && !last.After(now) {
+		last = s.next(last)
+		// для интервала перескакиваем долгий простой одним шагом
+		if s.Interval > 0 && now.Sub(last) > s.Interval {
+			last = last.Add(now.Sub(last) / s.Interval * s.Interval)
+		}
+	}
+	var nextRun, lastRun interface{}
+	if !last.IsZero() {
+		nextRun = last
+	}
+	if len(times) > 0 {
+		lastRun = times[len(times)-1]
+	} else if s.LastRun.Valid {
+		lastRun = s.LastRun.Time
+	}
+	if _, err := tx.Exec("UPDATE schedules SET next_run = ?, last_run = ? WHERE id = ?", nextRun, lastRun, s.ID); err != nil {
+		return err
+	}
+	if skips > 0 {
+		_, err := tx.Exec(
+			"UPDATE schedules SET skipped_runs = skipped_runs + ?, last_skip_reason = ?, last_skipped_at = ? WHERE id = ?",
+			skips, skipReason, now, s.ID,
+		)
+		if err != nil {
+			return err
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+
+	if Orch != nil {
+		for _, id := range ids {
+			Orch.SubmitPriority(id, s.UserID, expr, s.Priority)
+		}
+	}
+	return nil
+}
+
+// skipError — причина пропуска запуска для last_skip.reason.
+func skipError(e *apperrors.AppError) string {
+	msg := e.Code
+	for _, f := range e.Fields {
+		msg += " (" + f.Field + ": " + f.Message + ")"
+	}
+	return msg
+}
+
+// dueTimes возвращает, какие из наступивших к now запусков выполнить.
+// Опоздавший больше чем на scheduleGrace запуск считается пропущенным: при skip он не
+// выполняется, при catch_up выполняются последние maxCatchUp пропущенных.
+func dueTimes(s *schedule, now time.Time) []time.Time {
+	at := s.NextRun.Time
+	if s.Interval > 0 && now.Sub(at) > maxCatchUp*s.Interval {
+		at = at.Add((now.Sub(at)/s.Interval - maxCatchUp) * s.Interval)
+	}
+	var times []time.Time
+	for !at.IsZero() && !at.After(now) {
+		if s.Missed == MissedCatchUp || now.Sub(at) <= scheduleGrace {
+			times = append(times, at)
+			if len(times) > maxCatchUp {
+				times = times[1:]
+			}
+		}
+		at = s.next(at)
+	}
+	// при skip из нескольких своевременных запусков (частый интервал) выполняется последний
+	if s.Missed == MissedSkip && len(times) > 1 {
+		times = times[len(times)-1:]
+	}
+	return times
+}
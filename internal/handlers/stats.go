@@ -0,0 +1,192 @@
+package handlers
+
+import (
+	"database/sql"
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/scriptoxin/yandex-liceum-go-calc/pkg/db"
+	apperrors "github.com/scriptoxin/yandex-liceum-go-calc/pkg/errors"
+)
+
+// Период статистики в днях: по умолчанию и предельный.
+const (
+	defaultStatsDays = 30
+	maxStatsDays     = 365
+)
+
+// statsTopOperations — сколько самых частых операций попадёт в ответ.
+const statsTopOperations = 5
+
+// latencyQuery — задержка досчитанных выражений (от создания до результата, мс):
+// среднее и перцентили по ближайшему рангу, одним проходом с оконными функциями.
+const latencyQuery = `
+	WITH d AS (
+		SELECT (julianday(finished_at) - julianday(created_at)) * 86400000 AS ms
+		FROM expressions
+		WHERE user_id = ? AND created_at >= ? AND status = 'done' AND finished_at IS NOT NULL
+	), r AS (
+		SELECT ms, ROW_NUMBER() OVER (ORDER BY ms) AS rn, COUNT(*) OVER () AS n FROM d
+	)
+	SELECT COUNT(*), AVG(ms), MAX(ms),
+		MAX(CASE WHEN rn = CAST((n - 1) * 0.5 AS INTEGER) + 1 THEN ms END),
+		MAX(CASE WHEN rn = CAST((n - 1) * 0.9 AS INTEGER) + 1 THEN ms END),
+		MAX(CASE WHEN rn = CAST((n - 1) * 0.95 AS INTEGER) + 1 THEN ms END),
+		MAX(CASE WHEN rn = CAST((n - 1) * 0.99 AS INTEGER) + 1 THEN ms END)
+	FROM r`
+
+// errorKindSQL — вид ошибки по её тексту (см. evaluator и orchestrator).
+const errorKindSQL = `CASE
+		WHEN error = 'division by zero' THEN 'division_by_zero'
+		WHEN error LIKE '% at position %' THEN 'syntax'
+		WHEN error LIKE 'expression % exceeds the limit of %' THEN 'too_large'
+		ELSE 'other'
+	END`
+
+// GetStats — GET /api/v1/me/stats?days=30
+// Статистика по выражениям пользователя за последние days дней (до 365): число по статусам,
+// задержка досчитанных (среднее и перцентили), самые частые операции, доля ошибок
+// по видам и число выражений по дням (UTC, дни без выражений — нулями).
+func GetStats(w http.ResponseWriter, r *http.Request) {
+	uid := r.Context().Value("user_id").(int)
+	days := defaultStatsDays
+	if v := r.URL.Query().Get("days"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n <= 0 || n > maxStatsDays {
+			apperrors.Write(w, r, apperrors.ErrInvalidField.WithField("days", "must be from 1 to 365"))
+			return
+		}
+		days = n
+	}
+	today := time.Now().UTC().Truncate(24 * time.Hour)
+	since := today.AddDate(0, 0, 1-days)
+
+	counts := map[string]int{"pending": 0, "in_progress": 0, "done": 0, "error": 0, "cancelled": 0}
+	total := 0
+	rows, err := db.Conn.Query(
+		"SELECT status, COUNT(*) FROM expressions WHERE user_id = ? AND created_at >= ? GROUP BY status", uid, since,
+	)
+	if err != nil {
+		apperrors.Write(w, r, apperrors.ErrInternalServer)
+		return
+	}
+	for rows.Next() {
+		var (
+			status string
+			n      int
+		)
+		rows.Scan(&status, &n)
+		counts[status] = n
+		total += n
+	}
+	rows.Close()
+
+	var (
+		done                             int
+		avg, slowest, p50, p90, p95, p99 sql.NullFloat64
+	)
+	if err := db.Conn.QueryRow(latencyQuery, uid, since).Scan(&done, &avg, &slowest, &p50, &p90, &p95, &p99); err != nil {
+		apperrors.Write(w, r, apperrors.ErrInternalServer)
+		return
+	}
+	latency := map[string]interface{}{"count": done}
+	if done > 0 {
+		for name, v := range map[string]sql.NullFloat64{
+			"avg_ms": avg, "p50_ms": p50, "p90_ms": p90, "p95_ms": p95, "p99_ms": p99, "max_ms": slowest,
+		} {
+			latency[name] = int64(v.Float64 + 0.5)
+		}
+	}
+
+	// Операции считаются по задачам, отданным агентам: выражения из кэша их не дают
+	rows, err = db.Conn.Query(
+		`SELECT t.op, COUNT(*) FROM tasks t JOIN expressions e ON e.id = t.expression_id
+		WHERE e.user_id = ? AND e.created_at >= ? GROUP BY t.op ORDER BY COUNT(*) DESC, t.op LIMIT ?`,
+		uid, since, statsTopOperations,
+	)
+	if err != nil {
+		apperrors.Write(w, r, apperrors.ErrInternalServer)
+		return
+	}
+	operations := []map[string]interface{}{}
+	for rows.Next() {
+		var (
+			op string
+			n  int
+		)
+		rows.Scan(&op, &n)
+		operations = append(operations, map[string]interface{}{"op": op, "count": n})
+	}
+	rows.Close()
+
+	rows, err = db.Conn.Query(
+		"SELECT "+errorKindSQL+" AS kind, COUNT(*) FROM expressions WHERE user_id = ? AND created_at >= ? AND status = 'error' GROUP BY kind",
+		uid, since,
+	)
+	if err != nil {
+		apperrors.Write(w, r, apperrors.ErrInternalServer)
+		return
+	}
+	errorKinds := map[string]int{}
+	for rows.Next() {
+		var (
+			kind string
+			n    int
+		)
+		rows.Scan(&kind, &n)
+		errorKinds[kind] = n
+	}
+	rows.Close()
+	// Доля — от завершённых (done и error): ожидающие ещё могут досчитаться
+	finished := counts["done"] + counts["error"]
+	errorRate := 0.0
+	if finished > 0 {
+		errorRate = float64(counts["error"]) / float64(finished)
+	}
+	errorsByKind := map[string]interface{}{}
+	for kind, n := range errorKinds {
+		errorsByKind[kind] = map[string]interface{}{"count": n, "rate": float64(n) / float64(finished)}
+	}
+
+	perDay := map[string][3]int{}
+	rows, err = db.Conn.Query(
+		`SELECT date(created_at) AS day, COUNT(*), SUM(status = 'done'), SUM(status = 'error')
+		FROM expressions WHERE user_id = ? AND created_at >= ? GROUP BY day`,
+		uid, since,
+	)
+	if err != nil {
+		apperrors.Write(w, r, apperrors.ErrInternalServer)
+		return
+	}
+	for rows.Next() {
+		var (
+			day         string
+			n, ok, fail int
+		)
+		rows.Scan(&day, &n, &ok, &fail)
+		perDay[day] = [3]int{n, ok, fail}
+	}
+	rows.Close()
+	histogram := []map[string]interface{}{}
+	for d := since; !d.After(today); d = d.AddDate(0, 0, 1) {
+		day := d.Format("2006-01-02")
+		c := perDay[day]
+		histogram = append(histogram, map[string]interface{}{"date": day, "total": c[0], "done": c[1], "error": c[2]})
+	}
+	json.NewEncoder(w).Encode(map[string]interface{}{"stats": map[string]interface{}{
+		"since":      since,
+		"days":       days,
+		"total":      total,
+		"counts":     counts,
+		"latency":    latency,
+		"operations": operations,
+		"errors": map[string]interface{}{
+			"count":   counts["error"],
+			"rate":    errorRate,
+			"by_kind": errorsByKind,
+		},
+		"daily": histogram,
+	}})
+}
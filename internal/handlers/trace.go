@@ -0,0 +1,224 @@
+package handlers
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+
+	"github.com/scriptoxin/yandex-liceum-go-calc/internal/evaluator"
+	"github.com/scriptoxin/yandex-liceum-go-calc/internal/orchestrator"
+	"github.com/scriptoxin/yandex-liceum-go-calc/pkg/db"
+	apperrors "github.com/scriptoxin/yandex-liceum-go-calc/pkg/errors"
+)
+
+// traceNode — узел дерева выражения в трассировке.
+// У операций result — итог последней успешной задачи этого узла.
+type traceNode struct {
+	ID     int        `json:"id"`
+	Op     string     `json:"op,omitempty"`
+	Value  *float64   `json:"value,omitempty"`
+	Result *float64   `json:"result,omitempty"`
+	Left   *traceNode `json:"left,omitempty"`
+	Right  *traceNode `json:"right,omitempty"`
+}
+
+// traceTask — попытка вычислить узел, из журнала задач оркестратора.
+type traceTask struct {
+	ID         string     `json:"id"`
+	Node       int        `json:"node"`
+	Op         string     `json:"op"`
+	Arg1       float64    `json:"arg1"`
+	Arg2       float64    `json:"arg2"`
+	Attempt    int        `json:"attempt"`
+	Status     string     `json:"status"`
+	Result     *float64   `json:"result,omitempty"`
+	Agent      string     `json:"agent,omitempty"`
+	SharedWith string     `json:"shared_with,omitempty"`
+	QueuedAt   time.Time  `json:"queued_at"`
+	IssuedAt   *time.Time `json:"issued_at,omitempty"`
+	FinishedAt *time.Time `json:"finished_at,omitempty"`
+}
+
+// ExpressionTrace — GET /api/v1/expressions/{id}/trace
+// Дерево выражения и все задачи оркестратора по нему. С format=dot или format=mermaid
+// вместо JSON отдаётся граф зависимостей в соответствующей нотации.
+func ExpressionTrace(w http.ResponseWriter, r *http.Request) {
+	ws, ok := resolveWorkspace(w, r)
+	if !ok {
+		return
+	}
+	id := mux.Vars(r)["id"]
+	cond, args := ws.cond()
+
+	format := r.URL.Query().Get("format")
+	if format != "" && format != "json" && format != "dot" && format != "mermaid" {
+		apperrors.Write(w, r, apperrors.ErrInvalidField.WithField("format", "must be json, dot or mermaid"))
+		return
+	}
+
+	var expr, status string
+	err := db.Conn.QueryRow(
+		"SELECT expression, status FROM expressions WHERE id = ? AND "+cond,
+		append([]interface{}{id}, args...)...,
+	).Scan(&expr, &status)
+	if err != nil {
+		apperrors.Write(w, r, apperrors.ErrNotFound)
+		return
+	}
+
+	tasks, err := loadTasks(id)
+	if err != nil {
+		apperrors.Write(w, r, apperrors.ErrInternalServer)
+		return
+	}
+	// Итог узла — результат последней успешной попытки
+	results := map[int]float64{}
+	for _, t := range tasks {
+		if t.Status == orchestrator.TaskDone && t.Result != nil {
+			results[t.Node] = *t.Result
+		}
+	}
+
+	var ast *traceNode
+	if root, err := evaluator.Parse(expr); err == nil {
+		ast = buildTraceNode(root, evaluator.Index(root), results)
+	}
+
+	switch format {
+	case "dot":
+		w.Header().Set("Content-Type", "text/vnd.graphviz; charset=utf-8")
+		fmt.Fprint(w, renderDOT(ast))
+	case "mermaid":
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		fmt.Fprint(w, renderMermaid(ast))
+	default:
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"id":         id,
+			"expression": expr,
+			"status":     status,
+			"ast":        ast,
+			"tasks":      tasks,
+		})
+	}
+}
+
+// loadTasks читает журнал задач выражения в порядке постановки в очередь.
+func loadTasks(exprID string) ([]traceTask, error) {
+	rows, err := db.Conn.Query(
+		`SELECT id, node, op, arg1, arg2, attempt, status, result, agent, shared_with, queued_at, issued_at, finished_at
+		FROM tasks WHERE expression_id = ? ORDER BY queued_at, rowid`,
+		exprID,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	list := []traceTask{}
+	for rows.Next() {
+		var (
+			t                traceTask
+			result           sql.NullFloat64
+			agent, shared    sql.NullString
+			issued, finished sql.NullTime
+		)
+		err := rows.Scan(&t.ID, &t.Node, &t.Op, &t.Arg1, &t.Arg2, &t.Attempt, &t.Status,
+			&result, &agent, &shared, &t.QueuedAt, &issued, &finished)
+		if err != nil {
+			return nil, err
+		}
+		if result.Valid {
+			t.Result = &result.Float64
+		}
+		t.Agent, t.SharedWith = agent.String, shared.String
+		if issued.Valid {
+			t.IssuedAt = &issued.Time
+		}
+		if finished.Valid {
+			t.FinishedAt = &finished.Time
+		}
+		list = append(list, t)
+	}
+	return list, rows.Err()
+}
+
+// buildTraceNode переводит дерево разбора в узлы трассировки с номерами из index.
+func buildTraceNode(n *evaluator.Node, index map[*evaluator.Node]int, results map[int]float64) *traceNode {
+	out := &traceNode{ID: index[n]}
+	if n.IsLeaf() {
+		value := n.Value
+		out.Value = &value
+		return out
+	}
+	out.Op = string(n.Op)
+	if v, ok := results[out.ID]; ok {
+		out.Result = &v
+	}
+	out.Left = buildTraceNode(n.Left, index, results)
+	out.Right = buildTraceNode(n.Right, index, results)
+	return out
+}
+
+// label — подпись узла на графе: число или операция с итогом, если он уже есть.
+func (n *traceNode) label() string {
+	if n.Value != nil {
+		return strconv.FormatFloat(*n.Value, 'f', -1, 64)
+	}
+	if n.Result != nil {
+		return n.Op + " = " + strconv.FormatFloat(*n.Result, 'f', -1, 64)
+	}
+	return n.Op
+}
+
+// walk обходит дерево, вызывая node для каждого узла и edge для каждой связи родитель → операнд.
+func (n *traceNode) walk(node func(*traceNode), edge func(parent, child *traceNode)) {
+	if n == nil {
+		return
+	}
+	node(n)
+	for _, child := range []*traceNode{n.Left, n.Right} {
+		if child != nil {
+			edge(n, child)
+			child.walk(node, edge)
+		}
+	}
+}
+
+// renderDOT рисует дерево выражения в нотации Graphviz.
+func renderDOT(ast *traceNode) string {
+	var b strings.Builder
+	b.WriteString("digraph expression {\n")
+	ast.walk(func(n *traceNode) {
+		shape := "box"
+		if n.Value != nil {
+			shape = "ellipse"
+		}
+		fmt.Fprintf(&b, "  n%d [label=%q, shape=%s];\n", n.ID, n.label(), shape)
+	}, func(parent, child *traceNode) {
+		fmt.Fprintf(&b, "  n%d -> n%d;\n", parent.ID, child.ID)
+	})
+	b.WriteString("}\n")
+	return b.String()
+}
+
+// renderMermaid рисует дерево выражения в нотации Mermaid.
+func renderMermaid(ast *traceNode) string {
+	var b strings.Builder
+	b.WriteString("graph TD\n")
+	ast.walk(func(n *traceNode) {
+		if n.Value != nil {
+			fmt.Fprintf(&b, "  n%d((\"%s\"))\n", n.ID, n.label())
+		} else {
+			fmt.Fprintf(&b, "  n%d[\"%s\"]\n", n.ID, n.label())
+		}
+	}, func(parent, child *traceNode) {
+		fmt.Fprintf(&b, "  n%d --> n%d\n", parent.ID, child.ID)
+	})
+	return b.String()
+}
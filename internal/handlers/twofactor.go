@@ -0,0 +1,287 @@
+package handlers
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"strings"
+
+	"github.com/scriptoxin/yandex-liceum-go-calc/pkg/db"
+	apperrors "github.com/scriptoxin/yandex-liceum-go-calc/pkg/errors"
+	"github.com/scriptoxin/yandex-liceum-go-calc/pkg/jwt"
+	"github.com/scriptoxin/yandex-liceum-go-calc/pkg/totp"
+)
+
+// totpIssuer показывается в приложении-аутентификаторе.
+const totpIssuer = "Calc"
+
+// recoveryCodeCount — сколько одноразовых кодов восстановления выдаётся при включении 2FA.
+const recoveryCodeCount = 10
+
+type setupTwoFactorRequest struct {
+	Password string `json:"password"`
+}
+
+type enableTwoFactorRequest struct {
+	Password string `json:"password"`
+	Code     string `json:"code"`
+}
+
+type disableTwoFactorRequest struct {
+	Password string `json:"password"`
+	Code     string `json:"code"`
+}
+
+type loginTwoFactorRequest struct {
+	Challenge    string `json:"challenge"`
+	Code         string `json:"code"`
+	RecoveryCode string `json:"recovery_code"`
+}
+
+// SetupTwoFactor — POST /api/v1/me/2fa/setup
+// Требует пароль и создаёт новый секрет; 2FA включается только после подтверждения кодом.
+func SetupTwoFactor(w http.ResponseWriter, r *http.Request) {
+	uid := r.Context().Value("user_id").(int)
+
+	var req setupTwoFactorRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		apperrors.Write(w, r, apperrors.ErrBadRequest)
+		return
+	}
+	if !checkPassword(uid, req.Password) {
+		apperrors.Write(w, r, apperrors.ErrWrongPassword)
+		return
+	}
+	var (
+		login   string
+		enabled bool
+	)
+	err := db.Conn.QueryRow("SELECT login, totp_enabled FROM users WHERE id = ?", uid).Scan(&login, &enabled)
+	if err != nil {
+		apperrors.Write(w, r, apperrors.ErrInternalServer)
+		return
+	}
+	if enabled {
+		apperrors.Write(w, r, apperrors.ErrTwoFactorEnabled)
+		return
+	}
+	secret, err := totp.GenerateSecret()
+	if err != nil {
+		apperrors.Write(w, r, apperrors.ErrInternalServer)
+		return
+	}
+	if _, err := db.Conn.Exec("UPDATE users SET totp_secret = ? WHERE id = ?", secret, uid); err != nil {
+		apperrors.Write(w, r, apperrors.ErrInternalServer)
+		return
+	}
+	json.NewEncoder(w).Encode(map[string]string{
+		"secret": secret,
+		"uri":    totp.URI(totpIssuer, login, secret),
+	})
+}
+
+// EnableTwoFactor — POST /api/v1/me/2fa/enable
+// Проверяет пароль и первый код из приложения, возвращает коды восстановления (показываются один раз).
+func EnableTwoFactor(w http.ResponseWriter, r *http.Request) {
+	uid := r.Context().Value("user_id").(int)
+
+	var req enableTwoFactorRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		apperrors.Write(w, r, apperrors.ErrBadRequest)
+		return
+	}
+	if !checkPassword(uid, req.Password) {
+		apperrors.Write(w, r, apperrors.ErrWrongPassword)
+		return
+	}
+	var (
+		secret  string
+		enabled bool
+	)
+	err := db.Conn.QueryRow("SELECT totp_secret, totp_enabled FROM users WHERE id = ?", uid).Scan(&secret, &enabled)
+	if err != nil {
+		apperrors.Write(w, r, apperrors.ErrInternalServer)
+		return
+	}
+	if enabled {
+		apperrors.Write(w, r, apperrors.ErrTwoFactorEnabled)
+		return
+	}
+	if secret == "" {
+		apperrors.Write(w, r, apperrors.ErrTwoFactorNotSetUp)
+		return
+	}
+	step, ok := totp.Validate(secret, req.Code)
+	if !ok {
+		apperrors.Write(w, r, apperrors.ErrWrongCode)
+		return
+	}
+
+	codes := make([]string, recoveryCodeCount)
+	tx, err := db.Conn.Begin()
+	if err != nil {
+		apperrors.Write(w, r, apperrors.ErrInternalServer)
+		return
+	}
+	defer tx.Rollback()
+	if _, err := tx.Exec("DELETE FROM recovery_codes WHERE user_id = ?", uid); err != nil {
+		apperrors.Write(w, r, apperrors.ErrInternalServer)
+		return
+	}
+	for i := range codes {
+		buf := make([]byte, 5)
+		if _, err := rand.Read(buf); err != nil {
+			apperrors.Write(w, r, apperrors.ErrInternalServer)
+			return
+		}
+		code := hex.EncodeToString(buf)
+		codes[i] = code[:5] + "-" + code[5:]
+		if _, err := tx.Exec("INSERT INTO recovery_codes(user_id, hash) VALUES(?, ?)", uid, hashRecoveryCode(codes[i])); err != nil {
+			apperrors.Write(w, r, apperrors.ErrInternalServer)
+			return
+		}
+	}
+	if _, err := tx.Exec("UPDATE users SET totp_enabled = 1, totp_last_step = ? WHERE id = ?", step, uid); err != nil {
+		apperrors.Write(w, r, apperrors.ErrInternalServer)
+		return
+	}
+	if err := tx.Commit(); err != nil {
+		apperrors.Write(w, r, apperrors.ErrInternalServer)
+		return
+	}
+	audit(r, uid, AuditTwoFactorEnable, "", nil)
+	json.NewEncoder(w).Encode(map[string]interface{}{"recovery_codes": codes})
+}
+
+// DisableTwoFactor — POST /api/v1/me/2fa/disable
+// Требует пароль и действующий код (или код восстановления).
+func DisableTwoFactor(w http.ResponseWriter, r *http.Request) {
+	uid := r.Context().Value("user_id").(int)
+
+	var req disableTwoFactorRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		apperrors.Write(w, r, apperrors.ErrBadRequest)
+		return
+	}
+	if !checkPassword(uid, req.Password) {
+		apperrors.Write(w, r, apperrors.ErrWrongPassword)
+		return
+	}
+	if !checkSecondFactor(uid, req.Code, req.Code) {
+		apperrors.Write(w, r, apperrors.ErrWrongCode)
+		return
+	}
+	tx, err := db.Conn.Begin()
+	if err != nil {
+		apperrors.Write(w, r, apperrors.ErrInternalServer)
+		return
+	}
+	defer tx.Rollback()
+	if _, err := tx.Exec("DELETE FROM recovery_codes WHERE user_id = ?", uid); err != nil {
+		apperrors.Write(w, r, apperrors.ErrInternalServer)
+		return
+	}
+	if _, err := tx.Exec("UPDATE users SET totp_enabled = 0, totp_secret = '', totp_last_step = 0 WHERE id = ?", uid); err != nil {
+		apperrors.Write(w, r, apperrors.ErrInternalServer)
+		return
+	}
+	if err := tx.Commit(); err != nil {
+		apperrors.Write(w, r, apperrors.ErrInternalServer)
+		return
+	}
+	audit(r, uid, AuditTwoFactorOff, "", nil)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// LoginTwoFactor — POST /api/v1/login/2fa
+// Второй шаг входа: challenge из /login и код из приложения или код восстановления.
+func LoginTwoFactor(w http.ResponseWriter, r *http.Request) {
+	var req loginTwoFactorRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		apperrors.Write(w, r, apperrors.ErrBadRequest)
+		return
+	}
+	uid, err := jwt.ParseChallenge(req.Challenge)
+	if err != nil {
+		apperrors.Write(w, r, apperrors.ErrInvalidChallenge)
+		return
+	}
+	var (
+		lockedUntil sql.NullTime
+		disabled    bool
+	)
+	err = db.Conn.QueryRow(
+		"SELECT locked_until, disabled FROM users WHERE id = ?", uid,
+	).Scan(&lockedUntil, &disabled)
+	if err != nil {
+		apperrors.Write(w, r, apperrors.ErrInvalidChallenge)
+		return
+	}
+	if locked(w, r, lockedUntil) {
+		audit(r, uid, AuditLoginFailure, "", map[string]interface{}{"reason": "locked", "step": "2fa"})
+		return
+	}
+	if disabled {
+		apperrors.Write(w, r, apperrors.ErrAccountDisabled)
+		return
+	}
+	if !checkSecondFactor(uid, req.Code, req.RecoveryCode) {
+		recordLoginFailure(uid)
+		audit(r, uid, AuditLoginFailure, "", map[string]interface{}{"reason": "invalid_code", "step": "2fa"})
+		apperrors.Write(w, r, apperrors.ErrInvalidCode)
+		return
+	}
+	method := "totp"
+	if req.Code == "" {
+		method = "recovery_code"
+	}
+	issueToken(w, r, uid, method)
+}
+
+// checkSecondFactor принимает TOTP-код (каждый не больше одного раза)
+// или неиспользованный код восстановления, который после этого гасится.
+func checkSecondFactor(uid int, code, recoveryCode string) bool {
+	var (
+		secret   string
+		lastStep int64
+	)
+	err := db.Conn.QueryRow(
+		"SELECT totp_secret, totp_last_step FROM users WHERE id = ? AND totp_enabled = 1", uid,
+	).Scan(&secret, &lastStep)
+	if err != nil {
+		return false
+	}
+	if code != "" {
+		if step, ok := totp.Validate(secret, code); ok && step > lastStep {
+			res, err := db.Conn.Exec(
+				"UPDATE users SET totp_last_step = ? WHERE id = ? AND totp_last_step < ?",
+				step, uid, step,
+			)
+			if err == nil {
+				if n, _ := res.RowsAffected(); n == 1 {
+					return true
+				}
+			}
+		}
+	}
+	if recoveryCode != "" {
+		res, err := db.Conn.Exec(
+			"UPDATE recovery_codes SET used_at = CURRENT_TIMESTAMP WHERE user_id = ? AND hash = ? AND used_at IS NULL",
+			uid, hashRecoveryCode(recoveryCode),
+		)
+		if err == nil {
+			if n, _ := res.RowsAffected(); n == 1 {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func hashRecoveryCode(code string) string {
+	sum := sha256.Sum256([]byte(strings.ToLower(strings.TrimSpace(code))))
+	return hex.EncodeToString(sum[:])
+}
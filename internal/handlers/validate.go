@@ -0,0 +1,69 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+
+	"github.com/scriptoxin/yandex-liceum-go-calc/internal/evaluator"
+	apperrors "github.com/scriptoxin/yandex-liceum-go-calc/pkg/errors"
+)
+
+// ExprLimits — ограничения на размер принимаемых выражений; задаются в main.
+var ExprLimits = evaluator.Limits{
+	MaxLength: 1000,
+	MaxDepth:  100,
+	MaxNodes:  1000,
+}
+
+// ValidateExpression — POST /api/v1/validate
+// Проверяет выражение так же, как Calculate, но ничего не сохраняет.
+// Корректное — 200 с размерами, некорректное — та же 422, что вернул бы Calculate.
+func ValidateExpression(w http.ResponseWriter, r *http.Request) {
+	var req calcRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		apperrors.Write(w, r, apperrors.ErrBadRequest)
+		return
+	}
+	stats, appErr := checkExpression("expression", req.Expression)
+	if appErr != nil {
+		apperrors.Write(w, r, appErr)
+		return
+	}
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"valid":  true,
+		"length": stats.Length,
+		"depth":  stats.Depth,
+		"nodes":  stats.Nodes,
+		"limits": map[string]int{
+			"length": ExprLimits.MaxLength,
+			"depth":  ExprLimits.MaxDepth,
+			"nodes":  ExprLimits.MaxNodes,
+		},
+	})
+}
+
+// checkExpression проверяет выражение по ExprLimits. Для некорректного возвращает
+// invalid_expression или expression_too_large с описанием проблемы в поле field.
+func checkExpression(field, expr string) (evaluator.Stats, *apperrors.AppError) {
+	_, stats, err := evaluator.Validate(expr, ExprLimits)
+	if err == nil {
+		return stats, nil
+	}
+	base := apperrors.ErrInvalidExpression
+	var limit *evaluator.LimitError
+	if errors.As(err, &limit) {
+		base = apperrors.ErrExpressionTooLarge
+	}
+	message, position := describeExpressionError(err)
+	return stats, base.WithFieldAt(field, message, position)
+}
+
+// describeExpressionError — текст ошибки разбора и позиция, если она известна.
+func describeExpressionError(err error) (string, int) {
+	var syntax *evaluator.SyntaxError
+	if errors.As(err, &syntax) {
+		return syntax.Message, syntax.Position
+	}
+	return err.Error(), 0
+}
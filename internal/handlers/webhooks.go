@@ -0,0 +1,308 @@
+package handlers
+
+import (
+	"database/sql"
+	"encoding/json"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+
+	"github.com/scriptoxin/yandex-liceum-go-calc/internal/orchestrator"
+	"github.com/scriptoxin/yandex-liceum-go-calc/pkg/db"
+	apperrors "github.com/scriptoxin/yandex-liceum-go-calc/pkg/errors"
+)
+
+// webhookEvents — события, на которые можно подписаться.
+var webhookEvents = []string{"expression.done", "expression.error", "expression.cancelled"}
+
+type webhookRequest struct {
+	URL string `json:"url"`
+	// Events — на какие события подписка; пустой список — на все
+	Events []string `json:"events"`
+}
+
+// checkCallbackURL проверяет адрес для вебхука: абсолютный http или https и не явно
+// внутренний (localhost или внутренний IP). Имена, которые разрешаются во внутренние
+// адреса, отсекает уже отправитель при подключении (см. orchestrator.NewWebhooks).
+func checkCallbackURL(field, raw string) *apperrors.AppError {
+	u, err := url.Parse(raw)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return apperrors.ErrInvalidField.WithField(field, "must be an absolute http or https URL")
+	}
+	if len(raw) > 2048 {
+		return apperrors.ErrInvalidField.WithField(field, "must be at most 2048 characters")
+	}
+	if Orch != nil && Orch.Webhooks != nil && Orch.Webhooks.AllowPrivate {
+		return nil
+	}
+	host := strings.ToLower(strings.TrimSuffix(u.Hostname(), "."))
+	ip := net.ParseIP(host)
+	if host == "localhost" || strings.HasSuffix(host, ".localhost") || (ip != nil && orchestrator.IsPrivateIP(ip)) {
+		return apperrors.ErrInvalidField.WithField(field, "must not point to a private or loopback address")
+	}
+	return nil
+}
+
+// CreateWebhook — POST /api/v1/webhooks
+// {"url":"https://example.com/hook","events":["expression.done"]}: итоги всех выражений
+// пользователя будут приходить на url, подписанные секретом из /webhooks/secret.
+func CreateWebhook(w http.ResponseWriter, r *http.Request) {
+	uid := r.Context().Value("user_id").(int)
+
+	var req webhookRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		apperrors.Write(w, r, apperrors.ErrBadRequest)
+		return
+	}
+	if appErr := checkCallbackURL("url", req.URL); appErr != nil {
+		apperrors.Write(w, r, appErr)
+		return
+	}
+	for i, e := range req.Events {
+		known := false
+		for _, k := range webhookEvents {
+			known = known || e == k
+		}
+		if !known {
+			apperrors.Write(w, r, apperrors.ErrInvalidField.WithField("events["+strconv.Itoa(i)+"]",
+				"must be one of "+strings.Join(webhookEvents, ", ")))
+			return
+		}
+	}
+	now := time.Now().UTC()
+	res, err := db.Conn.Exec(
+		"INSERT INTO webhooks(user_id, url, events, created_at) VALUES(?, ?, ?, ?)",
+		uid, req.URL, strings.Join(req.Events, ","), now,
+	)
+	if err != nil {
+		apperrors.Write(w, r, apperrors.ErrInternalServer)
+		return
+	}
+	id, _ := res.LastInsertId()
+	audit(r, uid, AuditWebhookCreate, strconv.FormatInt(id, 10), map[string]interface{}{"url": req.URL})
+	if req.Events == nil {
+		req.Events = []string{}
+	}
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(map[string]interface{}{"webhook": map[string]interface{}{
+		"id": id, "url": req.URL, "events": req.Events, "created_at": now,
+	}})
+}
+
+// ListWebhooks — GET /api/v1/webhooks
+func ListWebhooks(w http.ResponseWriter, r *http.Request) {
+	uid := r.Context().Value("user_id").(int)
+	rows, err := db.Conn.Query("SELECT id, url, events, created_at FROM webhooks WHERE user_id = ? ORDER BY id", uid)
+	if err != nil {
+		apperrors.Write(w, r, apperrors.ErrInternalServer)
+		return
+	}
+	defer rows.Close()
+
+	list := []map[string]interface{}{}
+	for rows.Next() {
+		var (
+			id          int64
+			u, events   string
+			createdAt   time.Time
+			eventsSlice = []string{}
+		)
+		rows.Scan(&id, &u, &events, &createdAt)
+		if events != "" {
+			eventsSlice = strings.Split(events, ",")
+		}
+		list = append(list, map[string]interface{}{"id": id, "url": u, "events": eventsSlice, "created_at": createdAt})
+	}
+	json.NewEncoder(w).Encode(map[string]interface{}{"webhooks": list})
+}
+
+// DeleteWebhook — DELETE /api/v1/webhooks/{id}
+// Журнал доставок по подписке остаётся.
+func DeleteWebhook(w http.ResponseWriter, r *http.Request) {
+	uid := r.Context().Value("user_id").(int)
+	id := mux.Vars(r)["id"]
+	res, err := db.Conn.Exec("DELETE FROM webhooks WHERE id = ? AND user_id = ?", id, uid)
+	if err != nil {
+		apperrors.Write(w, r, apperrors.ErrInternalServer)
+		return
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		apperrors.Write(w, r, apperrors.ErrNotFound)
+		return
+	}
+	audit(r, uid, AuditWebhookDelete, id, nil)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// GetWebhookSecret — GET /api/v1/webhooks/secret
+// Секрет, которым подписываются все вебхуки пользователя (создаётся при первом запросе).
+func GetWebhookSecret(w http.ResponseWriter, r *http.Request) {
+	secret, err := orchestrator.WebhookSecret(r.Context().Value("user_id").(int))
+	if err != nil {
+		apperrors.Write(w, r, apperrors.ErrInternalServer)
+		return
+	}
+	json.NewEncoder(w).Encode(map[string]string{"secret": secret})
+}
+
+// RotateWebhookSecret — POST /api/v1/webhooks/secret/rotate
+// Новый секрет действует сразу, в том числе для повторов уже поставленных доставок.
+func RotateWebhookSecret(w http.ResponseWriter, r *http.Request) {
+	uid := r.Context().Value("user_id").(int)
+	secret, err := orchestrator.RotateWebhookSecret(uid)
+	if err != nil {
+		apperrors.Write(w, r, apperrors.ErrInternalServer)
+		return
+	}
+	audit(r, uid, AuditWebhookSecret, "", nil)
+	json.NewEncoder(w).Encode(map[string]string{"secret": secret})
+}
+
+// deliveryColumns — столбцы, которые читает scanDelivery.
+const deliveryColumns = "id, webhook_id, expression_id, event, url, status, attempts, next_attempt_at, last_error, created_at, delivered_at"
+
+func scanDelivery(row rowScanner) (map[string]interface{}, error) {
+	var (
+		id, exprID, event, u, status string
+		webhookID                    sql.NullInt64
+		attempts                     int
+		next, delivered              sql.NullTime
+		lastError                    sql.NullString
+		created                      time.Time
+	)
+	err := row.Scan(&id, &webhookID, &exprID, &event, &u, &status, &attempts, &next, &lastError, &created, &delivered)
+	if err != nil {
+		return nil, err
+	}
+	out := map[string]interface{}{
+		"id":            id,
+		"expression_id": exprID,
+		"event":         event,
+		"url":           u,
+		"status":        status,
+		"attempts":      attempts,
+		"created_at":    created,
+	}
+	if webhookID.Valid {
+		out["webhook_id"] = webhookID.Int64
+	}
+	if next.Valid {
+		out["next_attempt_at"] = next.Time
+	}
+	if lastError.Valid {
+		out["last_error"] = lastError.String
+	}
+	if delivered.Valid {
+		out["delivered_at"] = delivered.Time
+	}
+	return out, nil
+}
+
+// ListWebhookDeliveries — GET /api/v1/webhooks/deliveries
+// Журнал доставок, новые сверху. ?status=dead — недоставленные, ?expression_id=, ?limit= (до 200).
+func ListWebhookDeliveries(w http.ResponseWriter, r *http.Request) {
+	uid := r.Context().Value("user_id").(int)
+	q := r.URL.Query()
+	conds, args := []string{"user_id = ?"}, []interface{}{uid}
+	for _, param := range []string{"status", "expression_id"} {
+		if v := q.Get(param); v != "" {
+			conds = append(conds, param+" = ?")
+			args = append(args, v)
+		}
+	}
+	limit := defaultPageSize
+	if v := q.Get("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n <= 0 {
+			apperrors.Write(w, r, apperrors.ErrInvalidField.WithField("limit", "must be a positive number"))
+			return
+		}
+		limit = min(n, maxPageSize)
+	}
+	rows, err := db.Conn.Query(
+		"SELECT "+deliveryColumns+" FROM webhook_deliveries WHERE "+strings.Join(conds, " AND ")+
+			" ORDER BY created_at DESC, rowid DESC LIMIT "+strconv.Itoa(limit),
+		args...,
+	)
+	if err != nil {
+		apperrors.Write(w, r, apperrors.ErrInternalServer)
+		return
+	}
+	defer rows.Close()
+
+	list := []map[string]interface{}{}
+	for rows.Next() {
+		d, err := scanDelivery(rows)
+		if err != nil {
+			apperrors.Write(w, r, apperrors.ErrInternalServer)
+			return
+		}
+		list = append(list, d)
+	}
+	json.NewEncoder(w).Encode(map[string]interface{}{"deliveries": list})
+}
+
+// GetWebhookDelivery — GET /api/v1/webhooks/deliveries/{id}
+// Доставка с отправленным телом и всеми попытками: код ответа, ошибка, длительность.
+func GetWebhookDelivery(w http.ResponseWriter, r *http.Request) {
+	uid := r.Context().Value("user_id").(int)
+	id := mux.Vars(r)["id"]
+
+	var payload string
+	d, err := scanDelivery(db.Conn.QueryRow(
+		"SELECT "+deliveryColumns+" FROM webhook_deliveries WHERE id = ? AND user_id = ?", id, uid,
+	))
+	if err == nil {
+		err = db.Conn.QueryRow("SELECT payload FROM webhook_deliveries WHERE id = ?", id).Scan(&payload)
+	}
+	if err != nil {
+		apperrors.Write(w, r, apperrors.ErrNotFound)
+		return
+	}
+	d["payload"] = json.RawMessage(payload)
+
+	rows, err := db.Conn.Query(
+		"SELECT attempt, status_code, error, duration_ms, at FROM webhook_attempts WHERE delivery_id = ? ORDER BY attempt", id,
+	)
+	if err != nil {
+		apperrors.Write(w, r, apperrors.ErrInternalServer)
+		return
+	}
+	defer rows.Close()
+	attempts := []map[string]interface{}{}
+	for rows.Next() {
+		var (
+			n, durationMS int
+			code          sql.NullInt64
+			errText       sql.NullString
+			at            time.Time
+		)
+		rows.Scan(&n, &code, &errText, &durationMS, &at)
+		a := map[string]interface{}{"attempt": n, "duration_ms": durationMS, "at": at}
+		if code.Valid {
+			a["status_code"] = code.Int64
+		}
+		if errText.Valid {
+			a["error"] = errText.String
+		}
+		attempts = append(attempts, a)
+	}
+	d["log"] = attempts
+	json.NewEncoder(w).Encode(map[string]interface{}{"delivery": d})
+}
+
+// RetryWebhookDelivery — POST /api/v1/webhooks/deliveries/{id}/retry
+// Возвращает недоставленную (dead) доставку в очередь с полным набором попыток.
+func RetryWebhookDelivery(w http.ResponseWriter, r *http.Request) {
+	uid := r.Context().Value("user_id").(int)
+	if Orch == nil || Orch.Webhooks == nil || !Orch.Webhooks.Retry(mux.Vars(r)["id"], uid) {
+		apperrors.Write(w, r, apperrors.ErrNotFound)
+		return
+	}
+	w.WriteHeader(http.StatusAccepted)
+}
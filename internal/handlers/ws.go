@@ -0,0 +1,261 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+
+	apperrors "github.com/scriptoxin/yandex-liceum-go-calc/pkg/errors"
+)
+
+// Параметры WebSocket-соединения. Переменные, а не константы, чтобы тесты могли их ужать.
+var (
+	// wsPingPeriod — как часто сервер шлёт ping; клиент должен ответить pong за wsPongWait.
+	wsPingPeriod = 30 * time.Second
+	wsPongWait   = 60 * time.Second
+	wsWriteWait  = 10 * time.Second
+	// wsSendBuffer — сколько кадров может ждать отправки; клиент, который не успевает
+	// их забирать, отключается.
+	wsSendBuffer = 64
+	// wsRate и wsBurst — сколько выражений в секунду можно отправить по одному соединению.
+	wsRate  = 5.0
+	wsBurst = 10.0
+)
+
+// wsMaxMessage — предельный размер сообщения от клиента.
+const wsMaxMessage = 4096
+
+// wsTokenProtocol — подпротокол, за которым браузер передаёт токен:
+// new WebSocket(url, ["bearer", token]). Браузерный WebSocket не умеет
+// ставить заголовок Authorization, а Sec-WebSocket-Protocol — умеет.
+const wsTokenProtocol = "bearer"
+
+// Сервер отвечает подпротоколом bearer, сам токен обратно не отправляется
+var wsUpgrader = websocket.Upgrader{
+	ReadBufferSize:  1024,
+	WriteBufferSize: 1024,
+	Subprotocols:    []string{wsTokenProtocol},
+}
+
+// wsProtocolToken достаёт токен из Sec-WebSocket-Protocol: "bearer, <token>".
+// Только для запроса на открытие /ws, на остальных маршрутах — пустая строка.
+func wsProtocolToken(r *http.Request) string {
+	if !strings.HasSuffix(r.URL.Path, "/ws") || !websocket.IsWebSocketUpgrade(r) {
+		return ""
+	}
+	protocols := websocket.Subprotocols(r)
+	for i := 0; i+1 < len(protocols); i++ {
+		if protocols[i] == wsTokenProtocol {
+			return protocols[i+1]
+		}
+	}
+	return ""
+}
+
+// wsMessage — сообщение клиента. Ref возвращается в ответе как есть,
+// чтобы клиент мог сопоставить его со своим запросом.
+type wsMessage struct {
+	Type       string `json:"type"`
+	Ref        string `json:"ref"`
+	Expression string `json:"expression"`
+	Priority   int    `json:"priority"`
+}
+
+// wsFrame — сообщение сервера: accepted, status, error или pong.
+type wsFrame struct {
+	Type   string   `json:"type"`
+	Ref    string   `json:"ref,omitempty"`
+	ID     string   `json:"id,omitempty"`
+	Status string   `json:"status,omitempty"`
+	Result *float64 `json:"result,omitempty"`
+	Code   string   `json:"code,omitempty"`
+	Error  string   `json:"error,omitempty"`
+}
+
+// wsConn — одно подключение: читатель разбирает сообщения клиента, писатель
+// отправляет кадры из out. Все кадры идут через out, поэтому accepted
+// всегда приходит раньше статусов того же выражения.
+type wsConn struct {
+	conn *websocket.Conn
+	out  chan wsFrame
+	done chan struct{}
+	once sync.Once
+
+	// токены для ограничения частоты отправки
+	tokens float64
+	last   time.Time
+}
+
+// WebSocket — GET /api/v1/ws
+// Клиент шлёт {"type":"calculate","expression":"2+2","ref":"1"} и получает
+// {"type":"accepted","ref":"1","id":"..."}, затем кадры status о выражениях рабочего
+// пространства (?workspace=): своих или всех выражениях организации.
+// {"type":"ping"} — проверка связи на уровне приложения, ответ {"type":"pong"}.
+func WebSocket(w http.ResponseWriter, r *http.Request) {
+	if Orch == nil {
+		apperrors.Write(w, r, apperrors.ErrNotFound)
+		return
+	}
+	uid := r.Context().Value("user_id").(int)
+	role, _ := r.Context().Value("role").(string)
+	ws, ok := resolveWorkspace(w, r)
+	if !ok {
+		return
+	}
+
+	// Подписка снимается последней: к этому моменту соединение уже закрыто,
+	// и пересылающая горутина не примет закрытие канала за медленного клиента
+	events, cancel, _ := Orch.Bus.Subscribe("", ws.matches)
+	defer cancel()
+
+	conn, err := wsUpgrader.Upgrade(w, r, nil)
+	if err != nil {
+		// Upgrade уже ответил клиенту
+		return
+	}
+	c := &wsConn{
+		conn:   conn,
+		out:    make(chan wsFrame, wsSendBuffer),
+		done:   make(chan struct{}),
+		tokens: wsBurst,
+		last:   time.Now(),
+	}
+	defer c.close(websocket.CloseNormalClosure, "")
+
+	go c.writeLoop()
+	go func() {
+		for e := range events {
+			if !c.send(wsFrame{Type: "status", ID: e.ExpressionID, Status: e.Status, Result: e.Result}) {
+				return
+			}
+		}
+		// шина отключила нас как медленного подписчика
+		c.close(websocket.CloseTryAgainLater, "client is too slow")
+	}()
+
+	conn.SetReadLimit(wsMaxMessage)
+	conn.SetReadDeadline(time.Now().Add(wsPongWait))
+	conn.SetPongHandler(func(string) error {
+		return conn.SetReadDeadline(time.Now().Add(wsPongWait))
+	})
+	for {
+		_, data, err := conn.ReadMessage()
+		if err != nil {
+			return
+		}
+		var msg wsMessage
+		if err := json.Unmarshal(data, &msg); err != nil {
+			c.send(wsError("", apperrors.ErrBadRequest))
+			continue
+		}
+		switch msg.Type {
+		case "ping":
+			c.send(wsFrame{Type: "pong", Ref: msg.Ref})
+		case "calculate":
+			if role != RoleUser && role != RoleAdmin {
+				c.send(wsError(msg.Ref, apperrors.ErrForbidden))
+				continue
+			}
+			if !c.allow() {
+				c.send(wsError(msg.Ref, apperrors.ErrRateLimited))
+				continue
+			}
+			_, appErr := checkExpression("expression", msg.Expression)
+			if appErr == nil {
+				appErr = checkPriority("priority", msg.Priority)
+			}
+			var id string
+			if appErr == nil {
+				appErr = withQuota(nil, uid, role, 1, func() (err error) {
+					id, err = insertExpression(r, uid, ws, msg.Expression, msg.Priority, "")
+					return err
+				})
+			}
+			if appErr != nil {
+				c.send(wsError(msg.Ref, appErr))
+				continue
+			}
+			if !c.send(wsFrame{Type: "accepted", Ref: msg.Ref, ID: id}) {
+				return
+			}
+			Orch.SubmitPriority(id, uid, msg.Expression, msg.Priority)
+		default:
+			c.send(wsError(msg.Ref, apperrors.ErrInvalidField.WithField("type", "must be calculate or ping")))
+		}
+	}
+}
+
+// send ставит кадр в очередь. Если очередь полна, клиент не успевает
+// читать — соединение закрывается, и send возвращает false.
+func (c *wsConn) send(f wsFrame) bool {
+	select {
+	case <-c.done:
+		return false
+	default:
+	}
+	select {
+	case c.out <- f:
+		return true
+	default:
+		c.close(websocket.CloseTryAgainLater, "client is too slow")
+		return false
+	}
+}
+
+// writeLoop — единственный писатель в соединение: кадры из out и ping.
+func (c *wsConn) writeLoop() {
+	ping := time.NewTicker(wsPingPeriod)
+	defer ping.Stop()
+	for {
+		select {
+		case f := <-c.out:
+			c.conn.SetWriteDeadline(time.Now().Add(wsWriteWait))
+			if err := c.conn.WriteJSON(f); err != nil {
+				c.close(websocket.CloseAbnormalClosure, "")
+				return
+			}
+		case <-ping.C:
+			if err := c.conn.WriteControl(websocket.PingMessage, nil, time.Now().Add(wsWriteWait)); err != nil {
+				c.close(websocket.CloseAbnormalClosure, "")
+				return
+			}
+		case <-c.done:
+			return
+		}
+	}
+}
+
+// close закрывает соединение один раз, по возможности с кадром close.
+func (c *wsConn) close(code int, reason string) {
+	c.once.Do(func() {
+		close(c.done)
+		if code != websocket.CloseAbnormalClosure {
+			c.conn.WriteControl(websocket.CloseMessage, websocket.FormatCloseMessage(code, reason), time.Now().Add(wsWriteWait))
+		}
+		c.conn.Close()
+	})
+}
+
+// allow — ведро токенов: wsRate выражений в секунду, не больше wsBurst подряд.
+// Вызывается только из читающей горутины.
+func (c *wsConn) allow() bool {
+	now := time.Now()
+	c.tokens += now.Sub(c.last).Seconds() * wsRate
+	c.last = now
+	if c.tokens > wsBurst {
+		c.tokens = wsBurst
+	}
+	if c.tokens < 1 {
+		return false
+	}
+	c.tokens--
+	return true
+}
+
+func wsError(ref string, err *apperrors.AppError) wsFrame {
+	return wsFrame{Type: "error", Ref: ref, Code: err.Code, Error: err.Message}
+}
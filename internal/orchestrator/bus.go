@@ -0,0 +1,146 @@
+package orchestrator
+
+import (
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Статусы выражения, о которых сообщает шина.
+const (
+	StatusPending    = "pending"
+	StatusInProgress = "in_progress"
+	StatusDone       = "done"
+	StatusError      = "error"
+	StatusCancelled  = "cancelled"
+)
+
+// busHistory — сколько последних событий хранится для переподключения по Last-Event-ID.
+const busHistory = 1024
+
+// subscriberBuffer — сколько событий может ждать медленный подписчик,
+// прежде чем шина его отключит.
+const subscriberBuffer = 64
+
+// Event — смена статуса выражения.
+type Event struct {
+	ID           string `json:"-"`
+	ExpressionID string `json:"id"`
+	UserID       int    `json:"-"`
+	// OrgID — организация, в пространстве которой выражение; 0 — личное
+	OrgID  int      `json:"-"`
+	Status string   `json:"status"`
+	Result *float64 `json:"result,omitempty"`
+	seq    int64
+}
+
+// Final сообщает, что после этого события статус выражения уже не изменится.
+func (e Event) Final() bool {
+	return e.Status == StatusDone || e.Status == StatusError || e.Status == StatusCancelled
+}
+
+type subscriber struct {
+	ch    chan Event
+	match func(Event) bool
+}
+
+// Bus — внутренняя шина событий о выражениях. Хранит хвост истории,
+// чтобы переподключившийся клиент получил пропущенные события.
+type Bus struct {
+	mu      sync.Mutex
+	epoch   string
+	seq     int64
+	history []Event
+	subs    map[*subscriber]struct{}
+}
+
+// NewBus создаёт пустую шину. ID событий включают время запуска,
+// поэтому ID из прошлого запуска не спутаются с новыми.
+func NewBus() *Bus {
+	return &Bus{
+		epoch: strconv.FormatInt(time.Now().UnixNano(), 36),
+		subs:  make(map[*subscriber]struct{}),
+	}
+}
+
+// Publish рассылает событие подписчикам. Подписчик, который не успевает
+// читать, отключается: его канал закрывается.
+func (b *Bus) Publish(e Event) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.seq++
+	e.seq = b.seq
+	e.ID = b.epoch + "-" + strconv.FormatInt(b.seq, 10)
+	b.history = append(b.history, e)
+	if len(b.history) > busHistory {
+		b.history = b.history[len(b.history)-busHistory:]
+	}
+	for s := range b.subs {
+		if !s.match(e) {
+			continue
+		}
+		select {
+		case s.ch <- e:
+		default:
+			delete(b.subs, s)
+			close(s.ch)
+		}
+	}
+}
+
+// Subscribe подписывает на события, для которых match возвращает true.
+// Если задан lastEventID, сначала отдаются подходящие события после него из истории;
+// complete == false, если часть из них уже потеряна (или ID из прошлого запуска)
+// и клиенту стоит перечитать состояние. cancel снимает подписку.
+func (b *Bus) Subscribe(lastEventID string, match func(Event) bool) (events <-chan Event, cancel func(), complete bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	var replay []Event
+	complete = true
+	if lastEventID != "" {
+		after, ok := b.parseID(lastEventID)
+		switch {
+		case !ok:
+			complete = false
+		case len(b.history) > 0 && after < b.history[0].seq-1:
+			complete = false
+		}
+		for _, e := range b.history {
+			if e.seq > after && match(e) {
+				replay = append(replay, e)
+			}
+		}
+	}
+
+	s := &subscriber{ch: make(chan Event, subscriberBuffer+len(replay)), match: match}
+	for _, e := range replay {
+		s.ch <- e
+	}
+	b.subs[s] = struct{}{}
+
+	cancel = func() {
+		b.mu.Lock()
+		defer b.mu.Unlock()
+		if _, ok := b.subs[s]; ok {
+			delete(b.subs, s)
+			close(s.ch)
+		}
+	}
+	return s.ch, cancel, complete
+}
+
+// parseID разбирает ID вида "<epoch>-<seq>". ID из другого запуска не принимается.
+func (b *Bus) parseID(id string) (int64, bool) {
+	epoch, seq, ok := strings.Cut(id, "-")
+	if !ok || epoch != b.epoch {
+		return 0, false
+	}
+	n, err := strconv.ParseInt(seq, 10, 64)
+	if err != nil || n > b.seq {
+		return 0, false
+	}
+	return n, true
+}
@@ -0,0 +1,153 @@
+package orchestrator
+
+import (
+	"container/list"
+	"database/sql"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/scriptoxin/yandex-liceum-go-calc/pkg/db"
+)
+
+// EvalMode — как агенты считают задачи: арифметика float64 из evaluator.Calc.
+// Результаты в кэше помечены режимом и при его смене не используются,
+// поэтому значение нужно менять при любом изменении арифметики.
+const EvalMode = "float64/v1"
+
+// DefaultCacheSize — сколько результатов кэш держит в памяти.
+const DefaultCacheSize = 10000
+
+// Cache — кэш результатов выражений по канонической записи (evaluator.Canonical).
+// В памяти — LRU на capacity записей, за ним таблица result_cache в SQLite,
+// которая переживает перезапуск.
+type Cache struct {
+	mu       sync.Mutex
+	mode     string
+	capacity int
+	order    *list.List
+	items    map[string]*list.Element
+	hits     int64
+	misses   int64
+}
+
+type cacheEntry struct {
+	key   string
+	value float64
+}
+
+// NewCache создаёт кэш для режима mode и удаляет из БД результаты других режимов.
+func NewCache(capacity int, mode string) *Cache {
+	if _, err := db.Conn.Exec("DELETE FROM result_cache WHERE mode != ?", mode); err != nil {
+		log.Printf("cache cleanup: %v", err)
+	}
+	return &Cache{
+		mode:     mode,
+		capacity: capacity,
+		order:    list.New(),
+		items:    make(map[string]*list.Element),
+	}
+}
+
+// Get ищет результат сначала в памяти, затем в БД.
+func (c *Cache) Get(key string) (float64, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if el, ok := c.items[key]; ok {
+		c.order.MoveToFront(el)
+		c.hits++
+		return el.Value.(*cacheEntry).value, true
+	}
+	var value float64
+	err := db.Conn.QueryRow("SELECT result FROM result_cache WHERE key = ? AND mode = ?", key, c.mode).Scan(&value)
+	if err != nil {
+		if err != sql.ErrNoRows {
+			log.Printf("cache get: %v", err)
+		}
+		c.misses++
+		return 0, false
+	}
+	c.remember(key, value)
+	c.hits++
+	return value, true
+}
+
+// Put сохраняет результат в памяти и в БД.
+func (c *Cache) Put(key string, value float64) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.remember(key, value)
+	_, err := db.Conn.Exec(
+		"INSERT OR REPLACE INTO result_cache(key, mode, result, created_at) VALUES(?, ?, ?, ?)",
+		key, c.mode, value, time.Now().UTC(),
+	)
+	if err != nil {
+		log.Printf("cache put: %v", err)
+	}
+}
+
+// Purge очищает кэш целиком, в памяти и в БД.
+func (c *Cache) Purge() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.order.Init()
+	c.items = make(map[string]*list.Element)
+	_, err := db.Conn.Exec("DELETE FROM result_cache")
+	return err
+}
+
+// remember кладёт запись в начало LRU и вытесняет самую старую, если места нет.
+func (c *Cache) remember(key string, value float64) {
+	if el, ok := c.items[key]; ok {
+		el.Value.(*cacheEntry).value = value
+		c.order.MoveToFront(el)
+		return
+	}
+	c.items[key] = c.order.PushFront(&cacheEntry{key, value})
+	if c.order.Len() > c.capacity {
+		oldest := c.order.Back()
+		c.order.Remove(oldest)
+		delete(c.items, oldest.Value.(*cacheEntry).key)
+	}
+}
+
+// CacheStats — счётчики кэша и объединения задач для администратора.
+type CacheStats struct {
+	Enabled   bool    `json:"enabled"`
+	Mode      string  `json:"mode,omitempty"`
+	Capacity  int     `json:"capacity"`
+	InMemory  int     `json:"in_memory"`
+	Stored    int     `json:"stored"`
+	Hits      int64   `json:"hits"`
+	Misses    int64   `json:"misses"`
+	HitRate   float64 `json:"hit_rate"`
+	Coalesced int64   `json:"coalesced_tasks"`
+}
+
+// stats заполняет счётчики самого кэша.
+func (c *Cache) stats(s *CacheStats) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	s.Enabled, s.Mode, s.Capacity = true, c.mode, c.capacity
+	s.InMemory = c.order.Len()
+	s.Hits, s.Misses = c.hits, c.misses
+	if total := c.hits + c.misses; total > 0 {
+		s.HitRate = float64(c.hits) / float64(total)
+	}
+	db.Conn.QueryRow("SELECT COUNT(*) FROM result_cache WHERE mode = ?", c.mode).Scan(&s.Stored)
+}
+
+// CacheStats возвращает счётчики кэша результатов и объединения задач.
+func (o *Orchestrator) CacheStats() CacheStats {
+	o.mu.Lock()
+	s := CacheStats{Coalesced: o.coalesced}
+	o.mu.Unlock()
+	if o.Cache != nil {
+		o.Cache.stats(&s)
+	}
+	return s
+}
@@ -0,0 +1,746 @@
+package orchestrator
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"log"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"google.golang.org/grpc/peer"
+
+	"github.com/scriptoxin/yandex-liceum-go-calc/internal/evaluator"
+	"github.com/scriptoxin/yandex-liceum-go-calc/pkg/db"
+	apperrors "github.com/scriptoxin/yandex-liceum-go-calc/pkg/errors"
+	pb "github.com/scriptoxin/yandex-liceum-go-calc/proto"
+)
+
+// errDivisionByZero — ошибка выражения, обнаруженная до отправки задачи агенту.
+var errDivisionByZero = errors.New("division by zero")
+
+// agentTTL — сколько агент считается подключённым после последнего запроса.
+const agentTTL = 30 * time.Second
+
+// DefaultLease — сколько у агента есть времени на задачу, прежде чем её отдадут другому.
+const DefaultLease = 2 * time.Minute
+
+// Состояния задачи в журнале (таблица tasks).
+const (
+	TaskQueued    = "queued"
+	TaskActive    = "active"
+	TaskDone      = "done"
+	TaskExpired   = "expired"
+	TaskCancelled = "cancelled"
+	TaskLost      = "lost"
+)
+
+// revokedTTL — сколько помнить отозванные задачи, чтобы объяснить агенту,
+// почему его результат не принят.
+const revokedTTL = 10 * time.Minute
+
+// Task — элементарная операция над двумя числами, которую вычисляет агент.
+type Task struct {
+	ID           string
+	ExpressionID string
+	UserID       int
+	Arg1         float64
+	Arg2         float64
+	Op           byte
+	// NodeID — номер узла в дереве выражения (см. evaluator.Index)
+	NodeID     int
+	Priority   int
+	Agent      string
+	LeaseUntil time.Time
+	IssuedAt   time.Time
+	// seq — порядок поступления, index — место в очереди пользователя,
+	// charged — чей лимит одновременных задач занимает выданная задача (см. scheduler)
+	seq     int64
+	index   int
+	charged int
+	// waiters — узлы выражений, которым нужен результат задачи. Одинаковые задачи
+	// разных выражений (и одного выражения) объединяются в одну, см. enqueue.
+	waiters []waiter
+}
+
+// waiter — узел выражения, ждущий результата задачи.
+// row — его запись в журнале задач.
+type waiter struct {
+	e    *expression
+	node *evaluator.Node
+	row  string
+}
+
+// revocation — отозванная у агента задача.
+type revocation struct {
+	err *apperrors.AppError
+	at  time.Time
+}
+
+// Expression возвращает задачу в виде строки для агента, например "2+3".
+func (t *Task) Expression() string {
+	return formatNumber(t.Arg1) + string(t.Op) + formatNumber(t.Arg2)
+}
+
+// Agent — вычислитель, который обращался к оркестратору по gRPC.
+type Agent struct {
+	Addr      string    `json:"addr"`
+	LastSeen  time.Time `json:"last_seen"`
+	Completed int       `json:"completed"`
+	Current   string    `json:"current_task,omitempty"`
+}
+
+type expression struct {
+	id      string
+	userID  int
+	orgID   int
+	root    *evaluator.Node
+	parents map[*evaluator.Node]*evaluator.Node
+	index   map[*evaluator.Node]int
+	// key — каноническая запись для кэша результатов
+	key      string
+	priority int
+	started  bool
+	// agents — адреса агентов, вычислявших задачи выражения
+	agents map[string]bool
+}
+
+// Orchestrator разбивает выражения на задачи и раздаёт их агентам по gRPC.
+type Orchestrator struct {
+	pb.UnimplementedCalculatorServer
+
+	// Bus сообщает о смене статусов выражений.
+	Bus *Bus
+	// Lease — срок аренды задачи агентом; просроченные задачи возвращаются в очередь.
+	Lease time.Duration
+	// Cache — кэш результатов; nil отключает кэширование.
+	Cache *Cache
+	// Webhooks — отправка итогов выражений клиентам; nil отключает вебхуки.
+	Webhooks *Webhooks
+	// MaxConcurrent — сколько задач одного пользователя могут одновременно считаться
+	// агентами, если у пользователя нет своего ограничения; 0 — без ограничения.
+	MaxConcurrent int
+
+	mu     sync.Mutex
+	exprs  map[string]*expression
+	sched  *scheduler
+	active map[string]*Task
+	agents map[string]*Agent
+	// inflight — задачи в очереди и у агентов по ключу taskKey, для объединения одинаковых
+	inflight  map[string]*Task
+	coalesced int64
+	// revoked — задачи, аренду которых отозвали (отмена выражения или истёкший срок)
+	revoked map[string]revocation
+
+	// waiters — подписчики на завершение выражений, см. Done.
+	// Отдельный мьютекс: finish вызывается и под mu, и без него.
+	waitMu  sync.Mutex
+	waiters map[string][]chan struct{}
+}
+
+// New создаёт пустой оркестратор.
+func New() *Orchestrator {
+	return &Orchestrator{
+		Bus:      NewBus(),
+		Lease:    DefaultLease,
+		revoked:  make(map[string]revocation),
+		exprs:    make(map[string]*expression),
+		sched:    newScheduler(),
+		active:   make(map[string]*Task),
+		inflight: make(map[string]*Task),
+		agents:   make(map[string]*Agent),
+		waiters:  make(map[string][]chan struct{}),
+	}
+}
+
+// Submit разбирает выражение и ставит в очередь задачи, готовые к вычислению.
+func (o *Orchestrator) Submit(id string, userID int, expr string) error {
+	return o.submit(id, userID, expr, 0, nil)
+}
+
+// SubmitPriority — Submit с приоритетом от MinPriority до MaxPriority.
+// Приоритет упорядочивает задачи одного пользователя; между пользователями
+// агенты делятся по весам, см. scheduler.
+func (o *Orchestrator) SubmitPriority(id string, userID int, expr string, priority int) error {
+	return o.submit(id, userID, expr, priority, nil)
+}
+
+// submit — Submit с уже известными агентами выражения (при восстановлении после перезапуска).
+func (o *Orchestrator) submit(id string, userID int, expr string, priority int, agents []string) error {
+	orgID := expressionOrg(id)
+	o.Bus.Publish(Event{ExpressionID: id, UserID: userID, OrgID: orgID, Status: StatusPending})
+	root, err := evaluator.Parse(expr)
+	if err != nil {
+		o.finish(id, userID, orgID, 0, err)
+		return err
+	}
+	key := evaluator.Canonical(root)
+	if o.Cache != nil && !root.IsLeaf() {
+		if value, ok := o.Cache.Get(key); ok {
+			db.Conn.Exec("UPDATE expressions SET cached = 1 WHERE id = ?", id)
+			o.finish(id, userID, orgID, value, nil)
+			return nil
+		}
+	}
+
+	e := &expression{
+		id:       id,
+		userID:   userID,
+		orgID:    orgID,
+		root:     root,
+		key:      key,
+		priority: priority,
+		parents:  make(map[*evaluator.Node]*evaluator.Node),
+		index:    evaluator.Index(root),
+		agents:   make(map[string]bool),
+	}
+	for _, a := range agents {
+		e.agents[a] = true
+	}
+	var ready []*evaluator.Node
+	var walk func(n *evaluator.Node)
+	walk = func(n *evaluator.Node) {
+		if n.IsLeaf() {
+			return
+		}
+		o.link(e, n)
+		walk(n.Left)
+		walk(n.Right)
+		if n.Left.IsLeaf() && n.Right.IsLeaf() {
+			ready = append(ready, n)
+		}
+	}
+	walk(root)
+
+	if root.IsLeaf() {
+		o.finish(id, userID, orgID, root.Value, nil)
+		return nil
+	}
+
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	o.exprs[id] = e
+	for _, n := range ready {
+		if err := o.enqueue(e, n); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// expressionOrg возвращает организацию выражения из БД; 0 — личное пространство.
+func expressionOrg(id string) int {
+	var orgID sql.NullInt64
+	db.Conn.QueryRow("SELECT org_id FROM expressions WHERE id = ?", id).Scan(&orgID)
+	return int(orgID.Int64)
+}
+
+// Recover заново ставит в очередь выражения, не досчитанные до перезапуска.
+func (o *Orchestrator) Recover() error {
+	rows, err := db.Conn.Query(
+		"SELECT id, user_id, expression, priority, agents FROM expressions WHERE status IN ('pending', 'in_progress')",
+	)
+	if err != nil {
+		return err
+	}
+	type pending struct {
+		id       string
+		userID   int
+		expr     string
+		priority int
+		agents   sql.NullString
+	}
+	var list []pending
+	for rows.Next() {
+		var p pending
+		if err := rows.Scan(&p.id, &p.userID, &p.expr, &p.priority, &p.agents); err != nil {
+			rows.Close()
+			return err
+		}
+		list = append(list, p)
+	}
+	rows.Close()
+
+	// Задачи, которые были в очереди или у агентов до перезапуска, уже не вернутся
+	db.Conn.Exec(
+		"UPDATE tasks SET status = ?, finished_at = ? WHERE status IN (?, ?)",
+		TaskLost, time.Now().UTC(), TaskQueued, TaskActive,
+	)
+	for _, p := range list {
+		var agents []string
+		if p.agents.Valid {
+			json.Unmarshal([]byte(p.agents.String), &agents)
+		}
+		o.submit(p.id, p.userID, p.expr, p.priority, agents)
+	}
+	return nil
+}
+
+// GetTask выдаёт агенту следующую задачу по очереди планировщика.
+func (o *Orchestrator) GetTask(ctx context.Context, _ *pb.Empty) (*pb.Task, error) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	agent := o.touch(ctx)
+	o.reclaim()
+	t := o.sched.pop(o.MaxConcurrent)
+	if t == nil {
+		return nil, apperrors.ErrNoTasks
+	}
+	now := time.Now()
+	t.Agent = agent.Addr
+	t.IssuedAt = now
+	t.LeaseUntil = now.Add(o.Lease)
+	agent.Current = t.ID
+	o.active[t.ID] = t
+	o.logTask("UPDATE tasks SET status = ?, agent = ?, issued_at = ? WHERE id = ?", TaskActive, t.Agent, now.UTC(), t.ID)
+
+	for _, w := range t.waiters {
+		o.start(w.e, now)
+	}
+	return &pb.Task{Id: t.ID, Expression: t.Expression()}, nil
+}
+
+// start переводит выражение в in_progress, когда агент взял первую его задачу.
+func (o *Orchestrator) start(e *expression, now time.Time) {
+	if e.started || o.exprs[e.id] != e {
+		return
+	}
+	e.started = true
+	db.Conn.Exec(
+		"UPDATE expressions SET status = ?, started_at = COALESCE(started_at, ?) WHERE id = ?",
+		StatusInProgress, now.UTC(), e.id,
+	)
+	o.Bus.Publish(Event{ExpressionID: e.id, UserID: e.userID, OrgID: e.orgID, Status: StatusInProgress})
+}
+
+// Cancel отменяет выражение: снимает его задачи с очереди, отзывает аренду
+// у агентов (их результаты будут отклонены с ErrTaskCancelled) и ставит статус cancelled.
+// Задачи, общие с другими выражениями, продолжают выполняться для них.
+// Возвращает false, если выражение уже не выполняется.
+func (o *Orchestrator) Cancel(id string) bool {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	e, ok := o.exprs[id]
+	if !ok {
+		return false
+	}
+	o.drop(id, true)
+	now := time.Now()
+	o.logTask(
+		"UPDATE tasks SET status = ?, finished_at = ? WHERE expression_id = ? AND status IN (?, ?)",
+		TaskCancelled, now.UTC(), id, TaskQueued, TaskActive,
+	)
+	MarkCancelled(id)
+	o.Bus.Publish(Event{ExpressionID: id, UserID: e.userID, OrgID: e.orgID, Status: StatusCancelled})
+	o.enqueueWebhooks(id, e.userID)
+	o.notify(id)
+	return true
+}
+
+// MarkCancelled ставит статус cancelled выражению, которое ещё не досчитано.
+func MarkCancelled(id string) {
+	_, err := db.Conn.Exec(
+		"UPDATE expressions SET status = ?, finished_at = ? WHERE id = ? AND status IN (?, ?)",
+		StatusCancelled, time.Now().UTC(), id, StatusPending, StatusInProgress,
+	)
+	if err != nil {
+		log.Printf("cancel %s: %v", id, err)
+	}
+}
+
+// SubmitResult принимает результат задачи и ставит в очередь зависящие от неё операции.
+func (o *Orchestrator) SubmitResult(ctx context.Context, r *pb.Result) (*pb.Empty, error) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	agent := o.touch(ctx)
+	t, ok := o.active[r.Id]
+	if !ok {
+		agent.Current = ""
+		if rev, ok := o.revoked[r.Id]; ok {
+			delete(o.revoked, r.Id)
+			return nil, rev.err
+		}
+		return nil, apperrors.ErrUnknownTask
+	}
+	delete(o.active, r.Id)
+	o.sched.done(t)
+	o.forget(t)
+	agent.Completed++
+	agent.Current = ""
+
+	// Результат получают все узлы, ждавшие задачу; записи отменённых не трогаем
+	args := []interface{}{TaskDone, r.Value, t.Agent, time.Now().UTC(), t.ID}
+	for _, w := range t.waiters {
+		args = append(args, w.row)
+	}
+	o.logTask(
+		"UPDATE tasks SET status = ?, result = ?, agent = ?, finished_at = ? WHERE id IN (?"+
+			strings.Repeat(", ?", len(t.waiters))+") AND status IN (?, ?)",
+		append(args, TaskQueued, TaskActive)...,
+	)
+	for _, w := range t.waiters {
+		// выражение могло закончиться ошибкой на предыдущем узле этой же задачи
+		if o.exprs[w.e.id] != w.e {
+			continue
+		}
+		o.account(w.e, t)
+		o.advance(w.e, w.node, r.Value)
+	}
+	return &pb.Empty{}, nil
+}
+
+// advance подставляет результат в узел n и ставит в очередь родителя,
+// если оба его операнда готовы. Результат корня — итог выражения.
+func (o *Orchestrator) advance(e *expression, n *evaluator.Node, value float64) {
+	n.Op, n.Value, n.Left, n.Right = 0, value, nil, nil
+
+	parent, ok := e.parents[n]
+	if !ok {
+		delete(o.exprs, e.id)
+		if o.Cache != nil {
+			o.Cache.Put(e.key, value)
+		}
+		o.finish(e.id, e.userID, e.orgID, value, nil)
+		return
+	}
+	if parent.Left.IsLeaf() && parent.Right.IsLeaf() {
+		o.enqueue(e, parent)
+	}
+}
+
+// QueuedTask — задача в очереди или у агента, для просмотра администратором.
+type QueuedTask struct {
+	ID           string     `json:"id"`
+	ExpressionID string     `json:"expression_id"`
+	UserID       int        `json:"user_id"`
+	Expression   string     `json:"expression"`
+	State        string     `json:"state"`
+	Agent        string     `json:"agent,omitempty"`
+	LeaseUntil   *time.Time `json:"lease_until,omitempty"`
+	// Waiting — сколько узлов выражений ждут эту задачу (больше 1 — задача объединена)
+	Waiting int `json:"waiting"`
+}
+
+// Queue возвращает задачи в очереди и задачи, выданные агентам.
+func (o *Orchestrator) Queue() []QueuedTask {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	list := []QueuedTask{}
+	for _, t := range o.active {
+		lease := t.LeaseUntil
+		list = append(list, QueuedTask{t.ID, t.ExpressionID, t.UserID, t.Expression(), "active", t.Agent, &lease, len(t.waiters)})
+	}
+	for _, t := range o.sched.queued() {
+		list = append(list, QueuedTask{t.ID, t.ExpressionID, t.UserID, t.Expression(), "queued", "", nil, len(t.waiters)})
+	}
+	return list
+}
+
+// Agents возвращает агентов, обращавшихся к оркестратору за последние agentTTL.
+func (o *Orchestrator) Agents() []Agent {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	list := []Agent{}
+	for addr, a := range o.agents {
+		if time.Since(a.LastSeen) > agentTTL {
+			delete(o.agents, addr)
+			continue
+		}
+		list = append(list, *a)
+	}
+	return list
+}
+
+// Done возвращает канал, который закроется, когда выражение id будет досчитано
+// (с результатом или ошибкой). Подписываться нужно до Submit; cancel снимает подписку.
+func (o *Orchestrator) Done(id string) (<-chan struct{}, func()) {
+	ch := make(chan struct{})
+	o.waitMu.Lock()
+	o.waiters[id] = append(o.waiters[id], ch)
+	o.waitMu.Unlock()
+
+	cancel := func() {
+		o.waitMu.Lock()
+		defer o.waitMu.Unlock()
+		list := o.waiters[id]
+		for i, c := range list {
+			if c == ch {
+				o.waiters[id] = append(list[:i], list[i+1:]...)
+				break
+			}
+		}
+		if len(o.waiters[id]) == 0 {
+			delete(o.waiters, id)
+		}
+	}
+	return ch, cancel
+}
+
+// notify будит всех, кто ждёт выражение id.
+func (o *Orchestrator) notify(id string) {
+	o.waitMu.Lock()
+	defer o.waitMu.Unlock()
+	for _, ch := range o.waiters[id] {
+		close(ch)
+	}
+	delete(o.waiters, id)
+}
+
+// reclaim возвращает в начало очереди задачи с истёкшей арендой;
+// результат от прежнего агента будет отклонён с ErrLeaseExpired.
+func (o *Orchestrator) reclaim() {
+	now := time.Now()
+	for id, t := range o.active {
+		if now.Before(t.LeaseUntil) {
+			continue
+		}
+		delete(o.active, id)
+		o.sched.done(t)
+		o.revoked[id] = revocation{apperrors.ErrLeaseExpired, now}
+		o.logTask("UPDATE tasks SET status = ?, finished_at = ? WHERE id = ?", TaskExpired, now.UTC(), id)
+		if a, ok := o.agents[t.Agent]; ok && a.Current == id {
+			a.Current = ""
+		}
+		retry := *t
+		retry.ID, retry.Agent, retry.LeaseUntil, retry.IssuedAt = uuid.NewString(), "", time.Time{}, time.Time{}
+		o.sched.push(&retry)
+		if key := taskKey(t.Op, t.Arg1, t.Arg2); o.inflight[key] == t {
+			o.inflight[key] = &retry
+		}
+		o.recordTask(&retry)
+	}
+	for id, rev := range o.revoked {
+		if now.Sub(rev.at) > revokedTTL {
+			delete(o.revoked, id)
+		}
+	}
+}
+
+// account записывает в БД выполненную задачу: счётчик задач,
+// время вычисления (от выдачи до результата) и агента.
+func (o *Orchestrator) account(e *expression, t *Task) {
+	e.agents[t.Agent] = true
+	agents := make([]string, 0, len(e.agents))
+	for a := range e.agents {
+		agents = append(agents, a)
+	}
+	sort.Strings(agents)
+	list, _ := json.Marshal(agents)
+	_, err := db.Conn.Exec(
+		"UPDATE expressions SET task_count = task_count + 1, compute_ms = compute_ms + ?, agents = ? WHERE id = ?",
+		time.Since(t.IssuedAt).Milliseconds(), string(list), e.id,
+	)
+	if err != nil {
+		log.Printf("account %s: %v", e.id, err)
+	}
+}
+
+// link запоминает родителя для дочерних узлов n.
+func (o *Orchestrator) link(e *expression, n *evaluator.Node) {
+	e.parents[n.Left] = n
+	e.parents[n.Right] = n
+}
+
+// enqueue создаёт задачу для узла, у которого оба операнда уже вычислены.
+// Если такая же задача уже в очереди или у агента, узел присоединяется к ней.
+// Деление на ноль отлавливается здесь, без отправки агенту.
+func (o *Orchestrator) enqueue(e *expression, n *evaluator.Node) error {
+	if n.Op == '/' && n.Right.Value == 0 {
+		o.drop(e.id, false)
+		o.finish(e.id, e.userID, e.orgID, 0, errDivisionByZero)
+		return errDivisionByZero
+	}
+	w := waiter{e: e, node: n, row: uuid.NewString()}
+	key := taskKey(n.Op, n.Left.Value, n.Right.Value)
+	if t, ok := o.inflight[key]; ok {
+		t.waiters = append(t.waiters, w)
+		if e.priority > t.Priority {
+			t.Priority = e.priority
+			o.sched.fix(t)
+		}
+		o.coalesced++
+		o.recordShared(t, w)
+		if _, active := o.active[t.ID]; active {
+			o.start(e, time.Now())
+		}
+		return nil
+	}
+	t := &Task{
+		ID:           w.row,
+		ExpressionID: e.id,
+		UserID:       e.userID,
+		Arg1:         n.Left.Value,
+		Arg2:         n.Right.Value,
+		Op:           n.Op,
+		NodeID:       e.index[n],
+		Priority:     e.priority,
+		waiters:      []waiter{w},
+	}
+	o.sched.push(t)
+	o.inflight[key] = t
+	o.recordTask(t)
+	return nil
+}
+
+// taskKey — ключ для объединения одинаковых задач. Порядок операндов + и *
+// на результат не влияет, поэтому 2+3 и 3+2 — одна задача.
+func taskKey(op byte, a, b float64) string {
+	if (op == '+' || op == '*') && b < a {
+		a, b = b, a
+	}
+	return formatNumber(a) + string(op) + formatNumber(b)
+}
+
+// forget убирает задачу из индекса одинаковых задач.
+func (o *Orchestrator) forget(t *Task) {
+	if key := taskKey(t.Op, t.Arg1, t.Arg2); o.inflight[key] == t {
+		delete(o.inflight, key)
+	}
+}
+
+// recordTask добавляет задачу в журнал для трассировки выражения.
+func (o *Orchestrator) recordTask(t *Task) {
+	o.recordRow(t.ID, t.ExpressionID, t.NodeID, t, "")
+}
+
+// recordShared записывает в журнал узел, присоединённый к чужой задаче t.
+func (o *Orchestrator) recordShared(t *Task, w waiter) {
+	o.recordRow(w.row, w.e.id, w.e.index[w.node], t, t.ID)
+}
+
+// recordRow пишет запись журнала. Номер попытки — сколько раз этот узел
+// уже ставился в очередь, плюс один.
+func (o *Orchestrator) recordRow(row, exprID string, node int, t *Task, sharedWith string) {
+	var shared interface{}
+	if sharedWith != "" {
+		shared = sharedWith
+	}
+	o.logTask(
+		`INSERT INTO tasks(id, expression_id, node, op, arg1, arg2, attempt, status, queued_at, shared_with)
+		VALUES(?, ?, ?, ?, ?, ?, (SELECT COUNT(*) + 1 FROM tasks WHERE expression_id = ? AND node = ?), ?, ?, ?)`,
+		row, exprID, node, string(t.Op), t.Arg1, t.Arg2, exprID, node, TaskQueued, time.Now().UTC(), shared,
+	)
+}
+
+// logTask выполняет запрос к журналу задач. Журнал нужен только для отладки,
+// поэтому ошибка не останавливает вычисление.
+func (o *Orchestrator) logTask(query string, args ...interface{}) {
+	if _, err := db.Conn.Exec(query, args...); err != nil {
+		log.Printf("task log: %v", err)
+	}
+}
+
+// drop убирает выражение и отсоединяет его от задач. Задача, которую больше
+// никто не ждёт, снимается с очереди; выданная агенту при revoke отзывается
+// с ErrTaskCancelled, иначе её результат просто никому не достанется.
+// Общая задача, принадлежавшая выражению, переходит к следующему ожидающему.
+func (o *Orchestrator) drop(id string, revoke bool) {
+	delete(o.exprs, id)
+	// release отсоединяет выражение от задачи; false — задачу больше никто не ждёт
+	release := func(t *Task) bool {
+		waiters := make([]waiter, 0, len(t.waiters))
+		for _, w := range t.waiters {
+			if w.e.id != id {
+				waiters = append(waiters, w)
+			}
+		}
+		t.waiters = waiters
+		if len(waiters) == 0 {
+			o.forget(t)
+			return false
+		}
+		return true
+	}
+	reassign := func(t *Task) {
+		w := t.waiters[0]
+		t.ExpressionID, t.UserID, t.NodeID = w.e.id, w.e.userID, w.e.index[w.node]
+		t.Priority = MinPriority
+		for _, w := range t.waiters {
+			t.Priority = max(t.Priority, w.e.priority)
+		}
+	}
+
+	for _, t := range o.sched.queued() {
+		if !release(t) {
+			o.sched.remove(t)
+		} else if t.ExpressionID == id {
+			// задача переходит в очередь нового владельца
+			o.sched.remove(t)
+			reassign(t)
+			o.sched.push(t)
+		}
+	}
+	now := time.Now()
+	for taskID, t := range o.active {
+		if release(t) {
+			if t.ExpressionID == id {
+				reassign(t)
+			}
+		} else if revoke {
+			delete(o.active, taskID)
+			o.sched.done(t)
+			o.revoked[taskID] = revocation{apperrors.ErrTaskCancelled, now}
+		}
+	}
+}
+
+// SetUserLimits меняет вес и ограничение одновременных задач пользователя.
+func (o *Orchestrator) SetUserLimits(uid int, limits UserLimits) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	o.sched.setLimits(uid, limits)
+}
+
+// finish сохраняет итог выражения в БД, публикует событие и будит ожидающих.
+func (o *Orchestrator) finish(id string, userID, orgID int, result float64, err error) {
+	defer o.notify(id)
+	event := Event{ExpressionID: id, UserID: userID, OrgID: orgID, Status: StatusDone, Result: &result}
+	now := time.Now().UTC()
+	var dbErr error
+	if err != nil {
+		event.Status, event.Result = StatusError, nil
+		_, dbErr = db.Conn.Exec(
+			"UPDATE expressions SET status = ?, error = ?, finished_at = ? WHERE id = ?",
+			StatusError, err.Error(), now, id,
+		)
+	} else {
+		_, dbErr = db.Conn.Exec(
+			"UPDATE expressions SET status = ?, result = ?, finished_at = ? WHERE id = ?",
+			StatusDone, result, now, id,
+		)
+	}
+	if dbErr != nil {
+		log.Printf("finish %s: %v", id, dbErr)
+	}
+	o.Bus.Publish(event)
+	o.enqueueWebhooks(id, userID)
+}
+
+// touch отмечает агента, от которого пришёл запрос.
+func (o *Orchestrator) touch(ctx context.Context) *Agent {
+	addr := "unknown"
+	if p, ok := peer.FromContext(ctx); ok {
+		addr = p.Addr.String()
+	}
+	a, ok := o.agents[addr]
+	if !ok {
+		a = &Agent{Addr: addr}
+		o.agents[addr] = a
+	}
+	a.LastSeen = time.Now()
+	return a
+}
+
+func formatNumber(v float64) string {
+	return strconv.FormatFloat(v, 'f', -1, 64)
+}
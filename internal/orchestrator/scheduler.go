@@ -0,0 +1,213 @@
+package orchestrator
+
+import (
+	"container/heap"
+	"database/sql"
+	"sort"
+
+	"github.com/scriptoxin/yandex-liceum-go-calc/pkg/db"
+)
+
+// Границы приоритета выражения; по умолчанию 0, задачи с большим приоритетом выдаются раньше.
+const (
+	MinPriority = -10
+	MaxPriority = 10
+)
+
+// UserLimits — настройки планировщика для пользователя.
+type UserLimits struct {
+	// Weight — доля агентов относительно других пользователей: с весом 2 задачи
+	// выдаются вдвое чаще, чем пользователю с весом 1
+	Weight float64 `json:"weight"`
+	// MaxConcurrent — сколько задач пользователя могут считаться одновременно;
+	// 0 — ограничение по умолчанию (Orchestrator.MaxConcurrent)
+	MaxConcurrent int `json:"max_concurrent"`
+}
+
+// userQueue — задачи одного пользователя и его место в очереди пользователей.
+type userQueue struct {
+	uid     int
+	tasks   taskHeap
+	running int
+	// pass — виртуальное время пользователя: растёт на 1/weight с каждой выданной задачей
+	pass   float64
+	limits UserLimits
+}
+
+// scheduler выбирает, чью задачу отдать агенту (stride scheduling — взвешенная
+// справедливая очередь): задачу получает пользователь с наименьшим pass,
+// внутри пользователя — задача с наибольшим приоритетом, при равенстве — более ранняя.
+// Пользователь, долго не отправлявший задач, не копит «кредит»: его pass подтягивается
+// к текущему виртуальному времени.
+type scheduler struct {
+	users map[int]*userQueue
+	vtime float64
+	seq   int64
+	// limits — загруженные настройки пользователей; пустые берутся из таблицы users
+	limits map[int]UserLimits
+}
+
+func newScheduler() *scheduler {
+	return &scheduler{
+		users:  make(map[int]*userQueue),
+		limits: make(map[int]UserLimits),
+	}
+}
+
+// push ставит задачу в очередь её пользователя. Номер seq выдаётся один раз,
+// поэтому повторно поставленная задача сохраняет своё место.
+func (s *scheduler) push(t *Task) {
+	if t.seq == 0 {
+		s.seq++
+		t.seq = s.seq
+	}
+	q := s.user(t.UserID)
+	if len(q.tasks) == 0 && q.running == 0 && q.pass < s.vtime {
+		q.pass = s.vtime
+	}
+	heap.Push(&q.tasks, t)
+}
+
+// pop выдаёт следующую задачу или nil, если все очереди пусты или упёрлись в ограничение.
+// defaultMax — ограничение одновременных задач для пользователей без своего.
+func (s *scheduler) pop(defaultMax int) *Task {
+	var best *userQueue
+	for _, q := range s.users {
+		if len(q.tasks) == 0 {
+			continue
+		}
+		limit := q.limits.MaxConcurrent
+		if limit == 0 {
+			limit = defaultMax
+		}
+		if limit > 0 && q.running >= limit {
+			continue
+		}
+		if best == nil || q.pass < best.pass || (q.pass == best.pass && q.tasks[0].seq < best.tasks[0].seq) {
+			best = q
+		}
+	}
+	if best == nil {
+		return nil
+	}
+	t := heap.Pop(&best.tasks).(*Task)
+	s.vtime = best.pass
+	best.pass += 1 / best.limits.Weight
+	best.running++
+	t.charged = best.uid
+	return t
+}
+
+// done отмечает, что выданная задача больше не считается агентом.
+func (s *scheduler) done(t *Task) {
+	if q, ok := s.users[t.charged]; ok && q.running > 0 {
+		q.running--
+		s.cleanup(q)
+	}
+}
+
+// remove убирает задачу из очереди.
+func (s *scheduler) remove(t *Task) {
+	q, ok := s.users[t.UserID]
+	if !ok || t.index < 0 || t.index >= len(q.tasks) || q.tasks[t.index] != t {
+		return
+	}
+	heap.Remove(&q.tasks, t.index)
+	s.cleanup(q)
+}
+
+// fix восстанавливает порядок после смены приоритета задачи в очереди.
+func (s *scheduler) fix(t *Task) {
+	if q, ok := s.users[t.UserID]; ok && t.index >= 0 && t.index < len(q.tasks) && q.tasks[t.index] == t {
+		heap.Fix(&q.tasks, t.index)
+	}
+}
+
+// queued возвращает все задачи в очереди в порядке приоритета и поступления.
+func (s *scheduler) queued() []*Task {
+	var list []*Task
+	for _, q := range s.users {
+		list = append(list, q.tasks...)
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].before(list[j]) })
+	return list
+}
+
+// setLimits меняет настройки пользователя; действуют со следующей выдачи задачи.
+func (s *scheduler) setLimits(uid int, limits UserLimits) {
+	if limits.Weight <= 0 {
+		limits.Weight = 1
+	}
+	s.limits[uid] = limits
+	if q, ok := s.users[uid]; ok {
+		q.limits = limits
+	}
+}
+
+// user возвращает очередь пользователя, создавая её при первой задаче.
+func (s *scheduler) user(uid int) *userQueue {
+	if q, ok := s.users[uid]; ok {
+		return q
+	}
+	limits, ok := s.limits[uid]
+	if !ok {
+		limits = loadLimits(uid)
+		s.limits[uid] = limits
+	}
+	q := &userQueue{uid: uid, pass: s.vtime, limits: limits}
+	s.users[uid] = q
+	return q
+}
+
+// cleanup забывает пользователя, у которого нет ни задач в очереди, ни выданных.
+func (s *scheduler) cleanup(q *userQueue) {
+	if len(q.tasks) == 0 && q.running == 0 {
+		delete(s.users, q.uid)
+	}
+}
+
+// loadLimits читает настройки пользователя из БД; для неизвестного — значения по умолчанию.
+func loadLimits(uid int) UserLimits {
+	limits := UserLimits{Weight: 1}
+	var maxConcurrent sql.NullInt64
+	err := db.Conn.QueryRow("SELECT weight, max_concurrent FROM users WHERE id = ?", uid).
+		Scan(&limits.Weight, &maxConcurrent)
+	if err != nil || limits.Weight <= 0 {
+		limits.Weight = 1
+	}
+	limits.MaxConcurrent = int(maxConcurrent.Int64)
+	return limits
+}
+
+// before — порядок задач внутри пользователя: выше приоритет, затем раньше поступила.
+func (t *Task) before(other *Task) bool {
+	if t.Priority != other.Priority {
+		return t.Priority > other.Priority
+	}
+	return t.seq < other.seq
+}
+
+// taskHeap — очередь задач пользователя (container/heap).
+type taskHeap []*Task
+
+func (h taskHeap) Len() int           { return len(h) }
+func (h taskHeap) Less(i, j int) bool { return h[i].before(h[j]) }
+func (h taskHeap) Swap(i, j int) {
+	h[i], h[j] = h[j], h[i]
+	h[i].index, h[j].index = i, j
+}
+
+func (h *taskHeap) Push(x interface{}) {
+	t := x.(*Task)
+	t.index = len(*h)
+	*h = append(*h, t)
+}
+
+func (h *taskHeap) Pop() interface{} {
+	old := *h
+	t := old[len(old)-1]
+	old[len(old)-1] = nil
+	t.index = -1
+	*h = old[:len(old)-1]
+	return t
+}
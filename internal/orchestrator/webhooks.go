@@ -0,0 +1,471 @@
+package orchestrator
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"net/netip"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/google/uuid"
+
+	"github.com/scriptoxin/yandex-liceum-go-calc/pkg/db"
+)
+
+// Статусы доставки вебхука.
+const (
+	DeliveryPending   = "pending"
+	DeliveryDelivered = "delivered"
+	// DeliveryDead — попытки кончились; доставку можно повторить вручную
+	DeliveryDead = "dead"
+)
+
+// Заголовки запроса с вебхуком.
+const (
+	// SignatureHeader — "t=<unix-время>,v1=<hex HMAC-SHA256 от "<t>.<тело>">"
+	SignatureHeader = "X-Webhook-Signature"
+	EventHeader     = "X-Webhook-Event"
+	DeliveryHeader  = "X-Webhook-Delivery"
+)
+
+// Значения по умолчанию для Webhooks.
+const (
+	DefaultWebhookAttempts = 8
+	DefaultWebhookBackoff  = 10 * time.Second
+	DefaultWebhookTimeout  = 10 * time.Second
+)
+
+// maxBackoff — дольше этого между попытками не ждём.
+const maxBackoff = time.Hour
+
+// webhookWorkers — сколько доставок отправляются одновременно.
+const webhookWorkers = 8
+
+// Webhooks отправляет итоги выражений на адреса клиентов: на callback_url выражения
+// и на адреса из подписок пользователя. Доставки хранятся в таблице webhook_deliveries,
+// поэтому переживают перезапуск. Неудачная доставка повторяется с экспоненциально
+// растущей паузой, а после MaxAttempts попыток попадает в список недоставленных (dead).
+type Webhooks struct {
+	Client *http.Client
+	// AllowPrivate разрешает доставку на внутренние адреса (см. IsPrivateIP).
+	// Только для тестов и локальной отладки: с ним вебхуком можно обратиться
+	// к любому сервису рядом с оркестратором. В работе должно быть выключено
+	AllowPrivate bool
+	// MaxAttempts — сколько попыток делается, прежде чем доставка станет dead
+	MaxAttempts int
+	// Backoff — пауза после первой неудачи; дальше удваивается, но не больше часа
+	Backoff time.Duration
+
+	// mu не даёт двум Deliver отправить одну доставку дважды
+	mu   sync.Mutex
+	wake chan struct{}
+}
+
+// NewWebhooks создаёт отправитель с настройками по умолчанию.
+// Адрес получателя проверяется при каждом подключении, уже после разрешения имени,
+// поэтому имя, которое позже стало указывать во внутреннюю сеть, тоже не пройдёт.
+// Перенаправления не выполняются: ответ 3xx — неудачная попытка.
+func NewWebhooks() *Webhooks {
+	w := &Webhooks{
+		MaxAttempts: DefaultWebhookAttempts,
+		Backoff:     DefaultWebhookBackoff,
+		wake:        make(chan struct{}, 1),
+	}
+	dialer := &net.Dialer{Timeout: DefaultWebhookTimeout, Control: w.checkDial}
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	// через прокси проверялся бы адрес прокси, а не получателя
+	transport.Proxy = nil
+	transport.DialContext = dialer.DialContext
+	w.Client = &http.Client{
+		Timeout:   DefaultWebhookTimeout,
+		Transport: transport,
+		CheckRedirect: func(*http.Request, []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
+	return w
+}
+
+// nonPublicNets — адреса, куда вебхуки не отправляются: всё, что не является публичным
+// адресом в интернете (реестры IANA IPv4/IPv6 Special-Purpose Address), и multicast.
+var nonPublicNets = []netip.Prefix{
+	netip.MustParsePrefix("0.0.0.0/8"),       // «эта сеть»
+	netip.MustParsePrefix("10.0.0.0/8"),      // частная сеть
+	netip.MustParsePrefix("100.64.0.0/10"),   // CGNAT
+	netip.MustParsePrefix("127.0.0.0/8"),     // loopback
+	netip.MustParsePrefix("169.254.0.0/16"),  // link-local, в том числе метаданные облаков
+	netip.MustParsePrefix("172.16.0.0/12"),   // частная сеть
+	netip.MustParsePrefix("192.0.0.0/24"),    // протокольные назначения IETF
+	netip.MustParsePrefix("192.0.2.0/24"),    // документация
+	netip.MustParsePrefix("192.88.99.0/24"),  // 6to4 relay
+	netip.MustParsePrefix("192.168.0.0/16"),  // частная сеть
+	netip.MustParsePrefix("198.18.0.0/15"),   // тестирование производительности
+	netip.MustParsePrefix("198.51.100.0/24"), // документация
+	netip.MustParsePrefix("203.0.113.0/24"),  // документация
+	netip.MustParsePrefix("224.0.0.0/4"),     // multicast
+	netip.MustParsePrefix("240.0.0.0/4"),     // зарезервировано и broadcast
+	netip.MustParsePrefix("::/96"),           // неуказанный, loopback и устаревшие IPv4-совместимые
+	netip.MustParsePrefix("::ffff:0:0:0/96"), // IPv4-транслируемые (SIIT)
+	netip.MustParsePrefix("64:ff9b::/96"),    // NAT64 — ведёт на IPv4-адрес
+	netip.MustParsePrefix("64:ff9b:1::/48"),  // локальный NAT64
+	netip.MustParsePrefix("100::/64"),        // discard
+	netip.MustParsePrefix("2001::/23"),       // протокольные назначения IETF, Teredo
+	netip.MustParsePrefix("2001:db8::/32"),   // документация
+	netip.MustParsePrefix("2002::/16"),       // 6to4 — ведёт на IPv4-адрес
+	netip.MustParsePrefix("fc00::/7"),        // unique local
+	netip.MustParsePrefix("fe80::/10"),       // link-local
+	netip.MustParsePrefix("ff00::/8"),        // multicast
+}
+
+// IsPrivateIP сообщает, что адрес не публичный (см. nonPublicNets). Вебхуки туда не
+// отправляются, чтобы через них нельзя было обратиться к сервисам рядом с оркестратором.
+// IPv4-адрес в IPv6-записи (::ffff:127.0.0.1) проверяется как IPv4.
+func IsPrivateIP(ip net.IP) bool {
+	addr, ok := netip.AddrFromSlice(ip)
+	if !ok {
+		return true
+	}
+	addr = addr.Unmap()
+	for _, p := range nonPublicNets {
+		if p.Contains(addr) {
+			return true
+		}
+	}
+	return false
+}
+
+// checkDial запрещает подключение к внутренним адресам, если не включён AllowPrivate.
+func (w *Webhooks) checkDial(network, address string, _ syscall.RawConn) error {
+	if w.AllowPrivate {
+		return nil
+	}
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		return err
+	}
+	if ip := net.ParseIP(host); ip == nil || IsPrivateIP(ip) {
+		return &net.AddrError{Err: "webhook address is not public", Addr: address}
+	}
+	return nil
+}
+
+// Queued сообщает о новых доставках, чтобы не ждать до следующего тика.
+func (w *Webhooks) Queued() <-chan struct{} {
+	return w.wake
+}
+
+func (w *Webhooks) signal() {
+	select {
+	case w.wake <- struct{}{}:
+	default:
+	}
+}
+
+// webhookPayload — тело запроса.
+type webhookPayload struct {
+	ID         string                 `json:"id"`
+	Event      string                 `json:"event"`
+	CreatedAt  time.Time              `json:"created_at"`
+	Expression map[string]interface{} `json:"expression"`
+}
+
+// enqueue ставит в очередь доставки итога выражения: на его callback_url
+// и во все подписки пользователя на это событие.
+func (w *Webhooks) enqueue(exprID string, userID int) {
+	var (
+		expr, status, label string
+		result              sql.NullFloat64
+		errText, callback   sql.NullString
+		finished            sql.NullTime
+	)
+	err := db.Conn.QueryRow(
+		"SELECT expression, status, result, error, label, finished_at, callback_url FROM expressions WHERE id = ?", exprID,
+	).Scan(&expr, &status, &result, &errText, &label, &finished, &callback)
+	if err != nil {
+		// выражение уже удалено (например, вместе с аккаунтом) — уведомлять не о чем
+		if err != sql.ErrNoRows {
+			log.Printf("webhooks %s: %v", exprID, err)
+		}
+		return
+	}
+	event := "expression." + status
+
+	type target struct {
+		webhookID interface{}
+		url       string
+	}
+	var targets []target
+	if callback.String != "" {
+		targets = append(targets, target{nil, callback.String})
+	}
+	rows, err := db.Conn.Query("SELECT id, url, events FROM webhooks WHERE user_id = ?", userID)
+	if err != nil {
+		log.Printf("webhooks %s: %v", exprID, err)
+		return
+	}
+	for rows.Next() {
+		var (
+			id          int64
+			url, events string
+		)
+		rows.Scan(&id, &url, &events)
+		if events == "" || containsEvent(events, event) {
+			targets = append(targets, target{id, url})
+		}
+	}
+	rows.Close()
+	if len(targets) == 0 {
+		return
+	}
+
+	body := map[string]interface{}{"id": exprID, "expression": expr, "status": status}
+	if result.Valid {
+		body["result"] = result.Float64
+	}
+	if errText.Valid {
+		body["error"] = errText.String
+	}
+	if label != "" {
+		body["label"] = label
+	}
+	if finished.Valid {
+		body["finished_at"] = finished.Time
+	}
+	now := time.Now().UTC()
+	for _, t := range targets {
+		payload := webhookPayload{ID: uuid.NewString(), Event: event, CreatedAt: now, Expression: body}
+		data, _ := json.Marshal(payload)
+		_, err := db.Conn.Exec(
+			`INSERT INTO webhook_deliveries(id, user_id, webhook_id, expression_id, event, url, payload, status, next_attempt_at, created_at)
+			VALUES(?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+			payload.ID, userID, t.webhookID, exprID, event, t.url, string(data), DeliveryPending, now, now,
+		)
+		if err != nil {
+			log.Printf("webhooks %s: %v", exprID, err)
+		}
+	}
+	w.signal()
+}
+
+// containsEvent проверяет, есть ли event в списке через запятую.
+func containsEvent(events, event string) bool {
+	for _, e := range strings.Split(events, ",") {
+		if e == event {
+			return true
+		}
+	}
+	return false
+}
+
+// delivery — доставка, которую пора отправить.
+type delivery struct {
+	id, url, event, payload string
+	userID, attempts        int
+}
+
+// Deliver отправляет доставки, чей срок наступил к моменту now, и ждёт ответов.
+// main вызывает его по тику и по Queued.
+func (w *Webhooks) Deliver(now time.Time) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	rows, err := db.Conn.Query(
+		"SELECT id, user_id, url, event, payload, attempts FROM webhook_deliveries WHERE status = ? AND next_attempt_at <= ? ORDER BY next_attempt_at",
+		DeliveryPending, now.UTC(),
+	)
+	if err != nil {
+		log.Printf("webhooks: %v", err)
+		return
+	}
+	var due []delivery
+	for rows.Next() {
+		var d delivery
+		if err := rows.Scan(&d.id, &d.userID, &d.url, &d.event, &d.payload, &d.attempts); err != nil {
+			log.Printf("webhooks: %v", err)
+			continue
+		}
+		due = append(due, d)
+	}
+	rows.Close()
+
+	var wg sync.WaitGroup
+	sem := make(chan struct{}, webhookWorkers)
+	for _, d := range due {
+		wg.Add(1)
+		sem <- struct{}{}
+		go func(d delivery) {
+			defer wg.Done()
+			defer func() { <-sem }()
+			w.attempt(d, now)
+		}(d)
+	}
+	wg.Wait()
+}
+
+// attempt делает одну попытку доставки и записывает её в журнал.
+func (w *Webhooks) attempt(d delivery, now time.Time) {
+	secret, err := WebhookSecret(d.userID)
+	if err != nil {
+		log.Printf("webhook %s: %v", d.id, err)
+		return
+	}
+	started := time.Now()
+	code, sendErr := w.send(d, secret, started)
+	elapsed := time.Since(started)
+	d.attempts++
+
+	var codeValue, errValue interface{}
+	if code != 0 {
+		codeValue = code
+	}
+	if sendErr != nil {
+		errValue = sendErr.Error()
+	}
+	// Номер в журнале сквозной: после ручного повтора счётчик attempts начинается заново
+	db.Conn.Exec(
+		`INSERT INTO webhook_attempts(delivery_id, attempt, status_code, error, duration_ms, at)
+		VALUES(?, (SELECT COUNT(*) + 1 FROM webhook_attempts WHERE delivery_id = ?), ?, ?, ?, ?)`,
+		d.id, d.id, codeValue, errValue, elapsed.Milliseconds(), started.UTC(),
+	)
+
+	switch {
+	case sendErr == nil:
+		_, err = db.Conn.Exec(
+			"UPDATE webhook_deliveries SET status = ?, attempts = ?, next_attempt_at = NULL, last_error = NULL, delivered_at = ? WHERE id = ?",
+			DeliveryDelivered, d.attempts, time.Now().UTC(), d.id,
+		)
+	case d.attempts >= w.MaxAttempts:
+		_, err = db.Conn.Exec(
+			"UPDATE webhook_deliveries SET status = ?, attempts = ?, next_attempt_at = NULL, last_error = ? WHERE id = ?",
+			DeliveryDead, d.attempts, sendErr.Error(), d.id,
+		)
+	default:
+		_, err = db.Conn.Exec(
+			"UPDATE webhook_deliveries SET attempts = ?, next_attempt_at = ?, last_error = ? WHERE id = ?",
+			d.attempts, now.Add(w.backoff(d.attempts)).UTC(), sendErr.Error(), d.id,
+		)
+	}
+	if err != nil {
+		log.Printf("webhook %s: %v", d.id, err)
+	}
+}
+
+// backoff — пауза после attempts неудачных попыток.
+func (w *Webhooks) backoff(attempts int) time.Duration {
+	d := w.Backoff
+	for i := 1; i < attempts && d < maxBackoff; i++ {
+		d *= 2
+	}
+	return min(d, maxBackoff)
+}
+
+// send отправляет тело доставки; успех — любой ответ 2xx.
+func (w *Webhooks) send(d delivery, secret string, at time.Time) (int, error) {
+	req, err := http.NewRequest(http.MethodPost, d.url, strings.NewReader(d.payload))
+	if err != nil {
+		return 0, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "calc-webhooks/1")
+	req.Header.Set(EventHeader, d.event)
+	req.Header.Set(DeliveryHeader, d.id)
+	req.Header.Set(SignatureHeader, Sign(secret, at, []byte(d.payload)))
+	resp, err := w.Client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, io.LimitReader(resp.Body, 64<<10))
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return resp.StatusCode, &statusError{resp.StatusCode}
+	}
+	return resp.StatusCode, nil
+}
+
+type statusError struct{ code int }
+
+func (e *statusError) Error() string {
+	return "unexpected status " + strconv.Itoa(e.code)
+}
+
+// Sign подписывает тело вебхука: "t=<unix-время>,v1=<hex HMAC-SHA256(secret, "<t>.<тело>")>".
+// Получатель считает ту же подпись своим секретом и отбрасывает старые t, чтобы не принять повтор.
+func Sign(secret string, at time.Time, body []byte) string {
+	t := strconv.FormatInt(at.Unix(), 10)
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(t + "."))
+	mac.Write(body)
+	return "t=" + t + ",v1=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// WebhookSecret возвращает секрет, которым подписываются вебхуки пользователя,
+// создавая его при первом обращении.
+func WebhookSecret(userID int) (string, error) {
+	var secret string
+	if err := db.Conn.QueryRow("SELECT webhook_secret FROM users WHERE id = ?", userID).Scan(&secret); err != nil {
+		return "", err
+	}
+	if secret != "" {
+		return secret, nil
+	}
+	secret, err := newWebhookSecret()
+	if err != nil {
+		return "", err
+	}
+	// Если секрет успели создать параллельно, берём тот, что уже в БД
+	db.Conn.Exec("UPDATE users SET webhook_secret = ? WHERE id = ? AND webhook_secret = ''", secret, userID)
+	err = db.Conn.QueryRow("SELECT webhook_secret FROM users WHERE id = ?", userID).Scan(&secret)
+	return secret, err
+}
+
+// RotateWebhookSecret заменяет секрет пользователя новым.
+func RotateWebhookSecret(userID int) (string, error) {
+	secret, err := newWebhookSecret()
+	if err != nil {
+		return "", err
+	}
+	_, err = db.Conn.Exec("UPDATE users SET webhook_secret = ? WHERE id = ?", secret, userID)
+	return secret, err
+}
+
+func newWebhookSecret() (string, error) {
+	buf := make([]byte, 32)
+	if _, err := rand.Read(buf); err != nil {
+		return "", err
+	}
+	return "whsec_" + hex.EncodeToString(buf), nil
+}
+
+// Retry возвращает недоставленную доставку пользователя в очередь с новым счётчиком попыток.
+func (w *Webhooks) Retry(id string, userID int) bool {
+	res, err := db.Conn.Exec(
+		"UPDATE webhook_deliveries SET status = ?, attempts = 0, next_attempt_at = ? WHERE id = ? AND user_id = ? AND status = ?",
+		DeliveryPending, time.Now().UTC(), id, userID, DeliveryDead,
+	)
+	if err != nil {
+		return false
+	}
+	n, _ := res.RowsAffected()
+	if n > 0 {
+		w.signal()
+	}
+	return n > 0
+}
+
+// enqueueWebhooks ставит доставки итога выражения, если вебхуки включены.
+func (o *Orchestrator) enqueueWebhooks(id string, userID int) {
+	if o.Webhooks != nil {
+		o.Webhooks.enqueue(id, userID)
+	}
+}
@@ -2,6 +2,7 @@ package db
 
 import (
 	"database/sql"
+	"strings"
 
 	_ "github.com/mattn/go-sqlite3"
 )
@@ -20,7 +21,23 @@ func Init(path string) error {
     CREATE TABLE IF NOT EXISTS users (
       id INTEGER PRIMARY KEY AUTOINCREMENT,
       login TEXT UNIQUE NOT NULL,
-      password TEXT NOT NULL
+      password TEXT NOT NULL,
+      role TEXT NOT NULL DEFAULT 'user',
+      disabled INTEGER NOT NULL DEFAULT 0,
+      failed_logins INTEGER NOT NULL DEFAULT 0,
+      locked_until DATETIME,
+      display_name TEXT NOT NULL DEFAULT '',
+      token_version INTEGER NOT NULL DEFAULT 0,
+      delete_at DATETIME,
+      totp_secret TEXT NOT NULL DEFAULT '',
+      totp_enabled INTEGER NOT NULL DEFAULT 0,
+      totp_last_step INTEGER NOT NULL DEFAULT 0,
+      weight REAL NOT NULL DEFAULT 1,
+      max_concurrent INTEGER,
+      quota_per_minute INTEGER,
+      quota_pending INTEGER,
+      quota_stored INTEGER,
+      webhook_secret TEXT NOT NULL DEFAULT ''
     );
     CREATE TABLE IF NOT EXISTS expressions (
       id TEXT PRIMARY KEY,
@@ -28,9 +45,242 @@ func Init(path string) error {
       expression TEXT NOT NULL,
       status TEXT NOT NULL,
       result REAL,
+      org_id INTEGER,
+      batch_id TEXT,
+      label TEXT NOT NULL DEFAULT '',
+      error TEXT,
+      created_at DATETIME,
+      started_at DATETIME,
+      finished_at DATETIME,
+      task_count INTEGER NOT NULL DEFAULT 0,
+      compute_ms INTEGER NOT NULL DEFAULT 0,
+      agents TEXT,
+      cached INTEGER NOT NULL DEFAULT 0,
+      priority INTEGER NOT NULL DEFAULT 0,
+      schedule_id TEXT,
+      scheduled_for DATETIME,
+      callback_url TEXT,
+      FOREIGN KEY(user_id) REFERENCES users(id),
+      FOREIGN KEY(org_id) REFERENCES organizations(id),
+      FOREIGN KEY(batch_id) REFERENCES batches(id)
+    );
+    CREATE TABLE IF NOT EXISTS tasks (
+      id TEXT PRIMARY KEY,
+      expression_id TEXT NOT NULL,
+      node INTEGER NOT NULL,
+      op TEXT NOT NULL,
+      arg1 REAL NOT NULL,
+      arg2 REAL NOT NULL,
+      attempt INTEGER NOT NULL,
+      status TEXT NOT NULL,
+      result REAL,
+      agent TEXT,
+      queued_at DATETIME NOT NULL,
+      issued_at DATETIME,
+      finished_at DATETIME,
+      shared_with TEXT,
+      FOREIGN KEY(expression_id) REFERENCES expressions(id)
+    );
+    CREATE INDEX IF NOT EXISTS tasks_expression ON tasks(expression_id, node);
+    CREATE TABLE IF NOT EXISTS result_cache (
+      key TEXT PRIMARY KEY,
+      mode TEXT NOT NULL,
+      result REAL NOT NULL,
+      created_at DATETIME NOT NULL
+    );
+    CREATE TABLE IF NOT EXISTS batches (
+      id TEXT PRIMARY KEY,
+      user_id INTEGER NOT NULL,
+      org_id INTEGER,
+      created_at DATETIME NOT NULL,
+      FOREIGN KEY(user_id) REFERENCES users(id),
+      FOREIGN KEY(org_id) REFERENCES organizations(id)
+    );
+    CREATE TABLE IF NOT EXISTS idempotency_keys (
+      user_id INTEGER NOT NULL,
+      key TEXT NOT NULL,
+      fingerprint TEXT NOT NULL,
+      status INTEGER NOT NULL DEFAULT 0,
+      content_type TEXT NOT NULL DEFAULT '',
+      location TEXT NOT NULL DEFAULT '',
+      body BLOB,
+      created_at DATETIME NOT NULL,
+      PRIMARY KEY(user_id, key),
+      FOREIGN KEY(user_id) REFERENCES users(id)
+    );
+    CREATE TABLE IF NOT EXISTS api_keys (
+      id INTEGER PRIMARY KEY AUTOINCREMENT,
+      user_id INTEGER NOT NULL,
+      name TEXT NOT NULL,
+      prefix TEXT NOT NULL,
+      hash TEXT UNIQUE NOT NULL,
+      scope TEXT NOT NULL,
+      created_at DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP,
+      expires_at DATETIME,
+      last_used_at DATETIME,
+      revoked INTEGER NOT NULL DEFAULT 0,
+      FOREIGN KEY(user_id) REFERENCES users(id)
+    );
+    CREATE TABLE IF NOT EXISTS organizations (
+      id INTEGER PRIMARY KEY AUTOINCREMENT,
+      name TEXT NOT NULL,
+      created_at DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP
+    );
+    CREATE TABLE IF NOT EXISTS org_members (
+      org_id INTEGER NOT NULL,
+      user_id INTEGER NOT NULL,
+      role TEXT NOT NULL,
+      PRIMARY KEY(org_id, user_id),
+      FOREIGN KEY(org_id) REFERENCES organizations(id),
+      FOREIGN KEY(user_id) REFERENCES users(id)
+    );
+    CREATE TABLE IF NOT EXISTS invitations (
+      id INTEGER PRIMARY KEY AUTOINCREMENT,
+      org_id INTEGER NOT NULL,
+      hash TEXT UNIQUE NOT NULL,
+      role TEXT NOT NULL,
+      created_by INTEGER NOT NULL,
+      expires_at DATETIME NOT NULL,
+      used_by INTEGER,
+      used_at DATETIME,
+      FOREIGN KEY(org_id) REFERENCES organizations(id)
+    );
+    CREATE TABLE IF NOT EXISTS audit_events (
+      id INTEGER PRIMARY KEY AUTOINCREMENT,
+      created_at DATETIME NOT NULL,
+      user_id INTEGER,
+      action TEXT NOT NULL,
+      target TEXT NOT NULL DEFAULT '',
+      ip TEXT NOT NULL DEFAULT '',
+      user_agent TEXT NOT NULL DEFAULT '',
+      request_id TEXT NOT NULL DEFAULT '',
+      details TEXT
+    );
+    CREATE INDEX IF NOT EXISTS audit_events_user ON audit_events(user_id, id);
+    CREATE INDEX IF NOT EXISTS audit_events_action ON audit_events(action, id);
+    CREATE INDEX IF NOT EXISTS audit_events_created ON audit_events(created_at);
+    -- журнал аудита только пополняется
+    CREATE TRIGGER IF NOT EXISTS audit_events_no_update BEFORE UPDATE ON audit_events
+    BEGIN SELECT RAISE(ABORT, 'audit_events is append-only'); END;
+    CREATE TRIGGER IF NOT EXISTS audit_events_no_delete BEFORE DELETE ON audit_events
+    BEGIN SELECT RAISE(ABORT, 'audit_events is append-only'); END;
+    CREATE TABLE IF NOT EXISTS schedules (
+      id TEXT PRIMARY KEY,
+      user_id INTEGER NOT NULL,
+      org_id INTEGER,
+      name TEXT NOT NULL DEFAULT '',
+      expression TEXT NOT NULL,
+      variables TEXT,
+      cron TEXT,
+      interval_ms INTEGER,
+      missed TEXT NOT NULL DEFAULT 'skip',
+      priority INTEGER NOT NULL DEFAULT 0,
+      enabled INTEGER NOT NULL DEFAULT 1,
+      next_run DATETIME,
+      last_run DATETIME,
+      created_at DATETIME NOT NULL,
+      FOREIGN KEY(user_id) REFERENCES users(id),
+      FOREIGN KEY(org_id) REFERENCES organizations(id)
+    );
+    CREATE INDEX IF NOT EXISTS schedules_next_run ON schedules(enabled, next_run);
+    CREATE TABLE IF NOT EXISTS webhooks (
+      id INTEGER PRIMARY KEY AUTOINCREMENT,
+      user_id INTEGER NOT NULL,
+      url TEXT NOT NULL,
+      events TEXT NOT NULL DEFAULT '',
+      created_at DATETIME NOT NULL,
+      FOREIGN KEY(user_id) REFERENCES users(id)
+    );
+    CREATE TABLE IF NOT EXISTS webhook_deliveries (
+      id TEXT PRIMARY KEY,
+      user_id INTEGER NOT NULL,
+      webhook_id INTEGER,
+      expression_id TEXT NOT NULL,
+      event TEXT NOT NULL,
+      url TEXT NOT NULL,
+      payload TEXT NOT NULL,
+      status TEXT NOT NULL,
+      attempts INTEGER NOT NULL DEFAULT 0,
+      next_attempt_at DATETIME,
+      last_error TEXT,
+      created_at DATETIME NOT NULL,
+      delivered_at DATETIME,
+      FOREIGN KEY(user_id) REFERENCES users(id)
+    );
+    CREATE INDEX IF NOT EXISTS webhook_deliveries_due ON webhook_deliveries(status, next_attempt_at);
+    CREATE INDEX IF NOT EXISTS webhook_deliveries_user ON webhook_deliveries(user_id, created_at);
+    CREATE TABLE IF NOT EXISTS webhook_attempts (
+      id INTEGER PRIMARY KEY AUTOINCREMENT,
+      delivery_id TEXT NOT NULL,
+      attempt INTEGER NOT NULL,
+      status_code INTEGER,
+      error TEXT,
+      duration_ms INTEGER NOT NULL,
+      at DATETIME NOT NULL
+    );
+    CREATE INDEX IF NOT EXISTS webhook_attempts_delivery ON webhook_attempts(delivery_id, attempt);
+    CREATE TABLE IF NOT EXISTS recovery_codes (
+      id INTEGER PRIMARY KEY AUTOINCREMENT,
+      user_id INTEGER NOT NULL,
+      hash TEXT NOT NULL,
+      used_at DATETIME,
       FOREIGN KEY(user_id) REFERENCES users(id)
     );
     `
-	_, err = Conn.Exec(schema)
-	return err
+	if _, err = Conn.Exec(schema); err != nil {
+		return err
+	}
+	return migrate()
+}
+
+// migrations добавляют столбцы (и индексы по ним) в таблицы, созданные старыми версиями.
+// Повторное добавление столбца SQLite отклоняет — такие ошибки пропускаем.
+var migrations = []string{
+	"ALTER TABLE users ADD COLUMN role TEXT NOT NULL DEFAULT 'user'",
+	"ALTER TABLE users ADD COLUMN disabled INTEGER NOT NULL DEFAULT 0",
+	"ALTER TABLE users ADD COLUMN failed_logins INTEGER NOT NULL DEFAULT 0",
+	"ALTER TABLE users ADD COLUMN locked_until DATETIME",
+	"ALTER TABLE users ADD COLUMN display_name TEXT NOT NULL DEFAULT ''",
+	"ALTER TABLE users ADD COLUMN token_version INTEGER NOT NULL DEFAULT 0",
+	"ALTER TABLE users ADD COLUMN delete_at DATETIME",
+	"ALTER TABLE users ADD COLUMN totp_secret TEXT NOT NULL DEFAULT ''",
+	"ALTER TABLE users ADD COLUMN totp_enabled INTEGER NOT NULL DEFAULT 0",
+	"ALTER TABLE users ADD COLUMN totp_last_step INTEGER NOT NULL DEFAULT 0",
+	"ALTER TABLE expressions ADD COLUMN org_id INTEGER REFERENCES organizations(id)",
+	"ALTER TABLE expressions ADD COLUMN batch_id TEXT REFERENCES batches(id)",
+	"ALTER TABLE expressions ADD COLUMN label TEXT NOT NULL DEFAULT ''",
+	"CREATE INDEX IF NOT EXISTS expressions_batch ON expressions(batch_id)",
+	"ALTER TABLE expressions ADD COLUMN error TEXT",
+	"ALTER TABLE expressions ADD COLUMN created_at DATETIME",
+	"ALTER TABLE expressions ADD COLUMN finished_at DATETIME",
+	"CREATE INDEX IF NOT EXISTS expressions_user_created ON expressions(user_id, created_at)",
+	"ALTER TABLE expressions ADD COLUMN started_at DATETIME",
+	"ALTER TABLE expressions ADD COLUMN task_count INTEGER NOT NULL DEFAULT 0",
+	"ALTER TABLE expressions ADD COLUMN compute_ms INTEGER NOT NULL DEFAULT 0",
+	"ALTER TABLE expressions ADD COLUMN agents TEXT",
+	"ALTER TABLE expressions ADD COLUMN cached INTEGER NOT NULL DEFAULT 0",
+	"ALTER TABLE tasks ADD COLUMN shared_with TEXT",
+	"ALTER TABLE expressions ADD COLUMN priority INTEGER NOT NULL DEFAULT 0",
+	"ALTER TABLE users ADD COLUMN weight REAL NOT NULL DEFAULT 1",
+	"ALTER TABLE users ADD COLUMN max_concurrent INTEGER",
+	"ALTER TABLE users ADD COLUMN quota_per_minute INTEGER",
+	"ALTER TABLE users ADD COLUMN quota_pending INTEGER",
+	"ALTER TABLE users ADD COLUMN quota_stored INTEGER",
+	"ALTER TABLE expressions ADD COLUMN schedule_id TEXT",
+	"ALTER TABLE expressions ADD COLUMN scheduled_for DATETIME",
+	"CREATE INDEX IF NOT EXISTS expressions_schedule ON expressions(schedule_id, scheduled_for)",
+	"ALTER TABLE expressions ADD COLUMN callback_url TEXT",
+	"ALTER TABLE users ADD COLUMN webhook_secret TEXT NOT NULL DEFAULT ''",
+	"ALTER TABLE schedules ADD COLUMN skipped_runs INTEGER NOT NULL DEFAULT 0",
+	"ALTER TABLE schedules ADD COLUMN last_skip_reason TEXT NOT NULL DEFAULT ''",
+	"ALTER TABLE schedules ADD COLUMN last_skipped_at DATETIME",
+}
+
+func migrate() error {
+	for _, m := range migrations {
+		if _, err := Conn.Exec(m); err != nil && !strings.Contains(err.Error(), "duplicate column name") {
+			return err
+		}
+	}
+	return nil
 }
@@ -1,28 +1,190 @@
 package errors
 
-import "net/http"
+import (
+	"context"
+	"encoding/json"
+	stderrors "errors"
+	"net/http"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
 
 // AppError определяет структуру ошибки приложения.
+// Code — стабильный машиночитаемый код, Status — HTTP-статус, который ему соответствует.
 type AppError struct {
-	Code    int    `json:"code"`
-	Message string `json:"message"`
+	Status    int          `json:"-"`
+	Code      string       `json:"code"`
+	Message   string       `json:"error"`
+	Fields    []FieldError `json:"fields,omitempty"`
+	RequestID string       `json:"request_id,omitempty"`
 }
 
-// NewAppError создает новую ошибку.
-func NewAppError(code int, message string) *AppError {
-	return &AppError{
+// FieldError описывает проблему с конкретным полем запроса.
+// Position — номер символа в значении поля (с 1), если проблема в конкретном месте.
+type FieldError struct {
+	Field    string `json:"field"`
+	Message  string `json:"message"`
+	Position int    `json:"position,omitempty"`
+}
+
+// catalogue — все объявленные ошибки, в порядке объявления.
+var catalogue []*AppError
+
+// NewAppError создает новую ошибку и регистрирует её код в каталоге.
+func NewAppError(status int, code, message string) *AppError {
+	e := &AppError{
+		Status:  status,
 		Code:    code,
 		Message: message,
 	}
+	catalogue = append(catalogue, e)
+	return e
 }
 
 func (e *AppError) Error() string {
 	return e.Message
 }
 
-// Предопределенные ошибки.
+// WithMessage возвращает копию ошибки с уточнённым текстом; код не меняется.
+func (e *AppError) WithMessage(message string) *AppError {
+	c := *e
+	c.Message = message
+	return &c
+}
+
+// WithField возвращает копию ошибки с описанием проблемного поля.
+func (e *AppError) WithField(field, message string) *AppError {
+	return e.WithFieldAt(field, message, 0)
+}
+
+// WithFieldAt — WithField с позицией проблемы внутри значения поля.
+func (e *AppError) WithFieldAt(field, message string, position int) *AppError {
+	c := *e
+	c.Fields = append(append([]FieldError(nil), e.Fields...), FieldError{field, message, position})
+	return &c
+}
+
+// GRPCStatus позволяет отдавать AppError из gRPC-методов: grpc-go сам
+// переведёт её в статус с подходящим кодом.
+func (e *AppError) GRPCStatus() *status.Status {
+	return status.New(GRPCCode(e.Status), e.Code+": "+e.Message)
+}
+
+// GRPCCode сопоставляет HTTP-статус коду gRPC.
+func GRPCCode(httpStatus int) codes.Code {
+	switch httpStatus {
+	case http.StatusBadRequest, http.StatusUnprocessableEntity:
+		return codes.InvalidArgument
+	case http.StatusUnauthorized:
+		return codes.Unauthenticated
+	case http.StatusForbidden:
+		return codes.PermissionDenied
+	case http.StatusNotFound:
+		return codes.NotFound
+	case http.StatusConflict:
+		return codes.AlreadyExists
+	case http.StatusGone:
+		return codes.Aborted
+	case http.StatusTooManyRequests:
+		return codes.ResourceExhausted
+	case http.StatusServiceUnavailable:
+		return codes.Unavailable
+	case http.StatusGatewayTimeout:
+		return codes.DeadlineExceeded
+	default:
+		return codes.Internal
+	}
+}
+
+// Предопределенные ошибки. Коды стабильны: клиенты могут на них полагаться.
 var (
-	ErrInvalidExpression = NewAppError(http.StatusUnprocessableEntity, "Expression is not valid")
-	ErrInternalServer    = NewAppError(http.StatusInternalServerError, "Internal server error")
-	ErrBadRequest        = NewAppError(http.StatusBadRequest, "Invalid JSON")
+	ErrInvalidExpression  = NewAppError(http.StatusUnprocessableEntity, "invalid_expression", "Expression is not valid")
+	ErrInternalServer     = NewAppError(http.StatusInternalServerError, "internal", "Internal server error")
+	ErrBadRequest         = NewAppError(http.StatusBadRequest, "invalid_json", "Invalid JSON")
+	ErrInvalidField       = NewAppError(http.StatusBadRequest, "invalid_field", "Invalid field value")
+	ErrUnauthorized       = NewAppError(http.StatusUnauthorized, "unauthorized", "Unauthorized")
+	ErrForbidden          = NewAppError(http.StatusForbidden, "forbidden", "Forbidden")
+	ErrNotFound           = NewAppError(http.StatusNotFound, "not_found", "Not found")
+	ErrAlreadyFinished    = NewAppError(http.StatusConflict, "already_finished", "Expression has already finished")
+	ErrRateLimited        = NewAppError(http.StatusTooManyRequests, "rate_limited", "Too many requests, try again later")
+	ErrInvalidBatch       = NewAppError(http.StatusUnprocessableEntity, "invalid_batch", "Some expressions in the batch are not valid")
+	ErrExpressionTooLarge = NewAppError(http.StatusUnprocessableEntity, "expression_too_large", "Expression exceeds the allowed size")
+	ErrQuotaExceeded      = NewAppError(http.StatusTooManyRequests, "quota_exceeded", "Expression quota exceeded")
+	ErrPayloadTooLarge    = NewAppError(http.StatusRequestEntityTooLarge, "payload_too_large", "Request body is too large")
+
+	// Idempotency-Key
+	ErrIdempotencyMismatch   = NewAppError(http.StatusConflict, "idempotency_key_reused", "Idempotency-Key was already used with a different request")
+	ErrIdempotencyInProgress = NewAppError(http.StatusConflict, "idempotency_in_progress", "A request with this Idempotency-Key is still being processed")
+
+	// Регистрация и вход
+	ErrInvalidLogin       = NewAppError(http.StatusBadRequest, "invalid_login", "Login is not valid")
+	ErrPasswordTooShort   = NewAppError(http.StatusBadRequest, "password_too_short", "Password is too short")
+	ErrPasswordTooWeak    = NewAppError(http.StatusBadRequest, "password_too_weak", "Password must contain letters and digits")
+	ErrLoginTaken         = NewAppError(http.StatusConflict, "login_taken", "Login is already taken")
+	ErrInvalidCredentials = NewAppError(http.StatusUnauthorized, "invalid_credentials", "Invalid credentials")
+	ErrAccountDisabled    = NewAppError(http.StatusForbidden, "account_disabled", "Account disabled")
+	ErrAccountLocked      = NewAppError(http.StatusTooManyRequests, "account_locked", "Too many failed logins, account is temporarily locked")
+	ErrWrongPassword      = NewAppError(http.StatusForbidden, "wrong_password", "Password is wrong")
+	ErrSessionRequired    = NewAppError(http.StatusForbidden, "session_required", "Sign in with a password, API keys cannot do this")
+
+	// Двухфакторная аутентификация
+	ErrInvalidChallenge  = NewAppError(http.StatusUnauthorized, "invalid_challenge", "Challenge is invalid or expired")
+	ErrInvalidCode       = NewAppError(http.StatusUnauthorized, "invalid_code", "Invalid two-factor code")
+	ErrWrongCode         = NewAppError(http.StatusForbidden, "wrong_code", "Invalid two-factor code")
+	ErrTwoFactorEnabled  = NewAppError(http.StatusConflict, "2fa_already_enabled", "Two-factor authentication is already enabled")
+	ErrTwoFactorNotSetUp = NewAppError(http.StatusBadRequest, "2fa_not_set_up", "Call /me/2fa/setup first")
+
+	// Администрирование и организации
+	ErrCannotChangeSelf  = NewAppError(http.StatusBadRequest, "cannot_change_self", "Cannot change own account")
+	ErrLastAdmin         = NewAppError(http.StatusConflict, "last_admin", "Cannot remove the last administrator")
+	ErrNotAMember        = NewAppError(http.StatusForbidden, "not_a_member", "You are not a member of this workspace")
+	ErrAlreadyMember     = NewAppError(http.StatusConflict, "already_member", "You are already a member")
+	ErrOwnerCannotLeave  = NewAppError(http.StatusBadRequest, "owner_cannot_leave", "The owner cannot be removed")
+	ErrInvalidInvitation = NewAppError(http.StatusNotFound, "invalid_invitation", "Invitation code is invalid, used or expired")
+
+	// gRPC-методы для агентов
+	ErrNoTasks       = NewAppError(http.StatusNotFound, "no_tasks", "No tasks")
+	ErrUnknownTask   = NewAppError(http.StatusNotFound, "unknown_task", "Unknown task")
+	ErrTaskCancelled = NewAppError(http.StatusGone, "task_cancelled", "Expression was cancelled, abandon the task")
+	ErrLeaseExpired  = NewAppError(http.StatusGone, "lease_expired", "Task lease expired, it was given to another agent")
 )
+
+// Catalogue возвращает все известные ошибки.
+func Catalogue() []*AppError {
+	return catalogue
+}
+
+// From приводит любую ошибку к AppError; неизвестные становятся ErrInternalServer.
+func From(err error) *AppError {
+	var e *AppError
+	if stderrors.As(err, &e) {
+		return e
+	}
+	return ErrInternalServer
+}
+
+// Write отвечает ошибкой в JSON: {"error": "...", "code": "...", "fields": [...], "request_id": "..."}.
+func Write(w http.ResponseWriter, r *http.Request, err error) {
+	e := *From(err)
+	if id, ok := r.Context().Value("request_id").(string); ok {
+		e.RequestID = id
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(e.Status)
+	json.NewEncoder(w).Encode(e)
+}
+
+// UnaryServerInterceptor переводит ошибки gRPC-методов через каталог:
+// всё, что не AppError и не gRPC-статус, отдаётся как codes.Internal.
+func UnaryServerInterceptor(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+	resp, err := handler(ctx, req)
+	if err == nil {
+		return resp, nil
+	}
+	if _, ok := status.FromError(err); ok {
+		return resp, err
+	}
+	return resp, From(err)
+}
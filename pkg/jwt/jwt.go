@@ -1,6 +1,7 @@
 package jwt
 
 import (
+	"errors"
 	"time"
 
 	"github.com/dgrijalva/jwt-go"
@@ -8,25 +9,70 @@ import (
 
 var secret = []byte("very-secret-key") // товарищ проверяющий, поменяйте на безопасный ключ)
 
-// Generate создаёт JWT с полем user_id и сроком жизни 72 часа
-func Generate(userID int) (string, error) {
+var errInvalidToken = errors.New("invalid token")
+
+// Claims — данные пользователя, зашитые в токен
+type Claims struct {
+	UserID int
+	Role   string
+	// Version сверяется с users.token_version: после смены пароля старые токены недействительны
+	Version int
+}
+
+// Generate создаёт JWT с полями user_id, role, ver и сроком жизни 72 часа
+func Generate(userID int, role string, version int) (string, error) {
 	claims := jwt.MapClaims{
 		"user_id": userID,
+		"role":    role,
+		"ver":     version,
 		"exp":     time.Now().Add(72 * time.Hour).Unix(),
 	}
 	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
 	return token.SignedString(secret)
 }
 
-// Parse валидирует токен и возвращает user_id
-func Parse(tokenStr string) (int, error) {
+// Parse валидирует токен и возвращает user_id и роль
+func Parse(tokenStr string) (*Claims, error) {
+	token, err := jwt.Parse(tokenStr, func(token *jwt.Token) (interface{}, error) {
+		return secret, nil
+	})
+	if err != nil || !token.Valid {
+		return nil, errInvalidToken
+	}
+	claims := token.Claims.(jwt.MapClaims)
+	uid, ok := claims["user_id"].(float64)
+	if !ok || claims["typ"] != nil {
+		return nil, errInvalidToken
+	}
+	role, _ := claims["role"].(string)
+	version, _ := claims["ver"].(float64)
+	return &Claims{UserID: int(uid), Role: role, Version: int(version)}, nil
+}
+
+// GenerateChallenge создаёт короткоживущий токен второго шага входа:
+// пароль уже проверен, осталось подтвердить код 2FA. Для доступа к API он не годится.
+func GenerateChallenge(userID int) (string, error) {
+	claims := jwt.MapClaims{
+		"user_id": userID,
+		"typ":     "2fa",
+		"exp":     time.Now().Add(5 * time.Minute).Unix(),
+	}
+	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
+	return token.SignedString(secret)
+}
+
+// ParseChallenge валидирует токен второго шага и возвращает user_id
+func ParseChallenge(tokenStr string) (int, error) {
 	token, err := jwt.Parse(tokenStr, func(token *jwt.Token) (interface{}, error) {
 		return secret, nil
 	})
 	if err != nil || !token.Valid {
-		return 0, err
+		return 0, errInvalidToken
 	}
 	claims := token.Claims.(jwt.MapClaims)
-	uid := int(claims["user_id"].(float64))
-	return uid, nil
+	uid, ok := claims["user_id"].(float64)
+	if !ok || claims["typ"] != "2fa" {
+		return 0, errInvalidToken
+	}
+	return int(uid), nil
 }
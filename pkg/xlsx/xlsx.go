@@ -0,0 +1,134 @@
+package xlsx
+
+import (
+	"archive/zip"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Writer пишет книгу Excel (.xlsx) с одним листом построчно, не держа её в памяти.
+// Строки — inline-строки, числа — числа, время — строка RFC 3339.
+type Writer struct {
+	zw    *zip.Writer
+	sheet io.Writer
+	row   int
+	err   error
+}
+
+// Служебные части книги: всё, кроме самого листа.
+const (
+	contentTypes = `<?xml version="1.0" encoding="UTF-8" standalone="yes"?>
+<Types xmlns="http://schemas.openxmlformats.org/package/2006/content-types">` +
+		`<Default Extension="rels" ContentType="application/vnd.openxmlformats-package.relationships+xml"/>` +
+		`<Default Extension="xml" ContentType="application/xml"/>` +
+		`<Override PartName="/xl/workbook.xml" ContentType="application/vnd.openxmlformats-officedocument.spreadsheetml.sheet.main+xml"/>` +
+		`<Override PartName="/xl/worksheets/sheet1.xml" ContentType="application/vnd.openxmlformats-officedocument.spreadsheetml.worksheet+xml"/>` +
+		`</Types>`
+	rootRels = `<?xml version="1.0" encoding="UTF-8" standalone="yes"?>
+<Relationships xmlns="http://schemas.openxmlformats.org/package/2006/relationships">` +
+		`<Relationship Id="rId1" Type="http://schemas.openxmlformats.org/officeDocument/2006/relationships/officeDocument" Target="xl/workbook.xml"/>` +
+		`</Relationships>`
+	workbookRels = `<?xml version="1.0" encoding="UTF-8" standalone="yes"?>
+<Relationships xmlns="http://schemas.openxmlformats.org/package/2006/relationships">` +
+		`<Relationship Id="rId1" Type="http://schemas.openxmlformats.org/officeDocument/2006/relationships/worksheet" Target="worksheets/sheet1.xml"/>` +
+		`</Relationships>`
+	workbook = `<?xml version="1.0" encoding="UTF-8" standalone="yes"?>
+<workbook xmlns="http://schemas.openxmlformats.org/spreadsheetml/2006/main" ` +
+		`xmlns:r="http://schemas.openxmlformats.org/officeDocument/2006/relationships">` +
+		`<sheets><sheet name="%s" sheetId="1" r:id="rId1"/></sheets></workbook>`
+	sheetStart = `<?xml version="1.0" encoding="UTF-8" standalone="yes"?>
+<worksheet xmlns="http://schemas.openxmlformats.org/spreadsheetml/2006/main"><sheetData>`
+	sheetEnd = `</sheetData></worksheet>`
+)
+
+// NewWriter начинает книгу с листом sheet. После последней строки нужно вызвать Close.
+func NewWriter(w io.Writer, sheet string) *Writer {
+	x := &Writer{zw: zip.NewWriter(w)}
+	parts := []struct{ name, body string }{
+		{"[Content_Types].xml", contentTypes},
+		{"_rels/.rels", rootRels},
+		{"xl/workbook.xml", fmt.Sprintf(workbook, escape(sheet))},
+		{"xl/_rels/workbook.xml.rels", workbookRels},
+		{"xl/worksheets/sheet1.xml", sheetStart},
+	}
+	for _, p := range parts {
+		if x.err != nil {
+			break
+		}
+		var f io.Writer
+		f, x.err = x.zw.Create(p.name)
+		if x.err == nil {
+			_, x.err = io.WriteString(f, p.body)
+		}
+		x.sheet = f
+	}
+	return x
+}
+
+// WriteRow добавляет строку. Ячейки: string, числа, bool, time.Time или nil (пустая).
+func (x *Writer) WriteRow(cells ...interface{}) error {
+	if x.err != nil {
+		return x.err
+	}
+	x.row++
+	var b strings.Builder
+	b.WriteString(`<row r="` + strconv.Itoa(x.row) + `">`)
+	for i, cell := range cells {
+		ref := column(i) + strconv.Itoa(x.row)
+		switch v := cell.(type) {
+		case nil:
+			continue
+		case string:
+			b.WriteString(`<c r="` + ref + `" t="inlineStr"><is><t xml:space="preserve">` + escape(v) + `</t></is></c>`)
+		case time.Time:
+			b.WriteString(`<c r="` + ref + `" t="inlineStr"><is><t>` + v.UTC().Format(time.RFC3339) + `</t></is></c>`)
+		case bool:
+			n := "0"
+			if v {
+				n = "1"
+			}
+			b.WriteString(`<c r="` + ref + `" t="b"><v>` + n + `</v></c>`)
+		case float64:
+			b.WriteString(`<c r="` + ref + `"><v>` + strconv.FormatFloat(v, 'g', -1, 64) + `</v></c>`)
+		case int:
+			b.WriteString(`<c r="` + ref + `"><v>` + strconv.Itoa(v) + `</v></c>`)
+		case int64:
+			b.WriteString(`<c r="` + ref + `"><v>` + strconv.FormatInt(v, 10) + `</v></c>`)
+		default:
+			b.WriteString(`<c r="` + ref + `" t="inlineStr"><is><t>` + escape(fmt.Sprint(v)) + `</t></is></c>`)
+		}
+	}
+	b.WriteString(`</row>`)
+	_, x.err = io.WriteString(x.sheet, b.String())
+	return x.err
+}
+
+// Close дописывает лист и оглавление архива.
+func (x *Writer) Close() error {
+	if x.err != nil {
+		return x.err
+	}
+	if _, err := io.WriteString(x.sheet, sheetEnd); err != nil {
+		return err
+	}
+	return x.zw.Close()
+}
+
+// column — буквенное имя столбца: 0 → A, 25 → Z, 26 → AA.
+func column(i int) string {
+	name := ""
+	for i++; i > 0; i = (i - 1) / 26 {
+		name = string(rune('A'+(i-1)%26)) + name
+	}
+	return name
+}
+
+func escape(s string) string {
+	var b strings.Builder
+	xml.EscapeText(&b, []byte(s))
+	return b.String()
+}
@@ -17,11 +17,35 @@ async function loadExpressionDetails() {
             ? `<p><b>Результат:</b> ${data.expression.result}</p>`
             : ''
         }
-        <p><b>Время создания:</b> ${new Date(
-          data.expression.created_at
-        ).toLocaleString()}</p>
+        ${formatTime('Создано', data.expression.created_at)}
+        ${formatTime('Начато', data.expression.started_at)}
+        ${formatTime('Завершено', data.expression.finished_at)}
+        <p><b>Задач:</b> ${data.expression.task_count}, <b>время вычисления:</b> ${
+    data.expression.compute_ms
+  } мс</p>
+        ${
+          data.expression.agents.length
+            ? `<p><b>Агенты:</b> ${data.expression.agents.join(', ')}</p>`
+            : ''
+        }
     `;
 }
 
+// У старых записей времени может не быть — тогда строка не выводится
+function formatTime(title, value) {
+  return value
+    ? `<p><b>${title}:</b> ${new Date(value).toLocaleString()}</p>`
+    : '';
+}
+
 loadExpressionDetails();
-setInterval(loadExpressionDetails, 2000);
+
+// Статус приходит событиями; после итогового статуса поток больше не нужен
+const events = new EventSource(`${API_BASE}/expressions/${exprId}/events`);
+events.addEventListener('status', (e) => {
+  const { status } = JSON.parse(e.data);
+  loadExpressionDetails();
+  if (status === 'done' || status === 'error' || status === 'cancelled') {
+    events.close();
+  }
+});
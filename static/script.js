@@ -52,6 +52,69 @@ async function loadExpressions() {
   });
 }
 
-// Автообновление каждые 5 секунд
-setInterval(loadExpressions, 5000);
-window.onload = loadExpressions;
+// Загрузка статистики для панели
+async function loadStats() {
+  const response = await fetch(`${API_BASE}/me/stats`);
+  if (!response.ok) return;
+  const { stats } = await response.json();
+
+  const ops = stats.operations.map((o) => `${o.op} ×${o.count}`).join(', ') || '—';
+  const kinds =
+    Object.entries(stats.errors.by_kind)
+      .map(([kind, e]) => `${kind}: ${e.count}`)
+      .join(', ') || '—';
+  const latency =
+    stats.latency.count > 0
+      ? `${stats.latency.avg_ms} / ${stats.latency.p50_ms} / ${stats.latency.p95_ms} / ${stats.latency.p99_ms} мс`
+      : '—';
+
+  document.getElementById('stats').innerHTML = `
+        <div><b>Всего:</b> ${stats.total}</div>
+        <div>${Object.entries(stats.counts)
+          .map(([status, n]) => `<span class="status ${status}">${status}: ${n}</span>`)
+          .join(' ')}</div>
+        <div><b>Задержка (сред. / p50 / p95 / p99):</b> ${latency}</div>
+        <div><b>Частые операции:</b> ${ops}</div>
+        <div><b>Ошибки:</b> ${(stats.errors.rate * 100).toFixed(1)}% (${kinds})</div>
+    `;
+
+  const daily = document.getElementById('stats-daily');
+  const peak = Math.max(1, ...stats.daily.map((d) => d.total));
+  daily.innerHTML = '';
+  stats.daily.forEach((d) => {
+    const bar = document.createElement('div');
+    bar.className = 'bar';
+    bar.style.height = `${(d.total / peak) * 100}%`;
+    bar.title = `${d.date}: ${d.total} (ошибок ${d.error})`;
+    daily.appendChild(bar);
+  });
+}
+
+// Статистику перечитываем не чаще раза в STATS_INTERVAL мс: при потоке результатов
+// каждое событие иначе давало бы отдельный запрос к /me/stats
+const STATS_INTERVAL = 5000;
+let statsTimer = null;
+let statsLoadedAt = 0;
+
+function scheduleStats() {
+  if (statsTimer) return;
+  const wait = Math.max(0, statsLoadedAt + STATS_INTERVAL - Date.now());
+  statsTimer = setTimeout(() => {
+    statsTimer = null;
+    statsLoadedAt = Date.now();
+    loadStats();
+  }, wait);
+}
+
+// Список обновляется на каждое событие, статистика — через scheduleStats
+function refresh() {
+  loadExpressions();
+  scheduleStats();
+}
+
+// Обновляем список по событиям сервера; при обрыве EventSource сам
+// переподключится с Last-Event-ID
+const stream = new EventSource(`${API_BASE}/expressions/stream`);
+stream.addEventListener('status', refresh);
+stream.addEventListener('reset', refresh);
+window.onload = refresh;
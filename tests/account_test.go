@@ -0,0 +1,134 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/scriptoxin/yandex-liceum-go-calc/internal/handlers"
+	"github.com/scriptoxin/yandex-liceum-go-calc/internal/orchestrator"
+	"github.com/scriptoxin/yandex-liceum-go-calc/pkg/db"
+)
+
+func login(t *testing.T, h http.Handler, body string) string {
+	t.Helper()
+	rr := doRequest(h, "POST", "/api/v1/login", "", body)
+	var out struct {
+		Token string `json:"token"`
+	}
+	json.Unmarshal(rr.Body.Bytes(), &out)
+	if out.Token == "" {
+		t.Fatalf("login failed: %d %s", rr.Code, rr.Body.String())
+	}
+	return out.Token
+}
+
+func TestChangePassword_RevokesTokens(t *testing.T) {
+	initDB(t)
+	h := handlers.NewRouter()
+	doRequest(h, "POST", "/api/v1/register", "", `{"login":"kate","password":"first1234"}`)
+	old := login(t, h, `{"login":"kate","password":"first1234"}`)
+
+	rr := doRequest(h, "POST", "/api/v1/me/password", "Bearer "+old, `{"current_password":"wrong","new_password":"second1234"}`)
+	if rr.Code != http.StatusForbidden {
+		t.Fatalf("wrong current password: expected status 403, got %d", rr.Code)
+	}
+	rr = doRequest(h, "POST", "/api/v1/me/password", "Bearer "+old, `{"current_password":"first1234","new_password":"second1234"}`)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("expected status 200, got %d: %s", rr.Code, rr.Body.String())
+	}
+	var out struct {
+		Token string `json:"token"`
+	}
+	json.Unmarshal(rr.Body.Bytes(), &out)
+
+	if rr := doRequest(h, "GET", "/api/v1/me", "Bearer "+old, ""); rr.Code != http.StatusUnauthorized {
+		t.Errorf("old token: expected status 401, got %d", rr.Code)
+	}
+	if rr := doRequest(h, "GET", "/api/v1/me", "Bearer "+out.Token, ""); rr.Code != http.StatusOK {
+		t.Errorf("new token: expected status 200, got %d", rr.Code)
+	}
+}
+
+func TestDeleteMe_RemovesData(t *testing.T) {
+	initDB(t)
+	h := handlers.NewRouter()
+	doRequest(h, "POST", "/api/v1/register", "", `{"login":"leo","password":"gone12345"}`)
+	token := login(t, h, `{"login":"leo","password":"gone12345"}`)
+	db.Conn.Exec("INSERT INTO expressions(id, user_id, expression, status) VALUES('x', 1, '1+1', 'pending')")
+	db.Conn.Exec("INSERT INTO api_keys(user_id, name, prefix, hash, scope) VALUES(1, 'k', 'ck_', 'h', 'read')")
+
+	if rr := doRequest(h, "DELETE", "/api/v1/me", "Bearer "+token, `{"password":"gone12345"}`); rr.Code != http.StatusNoContent {
+		t.Fatalf("expected status 204, got %d: %s", rr.Code, rr.Body.String())
+	}
+	for _, table := range []string{"users", "expressions", "api_keys"} {
+		var n int
+		db.Conn.QueryRow("SELECT COUNT(*) FROM " + table).Scan(&n)
+		if n != 0 {
+			t.Errorf("%s: expected no rows, got %d", table, n)
+		}
+	}
+}
+
+func TestDeleteMe_OrgsAndLiveExpressions(t *testing.T) {
+	initDB(t)
+	h := handlers.NewRouter()
+	o := orchestrator.New()
+	handlers.Orch = o
+	t.Cleanup(func() { handlers.Orch = nil })
+
+	doRequest(h, "POST", "/api/v1/register", "", `{"login":"boss","password":"gone12345"}`)
+	token := login(t, h, `{"login":"boss","password":"gone12345"}`)
+	staffID, _ := newUser(t, "staff", handlers.RoleUser)
+	peerID, _ := newUser(t, "peer", handlers.RoleUser)
+
+	// в team есть администратор staff и участник peer, в solo — только boss
+	db.Conn.Exec("INSERT INTO organizations(id, name) VALUES(1, 'team'), (2, 'solo')")
+	db.Conn.Exec("INSERT INTO org_members(org_id, user_id, role) VALUES(1, 1, 'owner'), (1, ?, 'member'), (1, ?, 'admin'), (2, 1, 'owner')", peerID, staffID)
+	db.Conn.Exec("INSERT INTO expressions(id, user_id, org_id, expression, status) VALUES('shared', 1, 1, '1+1', 'done'), ('solo', 1, 2, '1+1', 'done')")
+	db.Conn.Exec("INSERT INTO expressions(id, user_id, expression, status) VALUES('live', 1, '2+2*2', 'pending')")
+	o.Submit("live", 1, "2+2*2")
+	db.Conn.Exec("INSERT INTO schedules(id, user_id, org_id, expression, created_at) VALUES('team-report', 1, 1, '1+1', ?), ('own-report', 1, NULL, '1+1', ?)",
+		time.Now(), time.Now())
+	if len(o.Queue()) == 0 {
+		t.Fatal("expected queued tasks")
+	}
+
+	if rr := doRequest(h, "DELETE", "/api/v1/me", "Bearer "+token, `{"password":"gone12345"}`); rr.Code != http.StatusNoContent {
+		t.Fatalf("expected status 204, got %d: %s", rr.Code, rr.Body.String())
+	}
+	if q := o.Queue(); len(q) != 0 {
+		t.Errorf("expected tasks of the deleted user to be cancelled, got %v", q)
+	}
+	var ids []string
+	rows, _ := db.Conn.Query("SELECT id FROM expressions ORDER BY id")
+	for rows.Next() {
+		var id string
+		rows.Scan(&id)
+		ids = append(ids, id)
+	}
+	rows.Close()
+	if len(ids) != 1 || ids[0] != "shared" {
+		t.Errorf("expected only the shared expression to stay, got %v", ids)
+	}
+	// выражения и расписания организации переходят к новому владельцу
+	var exprOwner, schedOwner, schedules int
+	db.Conn.QueryRow("SELECT user_id FROM expressions WHERE id = 'shared'").Scan(&exprOwner)
+	db.Conn.QueryRow("SELECT user_id FROM schedules WHERE id = 'team-report'").Scan(&schedOwner)
+	db.Conn.QueryRow("SELECT COUNT(*) FROM schedules").Scan(&schedules)
+	if exprOwner != staffID || schedOwner != staffID || schedules != 1 {
+		t.Errorf("expected the org expression and schedule to pass to user %d, got %d and %d (%d schedules)",
+			staffID, exprOwner, schedOwner, schedules)
+	}
+	var role string
+	db.Conn.QueryRow("SELECT role FROM org_members WHERE org_id = 1 AND user_id = ?", staffID).Scan(&role)
+	if role != "owner" {
+		t.Errorf("expected the org admin to become owner, got %q", role)
+	}
+	var orgs int
+	db.Conn.QueryRow("SELECT COUNT(*) FROM organizations").Scan(&orgs)
+	if orgs != 1 {
+		t.Errorf("expected the org without other members to be deleted, got %d orgs", orgs)
+	}
+}
@@ -0,0 +1,71 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/gorilla/mux"
+
+	"github.com/scriptoxin/yandex-liceum-go-calc/internal/handlers"
+	"github.com/scriptoxin/yandex-liceum-go-calc/pkg/db"
+)
+
+func TestRequireRole(t *testing.T) {
+	ok := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})
+	handler := handlers.RequireRole(handlers.RoleAdmin)(ok)
+
+	for role, expected := range map[string]int{
+		handlers.RoleAdmin:    http.StatusOK,
+		handlers.RoleUser:     http.StatusForbidden,
+		handlers.RoleReadonly: http.StatusForbidden,
+	} {
+		req := httptest.NewRequest("GET", "/api/v1/admin/users", nil)
+		req = req.WithContext(context.WithValue(req.Context(), "role", role))
+		rr := httptest.NewRecorder()
+		handler.ServeHTTP(rr, req)
+		if rr.Code != expected {
+			t.Errorf("role %s: expected status %d, got %d", role, expected, rr.Code)
+		}
+	}
+}
+
+func TestAdmin_SetUserRole(t *testing.T) {
+	initDB(t)
+	h := handlers.NewRouter()
+	first, token := newUser(t, "root", handlers.RoleAdmin)
+	second, _ := newUser(t, "deputy", handlers.RoleAdmin)
+
+	rr := doRequest(h, "PUT", "/api/v1/admin/users/"+strconv.Itoa(first)+"/role", "Bearer "+token, `{"role":"user"}`)
+	if rr.Code != http.StatusBadRequest || errorCode(t, rr.Body.Bytes()) != "cannot_change_self" {
+		t.Errorf("expected cannot_change_self, got %d %s", rr.Code, rr.Body.String())
+	}
+	rr = doRequest(h, "PUT", "/api/v1/admin/users/"+strconv.Itoa(second)+"/role", "Bearer "+token, `{"role":"user"}`)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d %s", rr.Code, rr.Body.String())
+	}
+
+	// Токен второго выдан, пока он был администратором, а первый тем временем
+	// разжалован: снять последнего администратора нельзя
+	db.Conn.Exec("UPDATE users SET role = 'admin' WHERE id = ?", second)
+	db.Conn.Exec("UPDATE users SET role = 'user' WHERE id = ?", first)
+	r := mux.NewRouter()
+	r.HandleFunc("/api/v1/admin/users/{id}/role", handlers.SetUserRole)
+	req := httptest.NewRequest("PUT", "/api/v1/admin/users/"+strconv.Itoa(second+1)+"/role", strings.NewReader(`{"role":"user"}`))
+	req = req.WithContext(context.WithValue(req.Context(), "user_id", first))
+	rec := httptest.NewRecorder()
+	r.ServeHTTP(rec, req)
+	if rec.Code != http.StatusNotFound {
+		t.Errorf("expected 404 for an unknown user, got %d", rec.Code)
+	}
+	req = httptest.NewRequest("PUT", "/api/v1/admin/users/"+strconv.Itoa(second)+"/role", strings.NewReader(`{"role":"readonly"}`))
+	req = req.WithContext(context.WithValue(req.Context(), "user_id", first))
+	rec = httptest.NewRecorder()
+	r.ServeHTTP(rec, req)
+	if rec.Code != http.StatusConflict || errorCode(t, rec.Body.Bytes()) != "last_admin" {
+		t.Errorf("expected last_admin, got %d %s", rec.Code, rec.Body.String())
+	}
+}
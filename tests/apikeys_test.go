@@ -0,0 +1,75 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/scriptoxin/yandex-liceum-go-calc/internal/handlers"
+	"github.com/scriptoxin/yandex-liceum-go-calc/pkg/db"
+	"github.com/scriptoxin/yandex-liceum-go-calc/pkg/jwt"
+)
+
+// newUser заводит пользователя с ролью role и возвращает его id и JWT.
+func newUser(t *testing.T, login, role string) (int, string) {
+	t.Helper()
+	res, err := db.Conn.Exec("INSERT INTO users(login, password, role) VALUES(?, 'x', ?)", login, role)
+	if err != nil {
+		t.Fatal(err)
+	}
+	id, _ := res.LastInsertId()
+	token, err := jwt.Generate(int(id), role, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return int(id), token
+}
+
+func doRequest(h http.Handler, method, url, auth, body string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(method, url, bytes.NewBufferString(body))
+	req.Header.Set("Content-Type", "application/json")
+	if auth != "" {
+		req.Header.Set("Authorization", auth)
+	}
+	rr := httptest.NewRecorder()
+	h.ServeHTTP(rr, req)
+	return rr
+}
+
+func TestAPIKey_Lifecycle(t *testing.T) {
+	initDB(t)
+	h := handlers.NewRouter()
+	_, token := newUser(t, "ci", handlers.RoleUser)
+
+	rr := doRequest(h, "POST", "/api/v1/api-keys", "Bearer "+token, `{"name":"ci","scope":"read"}`)
+	if rr.Code != http.StatusCreated {
+		t.Fatalf("expected status 201, got %d: %s", rr.Code, rr.Body.String())
+	}
+	var created struct {
+		ID  int    `json:"id"`
+		Key string `json:"key"`
+	}
+	json.Unmarshal(rr.Body.Bytes(), &created)
+
+	if rr := doRequest(h, "GET", "/api/v1/expressions", "ApiKey "+created.Key, ""); rr.Code != http.StatusOK {
+		t.Errorf("read with key: expected status 200, got %d", rr.Code)
+	}
+	if rr := doRequest(h, "POST", "/api/v1/calculate", "ApiKey "+created.Key, `{"expression":"1+1"}`); rr.Code != http.StatusForbidden {
+		t.Errorf("submit with read key: expected status 403, got %d", rr.Code)
+	}
+
+	var used bool
+	db.Conn.QueryRow("SELECT last_used_at IS NOT NULL FROM api_keys WHERE id = ?", created.ID).Scan(&used)
+	if !used {
+		t.Error("expected last_used_at to be recorded")
+	}
+
+	if rr := doRequest(h, "DELETE", "/api/v1/api-keys/1", "Bearer "+token, ""); rr.Code != http.StatusNoContent {
+		t.Fatalf("revoke: expected status 204, got %d", rr.Code)
+	}
+	if rr := doRequest(h, "GET", "/api/v1/expressions", "ApiKey "+created.Key, ""); rr.Code != http.StatusUnauthorized {
+		t.Errorf("revoked key: expected status 401, got %d", rr.Code)
+	}
+}
@@ -0,0 +1,58 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/scriptoxin/yandex-liceum-go-calc/internal/handlers"
+	"github.com/scriptoxin/yandex-liceum-go-calc/pkg/db"
+)
+
+func TestAudit_LoginEventsAndExport(t *testing.T) {
+	initDB(t)
+	r := handlers.NewRouter()
+	_, adminToken := newUser(t, "root", handlers.RoleAdmin)
+
+	doRequest(r, "POST", "/api/v1/register", "", `{"login":"nick","password":"audit1234"}`)
+	doRequest(r, "POST", "/api/v1/login", "", `{"login":"nick","password":"wrong"}`)
+	login(t, r, `{"login":"nick","password":"audit1234"}`)
+
+	rr := doRequest(r, "GET", "/api/v1/admin/audit?action=login.*", "Bearer "+adminToken, "")
+	var out struct {
+		Events []struct {
+			Action    string `json:"action"`
+			RequestID string `json:"request_id"`
+		} `json:"events"`
+	}
+	json.Unmarshal(rr.Body.Bytes(), &out)
+	if len(out.Events) != 2 || out.Events[0].Action != handlers.AuditLoginSuccess || out.Events[1].Action != handlers.AuditLoginFailure {
+		t.Fatalf("expected success and failure events, got %s", rr.Body.String())
+	}
+	if out.Events[0].RequestID == "" {
+		t.Error("expected request_id to be recorded")
+	}
+
+	rr = doRequest(r, "GET", "/api/v1/admin/audit?format=jsonl", "Bearer "+adminToken, "")
+	lines := 0
+	scanner := bufio.NewScanner(strings.NewReader(rr.Body.String()))
+	for scanner.Scan() {
+		var event map[string]interface{}
+		if err := json.Unmarshal(scanner.Bytes(), &event); err != nil {
+			t.Fatalf("bad JSON line %q: %v", scanner.Text(), err)
+		}
+		lines++
+	}
+	if lines != 3 {
+		t.Errorf("expected 3 exported events, got %d", lines)
+	}
+
+	if _, err := db.Conn.Exec("DELETE FROM audit_events"); err == nil {
+		t.Error("expected audit_events to reject DELETE")
+	}
+	if rr := doRequest(r, "GET", "/api/v1/admin/audit", "Bearer "+adminToken, ""); rr.Code != http.StatusOK {
+		t.Errorf("expected status 200, got %d", rr.Code)
+	}
+}
@@ -0,0 +1,117 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/scriptoxin/yandex-liceum-go-calc/internal/handlers"
+	"github.com/scriptoxin/yandex-liceum-go-calc/pkg/db"
+)
+
+func errorCode(t *testing.T, body []byte) string {
+	t.Helper()
+	var out struct {
+		Code string `json:"code"`
+	}
+	json.Unmarshal(body, &out)
+	return out.Code
+}
+
+func TestRegister_Validation(t *testing.T) {
+	initDB(t)
+	h := http.HandlerFunc(handlers.Register)
+
+	cases := []struct {
+		body   string
+		status int
+		code   string
+	}{
+		{`{"login":"","password":""}`, http.StatusBadRequest, "invalid_login"},
+		{`{"login":"bob","password":"short1"}`, http.StatusBadRequest, "password_too_short"},
+		{`{"login":"bob","password":"onlyletters"}`, http.StatusBadRequest, "password_too_weak"},
+		{`{"login":"bob","password":"letters123"}`, http.StatusOK, ""},
+		{`{"login":"bob","password":"letters123"}`, http.StatusConflict, "login_taken"},
+	}
+	for _, c := range cases {
+		rr := doRequest(h, "POST", "/api/v1/register", "", c.body)
+		if rr.Code != c.status {
+			t.Errorf("%s: expected status %d, got %d", c.body, c.status, rr.Code)
+		}
+		if c.code != "" && errorCode(t, rr.Body.Bytes()) != c.code {
+			t.Errorf("%s: expected code %s, got %s", c.body, c.code, rr.Body.String())
+		}
+	}
+}
+
+func TestLogin_Lockout(t *testing.T) {
+	initDB(t)
+	old := handlers.Policy
+	handlers.Policy.LockoutThreshold = 3
+	handlers.Policy.LockoutBase = time.Minute
+	defer func() { handlers.Policy = old }()
+
+	doRequest(http.HandlerFunc(handlers.Register), "POST", "/api/v1/register", "", `{"login":"eve","password":"secret123"}`)
+	login := http.HandlerFunc(handlers.Login)
+
+	for i := 0; i < 3; i++ {
+		if rr := doRequest(login, "POST", "/api/v1/login", "", `{"login":"eve","password":"wrong"}`); rr.Code != http.StatusUnauthorized {
+			t.Fatalf("attempt %d: expected status 401, got %d", i+1, rr.Code)
+		}
+	}
+	rr := doRequest(login, "POST", "/api/v1/login", "", `{"login":"eve","password":"secret123"}`)
+	if rr.Code != http.StatusTooManyRequests || errorCode(t, rr.Body.Bytes()) != "account_locked" {
+		t.Fatalf("expected account_locked, got %d %s", rr.Code, rr.Body.String())
+	}
+	if rr.Header().Get("Retry-After") == "" {
+		t.Error("expected Retry-After header")
+	}
+}
+
+// Параллельные попытки не должны терять друг друга в счётчике неудач
+func TestLogin_ParallelFailures(t *testing.T) {
+	initDB(t)
+	old := handlers.Policy
+	handlers.Policy.LockoutThreshold = 100
+	defer func() { handlers.Policy = old }()
+
+	doRequest(http.HandlerFunc(handlers.Register), "POST", "/api/v1/register", "", `{"login":"mallory","password":"secret123"}`)
+	login := http.HandlerFunc(handlers.Login)
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			doRequest(login, "POST", "/api/v1/login", "", `{"login":"mallory","password":"wrong"}`)
+		}()
+	}
+	wg.Wait()
+	var failures int
+	db.Conn.QueryRow("SELECT failed_logins FROM users WHERE login = 'mallory'").Scan(&failures)
+	if failures != 10 {
+		t.Errorf("expected 10 failed logins, got %d", failures)
+	}
+}
+
+func TestThrottleAuth(t *testing.T) {
+	old := handlers.Policy
+	handlers.Policy.ThrottleRequests = 2
+	defer func() { handlers.Policy = old }()
+
+	h := handlers.ThrottleAuth()(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	codes := []int{}
+	for i := 0; i < 3; i++ {
+		rr := doRequest(h, "POST", "/api/v1/login", "", "{}")
+		codes = append(codes, rr.Code)
+	}
+	if codes[0] != http.StatusOK || codes[1] != http.StatusOK || codes[2] != http.StatusTooManyRequests {
+		t.Errorf("expected 200, 200, 429, got %v", codes)
+	}
+	// у другого middleware свой счётчик
+	other := handlers.ThrottleAuth()(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	if rr := doRequest(other, "POST", "/api/v1/login", "", "{}"); rr.Code != http.StatusOK {
+		t.Errorf("expected a separate counter, got %d", rr.Code)
+	}
+}
@@ -0,0 +1,83 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+
+	"github.com/scriptoxin/yandex-liceum-go-calc/internal/handlers"
+	"github.com/scriptoxin/yandex-liceum-go-calc/internal/orchestrator"
+	"github.com/scriptoxin/yandex-liceum-go-calc/pkg/db"
+)
+
+func TestBatch_RejectsInvalidItems(t *testing.T) {
+	initDB(t)
+	h := handlers.NewRouter()
+	_, token := newUser(t, "bulk", handlers.RoleUser)
+
+	rr := doRequest(h, "POST", "/api/v1/calculate/batch", "Bearer "+token,
+		`[{"expression":"1+1"},{"expression":"2+"},{"expression":"3*3"}]`)
+	if rr.Code != http.StatusUnprocessableEntity {
+		t.Fatalf("expected 422, got %d: %s", rr.Code, rr.Body.String())
+	}
+	var out struct {
+		Code   string `json:"code"`
+		Fields []struct {
+			Field string `json:"field"`
+		} `json:"fields"`
+	}
+	json.Unmarshal(rr.Body.Bytes(), &out)
+	if out.Code != "invalid_batch" || len(out.Fields) != 1 || out.Fields[0].Field != "expressions[1]" {
+		t.Errorf("unexpected body: %s", rr.Body.String())
+	}
+	var n int
+	db.Conn.QueryRow("SELECT COUNT(*) FROM expressions").Scan(&n)
+	if n != 0 {
+		t.Errorf("expected nothing inserted, got %d expressions", n)
+	}
+}
+
+func TestBatch_Progress(t *testing.T) {
+	initDB(t)
+	o := orchestrator.New()
+	handlers.Orch = o
+	defer func() { handlers.Orch = nil }()
+	h := handlers.NewRouter()
+	_, token := newUser(t, "bulk", handlers.RoleUser)
+	_, other := newUser(t, "other", handlers.RoleUser)
+
+	rr := doRequest(h, "POST", "/api/v1/calculate/batch", "Bearer "+token,
+		`{"expressions":[{"expression":"2*3","label":"a"},{"expression":"1/0","label":"b"}]}`)
+	if rr.Code != http.StatusCreated {
+		t.Fatalf("expected 201, got %d: %s", rr.Code, rr.Body.String())
+	}
+	var created struct {
+		BatchID string `json:"batch_id"`
+		Items   []struct {
+			ID    string `json:"id"`
+			Label string `json:"label"`
+		} `json:"items"`
+	}
+	json.Unmarshal(rr.Body.Bytes(), &created)
+	if len(created.Items) != 2 || created.Items[1].Label != "b" || created.Items[1].ID == "" {
+		t.Fatalf("unexpected items: %s", rr.Body.String())
+	}
+	runAgent(t, o)
+
+	rr = doRequest(h, "GET", "/api/v1/batches/"+created.BatchID, "Bearer "+token, "")
+	var got struct {
+		Batch struct {
+			Total    int            `json:"total"`
+			Counts   map[string]int `json:"counts"`
+			Finished bool           `json:"finished"`
+		} `json:"batch"`
+	}
+	json.Unmarshal(rr.Body.Bytes(), &got)
+	if got.Batch.Total != 2 || got.Batch.Counts["done"] != 1 || got.Batch.Counts["error"] != 1 || !got.Batch.Finished {
+		t.Errorf("unexpected progress: %s", rr.Body.String())
+	}
+
+	if rr := doRequest(h, "GET", "/api/v1/batches/"+created.BatchID, "Bearer "+other, ""); rr.Code != http.StatusNotFound {
+		t.Errorf("expected 404 for another user, got %d", rr.Code)
+	}
+}
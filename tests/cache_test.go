@@ -0,0 +1,91 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/scriptoxin/yandex-liceum-go-calc/internal/orchestrator"
+	"github.com/scriptoxin/yandex-liceum-go-calc/pkg/db"
+)
+
+// submitExpr сохраняет выражение и отдаёт его оркестратору.
+func submitExpr(t *testing.T, o *orchestrator.Orchestrator, id string, uid int, expr string) {
+	t.Helper()
+	db.Conn.Exec("INSERT INTO expressions(id, user_id, expression, status) VALUES(?, ?, ?, 'pending')", id, uid, expr)
+	if err := o.Submit(id, uid, expr); err != nil {
+		t.Fatalf("submit %q: %v", expr, err)
+	}
+}
+
+// exprResult возвращает статус, результат и признак попадания в кэш.
+func exprResult(id string) (status string, result float64, cached bool) {
+	db.Conn.QueryRow("SELECT status, COALESCE(result, 0), cached FROM expressions WHERE id = ?", id).
+		Scan(&status, &result, &cached)
+	return
+}
+
+func TestOrchestrator_CoalescesTasks(t *testing.T) {
+	initDB(t)
+	o := orchestrator.New()
+
+	submitExpr(t, o, "a", 1, "2+3")
+	submitExpr(t, o, "b", 2, "3+2")
+	submitExpr(t, o, "c", 3, "(2+3)*4")
+	submitExpr(t, o, "d", 3, "(1+1)/(1+1)")
+	if q := o.Queue(); len(q) != 2 {
+		t.Fatalf("expected 2 shared tasks, got %+v", q)
+	}
+	// отмена первого выражения не снимает задачу, нужную остальным
+	o.Cancel("a")
+	runAgent(t, o)
+
+	for id, want := range map[string]float64{"b": 5, "c": 20, "d": 1} {
+		if status, result, _ := exprResult(id); status != "done" || result != want {
+			t.Errorf("%s: expected done %v, got %s %v", id, want, status, result)
+		}
+	}
+	if status, _, _ := exprResult("a"); status != "cancelled" {
+		t.Errorf("a: expected cancelled, got %s", status)
+	}
+	if n := o.CacheStats().Coalesced; n != 3 {
+		t.Errorf("expected 3 coalesced tasks, got %d", n)
+	}
+	var shared int
+	db.Conn.QueryRow("SELECT COUNT(*) FROM tasks WHERE shared_with IS NOT NULL AND status = 'done'").Scan(&shared)
+	if shared != 3 {
+		t.Errorf("expected 3 shared journal rows, got %d", shared)
+	}
+}
+
+func TestOrchestrator_ResultCache(t *testing.T) {
+	initDB(t)
+	o := orchestrator.New()
+	o.Cache = orchestrator.NewCache(10, "test/v1")
+
+	submitExpr(t, o, "a", 1, "1+2*3")
+	runAgent(t, o)
+	// та же каноническая запись — ответ из кэша, без задач
+	submitExpr(t, o, "b", 2, "3 * 2 + 1")
+	if len(o.Queue()) != 0 {
+		t.Fatalf("cache hit must not create tasks")
+	}
+	if status, result, cached := exprResult("b"); status != "done" || result != 7 || !cached {
+		t.Errorf("b: expected cached 7, got %s %v %v", status, result, cached)
+	}
+	if s := o.CacheStats(); s.Hits != 1 || s.Misses != 1 || s.Stored != 1 {
+		t.Errorf("unexpected stats: %+v", s)
+	}
+
+	// после перезапуска результат берётся из БД, но только для того же режима
+	restarted := orchestrator.New()
+	restarted.Cache = orchestrator.NewCache(10, "test/v1")
+	submitExpr(t, restarted, "c", 1, "1+3*2")
+	if _, _, cached := exprResult("c"); !cached {
+		t.Errorf("expected a hit from the stored cache")
+	}
+	other := orchestrator.New()
+	other.Cache = orchestrator.NewCache(10, "test/v2")
+	submitExpr(t, other, "d", 1, "1+2*3")
+	if _, _, cached := exprResult("d"); cached {
+		t.Errorf("results of another evaluation mode must not be used")
+	}
+}
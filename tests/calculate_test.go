@@ -0,0 +1,50 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/scriptoxin/yandex-liceum-go-calc/internal/handlers"
+	"github.com/scriptoxin/yandex-liceum-go-calc/internal/orchestrator"
+)
+
+func TestCalculate_Wait(t *testing.T) {
+	initDB(t)
+	o := orchestrator.New()
+	handlers.Orch = o
+	defer func() { handlers.Orch = nil }()
+	h := handlers.NewRouter()
+	_, token := newUser(t, "sync", handlers.RoleUser)
+
+	// агент подключается чуть позже, обработчик должен его дождаться
+	go func() {
+		time.Sleep(50 * time.Millisecond)
+		runAgent(t, o)
+	}()
+	rr := doRequest(h, "POST", "/api/v1/calculate?wait=5s", "Bearer "+token, `{"expression":"2+2*2"}`)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d: %s", rr.Code, rr.Body.String())
+	}
+	var out struct {
+		Status string  `json:"status"`
+		Result float64 `json:"result"`
+	}
+	json.Unmarshal(rr.Body.Bytes(), &out)
+	if out.Status != "done" || out.Result != 6 {
+		t.Errorf("expected done 6, got %s", rr.Body.String())
+	}
+
+	// агентов нет — по таймауту 202 и ссылка на выражение
+	req := httptest.NewRequest("POST", "/api/v1/calculate", strings.NewReader(`{"expression":"1+1"}`))
+	req.Header.Set("Authorization", "Bearer "+token)
+	req.Header.Set("Prefer", "wait=1")
+	rr = httptest.NewRecorder()
+	h.ServeHTTP(rr, req)
+	if rr.Code != http.StatusAccepted || rr.Header().Get("Location") == "" {
+		t.Errorf("expected 202 with Location, got %d %q", rr.Code, rr.Header().Get("Location"))
+	}
+}
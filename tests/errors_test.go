@@ -0,0 +1,52 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+
+	"github.com/scriptoxin/yandex-liceum-go-calc/internal/handlers"
+	apperrors "github.com/scriptoxin/yandex-liceum-go-calc/pkg/errors"
+)
+
+func TestErrorFormat(t *testing.T) {
+	initDB(t)
+	h := handlers.RequestID(http.HandlerFunc(handlers.Register))
+
+	rr := doRequest(h, "POST", "/api/v1/register", "", `{"login":"bob","password":"short1"}`)
+	var body apperrors.AppError
+	if err := json.Unmarshal(rr.Body.Bytes(), &body); err != nil {
+		t.Fatalf("invalid JSON: %s", rr.Body.String())
+	}
+	if body.Code != "password_too_short" || len(body.Fields) != 1 || body.Fields[0].Field != "password" {
+		t.Errorf("unexpected error body: %s", rr.Body.String())
+	}
+	if body.RequestID == "" || body.RequestID != rr.Header().Get("X-Request-ID") {
+		t.Errorf("expected request_id %q in body, got %q", rr.Header().Get("X-Request-ID"), body.RequestID)
+	}
+}
+
+func TestErrorCatalogue(t *testing.T) {
+	rr := doRequest(http.HandlerFunc(handlers.ListErrors), "GET", "/api/v1/errors", "", "")
+	var out struct {
+		Errors []struct {
+			Code     string `json:"code"`
+			Status   int    `json:"status"`
+			GRPCCode string `json:"grpc_code"`
+		} `json:"errors"`
+	}
+	json.Unmarshal(rr.Body.Bytes(), &out)
+	seen := map[string]bool{}
+	for _, e := range out.Errors {
+		if seen[e.Code] {
+			t.Errorf("duplicate code %s", e.Code)
+		}
+		seen[e.Code] = true
+		if e.Code == "no_tasks" && e.GRPCCode != "NotFound" {
+			t.Errorf("no_tasks: expected NotFound, got %s", e.GRPCCode)
+		}
+	}
+	if !seen["invalid_expression"] || !seen["login_taken"] {
+		t.Errorf("catalogue is incomplete: %s", rr.Body.String())
+	}
+}
@@ -0,0 +1,46 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/scriptoxin/yandex-liceum-go-calc/internal/evaluator"
+)
+
+func TestCalc_UnaryMinus(t *testing.T) {
+	cases := map[string]float64{
+		"-2+3":     1,
+		"2-3":      -1,
+		"2*-3":     -6,
+		"-2*-3":    6,
+		"1--2":     3,
+		"-(2+3)*2": -10,
+		"4/-(1+1)": -2,
+		"-.5":      -0.5,
+		"(-1)":     -1,
+	}
+	for expr, expected := range cases {
+		got, err := evaluator.Calc(expr)
+		if err != nil || got != expected {
+			t.Errorf("%s: expected %v, got %v (%v)", expr, expected, got, err)
+		}
+	}
+
+	for _, expr := range []string{"-", "2*-", "--2", "-*2", "-)"} {
+		if _, err := evaluator.Calc(expr); err == nil {
+			t.Errorf("%s: expected an error", expr)
+		}
+	}
+}
+
+// Calc и Parse принимают одни и те же выражения: агент считает то, что разобрал оркестратор
+func TestCalc_SameGrammarAsParse(t *testing.T) {
+	for _, expr := range []string{
+		"--2", "1--2", "1---2", "-(-2)", "--(2)", "2*--3", "(-)", "-", "2.5.1", "()", "(1)(2)", "1 - - 2", "-.5",
+	} {
+		_, calcErr := evaluator.Calc(expr)
+		_, parseErr := evaluator.Parse(expr)
+		if (calcErr == nil) != (parseErr == nil) {
+			t.Errorf("%q: Calc error %v, Parse error %v", expr, calcErr, parseErr)
+		}
+	}
+}
@@ -0,0 +1,136 @@
+package main
+
+import (
+	"bufio"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/scriptoxin/yandex-liceum-go-calc/internal/handlers"
+	"github.com/scriptoxin/yandex-liceum-go-calc/internal/orchestrator"
+	"github.com/scriptoxin/yandex-liceum-go-calc/pkg/db"
+)
+
+func TestBus_Resume(t *testing.T) {
+	bus := orchestrator.NewBus()
+	all := func(orchestrator.Event) bool { return true }
+
+	events, cancel, _ := bus.Subscribe("", all)
+	bus.Publish(orchestrator.Event{ExpressionID: "a", Status: orchestrator.StatusPending})
+	first := <-events
+	cancel()
+	bus.Publish(orchestrator.Event{ExpressionID: "a", Status: orchestrator.StatusInProgress})
+	bus.Publish(orchestrator.Event{ExpressionID: "a", Status: orchestrator.StatusDone})
+
+	events, cancel, complete := bus.Subscribe(first.ID, all)
+	defer cancel()
+	if !complete {
+		t.Fatal("expected complete replay")
+	}
+	for _, want := range []string{orchestrator.StatusInProgress, orchestrator.StatusDone} {
+		if e := <-events; e.Status != want {
+			t.Errorf("expected %s, got %s", want, e.Status)
+		}
+	}
+	if _, _, complete := bus.Subscribe("stale-1", all); complete {
+		t.Error("ID from another run must not be complete")
+	}
+}
+
+func TestExpressionEvents(t *testing.T) {
+	initDB(t)
+	o := orchestrator.New()
+	handlers.Orch = o
+	defer func() { handlers.Orch = nil }()
+
+	srv := httptest.NewServer(handlers.NewRouter())
+	defer srv.Close()
+
+	uid, token := newUser(t, "sse", handlers.RoleUser)
+	db.Conn.Exec("INSERT INTO expressions(id, user_id, expression, status) VALUES('x', ?, '2*(3+4)', 'pending')", uid)
+	o.Submit("x", uid, "2*(3+4)")
+
+	req, _ := http.NewRequest("GET", srv.URL+"/api/v1/expressions/x/events", nil)
+	req.Header.Set("Authorization", "Bearer "+token)
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if ct := resp.Header.Get("Content-Type"); ct != "text/event-stream" {
+		t.Fatalf("expected text/event-stream, got %s", ct)
+	}
+
+	go runAgent(t, o)
+	var statuses []string
+	scanner := bufio.NewScanner(resp.Body)
+	for scanner.Scan() {
+		if data, ok := strings.CutPrefix(scanner.Text(), "data: "); ok {
+			statuses = append(statuses, data)
+		}
+	}
+	// поток закрывается сервером после итогового события
+	if len(statuses) < 2 || !strings.Contains(statuses[0], `"pending"`) ||
+		!strings.Contains(statuses[len(statuses)-1], `"status":"done","result":14`) {
+		t.Errorf("unexpected events: %v", statuses)
+	}
+}
+
+// newOrg создаёт организацию с участниками members (user_id → роль в организации).
+func newOrg(t *testing.T, members map[int]string) int {
+	t.Helper()
+	res, err := db.Conn.Exec("INSERT INTO organizations(name) VALUES('team')")
+	if err != nil {
+		t.Fatal(err)
+	}
+	id, _ := res.LastInsertId()
+	for uid, role := range members {
+		db.Conn.Exec("INSERT INTO org_members(org_id, user_id, role) VALUES(?, ?, ?)", id, uid, role)
+	}
+	return int(id)
+}
+
+// Поток организации показывает выражения всех её участников и только их
+func TestStreamExpressions_Workspace(t *testing.T) {
+	initDB(t)
+	o := orchestrator.New()
+	handlers.Orch = o
+	defer func() { handlers.Orch = nil }()
+	srv := httptest.NewServer(handlers.NewRouter())
+	defer srv.Close()
+
+	alice, token := newUser(t, "alice", handlers.RoleUser)
+	bob, _ := newUser(t, "bob", handlers.RoleUser)
+	org := newOrg(t, map[int]string{alice: "owner", bob: "member"})
+
+	req, _ := http.NewRequest("GET", srv.URL+"/api/v1/expressions/stream?workspace="+strconv.Itoa(org), nil)
+	req.Header.Set("Authorization", "Bearer "+token)
+	client := &http.Client{Timeout: 5 * time.Second}
+	resp, err := client.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("expected 200, got %d", resp.StatusCode)
+	}
+
+	db.Conn.Exec("INSERT INTO expressions(id, user_id, expression, status) VALUES('personal', ?, '1+1', 'pending')", bob)
+	o.Submit("personal", bob, "1+1")
+	db.Conn.Exec("INSERT INTO expressions(id, user_id, org_id, expression, status) VALUES('shared', ?, ?, '1+2', 'pending')", bob, org)
+	o.Submit("shared", bob, "1+2")
+
+	scanner := bufio.NewScanner(resp.Body)
+	for scanner.Scan() {
+		if data, ok := strings.CutPrefix(scanner.Text(), "data: "); ok {
+			if !strings.Contains(data, `"id":"shared"`) {
+				t.Errorf("expected the org member's expression first, got %s", data)
+			}
+			return
+		}
+	}
+	t.Error("stream closed without events")
+}
@@ -0,0 +1,171 @@
+package main
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/scriptoxin/yandex-liceum-go-calc/internal/handlers"
+	"github.com/scriptoxin/yandex-liceum-go-calc/pkg/db"
+)
+
+func TestExport_Formats(t *testing.T) {
+	initDB(t)
+	h := handlers.NewRouter()
+	uid, token := newUser(t, "exporter", handlers.RoleUser)
+	db.Conn.Exec("INSERT INTO expressions(id, user_id, expression, status, result, label, created_at) VALUES('e1', ?, '2+2', 'done', 4, 'four', '2026-01-01T00:00:00Z')", uid)
+	db.Conn.Exec("INSERT INTO expressions(id, user_id, expression, status, error, created_at) VALUES('e2', ?, '1/0', 'error', 'division by zero', '2026-01-02T00:00:00Z')", uid)
+
+	rr := doRequest(h, "GET", "/api/v1/expressions/export?format=csv", "Bearer "+token, "")
+	if rr.Code != http.StatusOK || !strings.HasPrefix(rr.Header().Get("Content-Type"), "text/csv") {
+		t.Fatalf("expected CSV, got %d %q: %s", rr.Code, rr.Header().Get("Content-Type"), rr.Body.String())
+	}
+	records, err := csv.NewReader(rr.Body).ReadAll()
+	if err != nil || len(records) != 3 {
+		t.Fatalf("expected header and 2 rows, got %v (%v)", records, err)
+	}
+	if records[1][0] != "e1" || records[1][1] != "2+2" || records[1][2] != "four" || records[1][5] != "4" {
+		t.Errorf("unexpected first row: %v", records[1])
+	}
+	if records[2][6] != "division by zero" {
+		t.Errorf("unexpected second row: %v", records[2])
+	}
+
+	rr = doRequest(h, "GET", "/api/v1/expressions/export?format=jsonl&status=done", "Bearer "+token, "")
+	lines := strings.Split(strings.TrimSpace(rr.Body.String()), "\n")
+	var first struct {
+		ID     string  `json:"id"`
+		Result float64 `json:"result"`
+	}
+	if len(lines) != 1 || json.Unmarshal([]byte(lines[0]), &first) != nil || first.ID != "e1" || first.Result != 4 {
+		t.Errorf("unexpected JSONL export: %s", rr.Body.String())
+	}
+
+	rr = doRequest(h, "GET", "/api/v1/expressions/export?format=xlsx", "Bearer "+token, "")
+	zr, err := zip.NewReader(bytes.NewReader(rr.Body.Bytes()), int64(rr.Body.Len()))
+	if err != nil {
+		t.Fatalf("xlsx is not a zip archive: %v", err)
+	}
+	var sheet []byte
+	for _, f := range zr.File {
+		if f.Name == "xl/worksheets/sheet1.xml" {
+			rc, _ := f.Open()
+			sheet, _ = io.ReadAll(rc)
+			rc.Close()
+		}
+	}
+	if !bytes.Contains(sheet, []byte("division by zero")) || !bytes.Contains(sheet, []byte("<v>4</v>")) {
+		t.Errorf("unexpected sheet: %s", sheet)
+	}
+
+	rr = doRequest(h, "GET", "/api/v1/expressions/export?format=pdf", "Bearer "+token, "")
+	if rr.Code != http.StatusBadRequest {
+		t.Errorf("expected 400 for unknown format, got %d", rr.Code)
+	}
+}
+
+func TestImport(t *testing.T) {
+	initDB(t)
+	h := handlers.NewRouter()
+	_, token := newUser(t, "importer", handlers.RoleUser)
+
+	// ошибки по строкам: ничего не сохраняется
+	rr := doRequest(h, "POST", "/api/v1/expressions/import", "Bearer "+token,
+		"expression,label,priority\n2+2,a,1\n2+*3,b,0\n1+1,c,99\n")
+	if rr.Code != http.StatusUnprocessableEntity {
+		t.Fatalf("expected 422, got %d: %s", rr.Code, rr.Body.String())
+	}
+	for _, line := range []string{`"field":"line 3"`, `"field":"line 4"`} {
+		if !strings.Contains(rr.Body.String(), line) {
+			t.Errorf("expected error for %s, got %s", line, rr.Body.String())
+		}
+	}
+
+	// выгрузка CSV загружается обратно как есть
+	rr = doRequest(h, "POST", "/api/v1/expressions/import", "Bearer "+token,
+		"id,expression,label,priority,status,result\nold-1,2+2,a,1,done,4\nold-2,\"(1+2)*3\",,0,done,9\n")
+	if rr.Code != http.StatusCreated || rr.Header().Get("Location") == "" {
+		t.Fatalf("expected 201, got %d: %s", rr.Code, rr.Body.String())
+	}
+
+	// JSONL файлом в multipart/form-data
+	var body bytes.Buffer
+	mw := multipart.NewWriter(&body)
+	fw, _ := mw.CreateFormFile("file", "history.jsonl")
+	io.WriteString(fw, "{\"expression\":\"3*3\",\"label\":\"nine\",\"status\":\"done\",\"result\":9}\n\n{\"expression\":\"10-1\"}\n")
+	mw.Close()
+	req := httptest.NewRequest("POST", "/api/v1/expressions/import", &body)
+	req.Header.Set("Content-Type", mw.FormDataContentType())
+	req.Header.Set("Authorization", "Bearer "+token)
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+	var out struct {
+		Count int `json:"count"`
+	}
+	json.Unmarshal(rec.Body.Bytes(), &out)
+	if rec.Code != http.StatusCreated || out.Count != 2 {
+		t.Fatalf("expected 201 with 2 expressions, got %d: %s", rec.Code, rec.Body.String())
+	}
+
+	var list struct {
+		Total  int            `json:"total"`
+		Counts map[string]int `json:"counts"`
+	}
+	rr = doRequest(h, "GET", "/api/v1/expressions", "Bearer "+token, "")
+	json.Unmarshal(rr.Body.Bytes(), &list)
+	if list.Total != 4 || list.Counts["pending"] != 4 {
+		t.Errorf("expected 4 pending imported expressions, got %s", rr.Body.String())
+	}
+}
+
+// Текст, похожий на формулу, выгружается с апострофом и загружается обратно без него
+func TestExport_FormulaCells(t *testing.T) {
+	initDB(t)
+	h := handlers.NewRouter()
+	uid, token := newUser(t, "exporter", handlers.RoleUser)
+	db.Conn.Exec("INSERT INTO expressions(id, user_id, expression, status, result, label, created_at) VALUES('e1', ?, '-2+3', 'done', 1, '=HYPERLINK(\"http://x\")', '2026-01-01T00:00:00Z')", uid)
+	db.Conn.Exec("INSERT INTO expressions(id, user_id, expression, status, result, label, created_at) VALUES('e2', ?, '1-4', 'done', -3, '@sum', '2026-01-02T00:00:00Z')", uid)
+	// метка, которая сама начинается с апострофа, тоже должна пережить выгрузку и загрузку
+	db.Conn.Exec("INSERT INTO expressions(id, user_id, expression, status, result, label, created_at) VALUES('e3', ?, '2+2', 'done', 4, ?, '2026-01-03T00:00:00Z')", uid, "'=1")
+
+	rr := doRequest(h, "GET", "/api/v1/expressions/export?format=csv", "Bearer "+token, "")
+	exported := rr.Body.String()
+	records, err := csv.NewReader(strings.NewReader(exported)).ReadAll()
+	if err != nil || len(records) != 4 {
+		t.Fatalf("expected header and 3 rows, got %v (%v)", records, err)
+	}
+	if records[1][1] != "'-2+3" || records[1][2] != "'=HYPERLINK(\"http://x\")" || records[2][1] != "1-4" || records[2][2] != "'@sum" ||
+		records[3][2] != "''=1" {
+		t.Errorf("expected formula-like cells to be escaped, got %v", records[1:])
+	}
+	if records[2][5] != "-3" {
+		t.Errorf("numbers must stay numbers, got %q", records[2][5])
+	}
+
+	_, other := newUser(t, "importer", handlers.RoleUser)
+	if rr := doRequest(h, "POST", "/api/v1/expressions/import", "Bearer "+other, exported); rr.Code != http.StatusCreated {
+		t.Fatalf("expected 201, got %d: %s", rr.Code, rr.Body.String())
+	}
+	var list struct {
+		Expressions []struct {
+			Expression string `json:"expression"`
+			Label      string `json:"label"`
+		} `json:"expressions"`
+	}
+	rr = doRequest(h, "GET", "/api/v1/expressions", "Bearer "+other, "")
+	json.Unmarshal(rr.Body.Bytes(), &list)
+	labels := map[string]string{}
+	for _, e := range list.Expressions {
+		labels[e.Expression] = e.Label
+	}
+	if len(labels) != 3 || labels["-2+3"] != `=HYPERLINK("http://x")` || labels["1-4"] != "@sum" || labels["2+2"] != "'=1" {
+		t.Errorf("expected the import to restore the cells, got %s", rr.Body.String())
+	}
+}
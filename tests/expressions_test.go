@@ -0,0 +1,96 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+
+	"github.com/scriptoxin/yandex-liceum-go-calc/internal/handlers"
+	"github.com/scriptoxin/yandex-liceum-go-calc/internal/orchestrator"
+	"github.com/scriptoxin/yandex-liceum-go-calc/pkg/db"
+)
+
+func TestCancelAndDelete(t *testing.T) {
+	initDB(t)
+	o := orchestrator.New()
+	handlers.Orch = o
+	defer func() { handlers.Orch = nil }()
+	h := handlers.NewRouter()
+	uid, token := newUser(t, "owner", handlers.RoleUser)
+	_, other := newUser(t, "other", handlers.RoleUser)
+
+	// разные выражения: одинаковые задачи оркестратор объединил бы в одну
+	for id, expr := range map[string]string{"a": "1+1", "b": "2+2", "c": "3+3"} {
+		db.Conn.Exec("INSERT INTO expressions(id, user_id, expression, status) VALUES(?, ?, ?, 'pending')", id, uid, expr)
+		o.Submit(id, uid, expr)
+	}
+
+	if rr := doRequest(h, "POST", "/api/v1/expressions/a/cancel", "Bearer "+other, ""); rr.Code != http.StatusNotFound {
+		t.Errorf("expected 404 for another user, got %d", rr.Code)
+	}
+	if rr := doRequest(h, "POST", "/api/v1/expressions/a/cancel", "Bearer "+token, ""); rr.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d: %s", rr.Code, rr.Body.String())
+	}
+	if rr := doRequest(h, "POST", "/api/v1/expressions/a/cancel", "Bearer "+token, ""); errorCode(t, rr.Body.Bytes()) != "already_finished" {
+		t.Errorf("expected already_finished, got %s", rr.Body.String())
+	}
+	if len(o.Queue()) != 2 {
+		t.Errorf("expected cancelled tasks to leave the queue, got %d", len(o.Queue()))
+	}
+
+	if rr := doRequest(h, "DELETE", "/api/v1/expressions/b", "Bearer "+token, ""); rr.Code != http.StatusNoContent {
+		t.Errorf("expected 204, got %d", rr.Code)
+	}
+	if rr := doRequest(h, "DELETE", "/api/v1/expressions", "Bearer "+token, ""); rr.Code != http.StatusBadRequest {
+		t.Errorf("bulk delete without a filter must be rejected, got %d", rr.Code)
+	}
+	rr := doRequest(h, "DELETE", "/api/v1/expressions?status=cancelled,pending", "Bearer "+token, "")
+	if rr.Code != http.StatusOK || rr.Body.String() != "{\"deleted\":2}\n" {
+		t.Errorf("unexpected bulk delete response: %d %s", rr.Code, rr.Body.String())
+	}
+	if len(o.Queue()) != 0 {
+		t.Errorf("expected empty queue after deleting everything, got %d", len(o.Queue()))
+	}
+}
+
+// Отменённое выражение пакета считается завершённым
+func TestCancel_BatchProgress(t *testing.T) {
+	initDB(t)
+	o := orchestrator.New()
+	handlers.Orch = o
+	defer func() { handlers.Orch = nil }()
+	h := handlers.NewRouter()
+	_, token := newUser(t, "owner", handlers.RoleUser)
+
+	rr := doRequest(h, "POST", "/api/v1/calculate/batch", "Bearer "+token,
+		`{"expressions":[{"expression":"2*3"},{"expression":"4*5"}]}`)
+	if rr.Code != http.StatusCreated {
+		t.Fatalf("expected 201, got %d: %s", rr.Code, rr.Body.String())
+	}
+	var created struct {
+		BatchID string `json:"batch_id"`
+		Items   []struct {
+			ID string `json:"id"`
+		} `json:"items"`
+	}
+	json.Unmarshal(rr.Body.Bytes(), &created)
+	if len(created.Items) != 2 {
+		t.Fatalf("unexpected items: %s", rr.Body.String())
+	}
+	if rr := doRequest(h, "POST", "/api/v1/expressions/"+created.Items[1].ID+"/cancel", "Bearer "+token, ""); rr.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d: %s", rr.Code, rr.Body.String())
+	}
+	runAgent(t, o)
+
+	rr = doRequest(h, "GET", "/api/v1/batches/"+created.BatchID, "Bearer "+token, "")
+	var got struct {
+		Batch struct {
+			Counts   map[string]int `json:"counts"`
+			Finished bool           `json:"finished"`
+		} `json:"batch"`
+	}
+	json.Unmarshal(rr.Body.Bytes(), &got)
+	if got.Batch.Counts["done"] != 1 || got.Batch.Counts["cancelled"] != 1 || !got.Batch.Finished {
+		t.Errorf("expected a finished batch with one cancelled item, got %s", rr.Body.String())
+	}
+}
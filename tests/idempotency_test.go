@@ -0,0 +1,112 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/scriptoxin/yandex-liceum-go-calc/internal/handlers"
+	"github.com/scriptoxin/yandex-liceum-go-calc/pkg/db"
+)
+
+func TestIdempotencyKey(t *testing.T) {
+	initDB(t)
+	h := handlers.NewRouter()
+	_, token := newUser(t, "retry", handlers.RoleUser)
+	_, other := newUser(t, "other", handlers.RoleUser)
+
+	post := func(token, key, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("POST", "/api/v1/calculate", strings.NewReader(body))
+		req.Header.Set("Authorization", "Bearer "+token)
+		req.Header.Set("Idempotency-Key", key)
+		rr := httptest.NewRecorder()
+		h.ServeHTTP(rr, req)
+		return rr
+	}
+	id := func(rr *httptest.ResponseRecorder) string {
+		var out struct {
+			ID string `json:"id"`
+		}
+		json.Unmarshal(rr.Body.Bytes(), &out)
+		return out.ID
+	}
+
+	first := post(token, "k1", `{"expression":"1+1"}`)
+	again := post(token, "k1", `{"expression":"1+1"}`)
+	if first.Code != http.StatusOK || again.Code != http.StatusOK || id(first) == "" || id(first) != id(again) {
+		t.Fatalf("expected the same id, got %s and %s", first.Body.String(), again.Body.String())
+	}
+	if again.Header().Get("Idempotent-Replayed") != "true" {
+		t.Error("expected Idempotent-Replayed header on the retry")
+	}
+
+	if rr := post(token, "k1", `{"expression":"2+2"}`); rr.Code != http.StatusConflict || errorCode(t, rr.Body.Bytes()) != "idempotency_key_reused" {
+		t.Errorf("expected 409 idempotency_key_reused, got %d %s", rr.Code, rr.Body.String())
+	}
+	// ключи у каждого пользователя свои
+	if rr := post(other, "k1", `{"expression":"1+1"}`); id(rr) == id(first) {
+		t.Error("another user must not get someone else's response")
+	}
+
+	var n int
+	db.Conn.QueryRow("SELECT COUNT(*) FROM expressions").Scan(&n)
+	if n != 2 {
+		t.Errorf("expected 2 expressions, got %d", n)
+	}
+}
+
+// После 429 ключ освобождается: когда лимит сбросится, тот же запрос выполнится
+func TestIdempotencyKey_NotFinal(t *testing.T) {
+	initDB(t)
+	useQuota(t, handlers.Quota{PerMinute: 1})
+	h := handlers.NewRouter()
+	_, token := newUser(t, "limited", handlers.RoleUser)
+
+	post := func(key, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("POST", "/api/v1/calculate", strings.NewReader(body))
+		req.Header.Set("Authorization", "Bearer "+token)
+		req.Header.Set("Idempotency-Key", key)
+		rr := httptest.NewRecorder()
+		h.ServeHTTP(rr, req)
+		return rr
+	}
+
+	if rr := post("a", `{"expression":"1+1"}`); rr.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d: %s", rr.Code, rr.Body.String())
+	}
+	if rr := post("b", `{"expression":"2+2"}`); rr.Code != http.StatusTooManyRequests {
+		t.Fatalf("expected 429, got %d: %s", rr.Code, rr.Body.String())
+	}
+	handlers.SubmitRate = handlers.NewSubmitCounter()
+	rr := post("b", `{"expression":"2+2"}`)
+	if rr.Code != http.StatusOK || rr.Header().Get("Idempotent-Replayed") != "" {
+		t.Errorf("expected a fresh 200 after the limit reset, got %d %s", rr.Code, rr.Body.String())
+	}
+
+	// ошибка в самом запросе окончательна и отдаётся повторно
+	handlers.SubmitRate = handlers.NewSubmitCounter()
+	if rr := post("c", `not json`); rr.Code != http.StatusBadRequest {
+		t.Fatalf("expected 400, got %d", rr.Code)
+	}
+	if rr := post("c", `not json`); rr.Code != http.StatusBadRequest || rr.Header().Get("Idempotent-Replayed") != "true" {
+		t.Errorf("expected a replayed 400, got %d", rr.Code)
+	}
+}
+
+// Тело читается до обработчика, поэтому его размер ограничивает сам Idempotent
+func TestIdempotencyKey_BodyLimit(t *testing.T) {
+	initDB(t)
+	h := handlers.NewRouter()
+	_, token := newUser(t, "huge", handlers.RoleUser)
+
+	req := httptest.NewRequest("POST", "/api/v1/expressions/import", strings.NewReader(strings.Repeat("x", 12<<20)))
+	req.Header.Set("Authorization", "Bearer "+token)
+	req.Header.Set("Idempotency-Key", "big")
+	rr := httptest.NewRecorder()
+	h.ServeHTTP(rr, req)
+	if rr.Code != http.StatusRequestEntityTooLarge || errorCode(t, rr.Body.Bytes()) != "payload_too_large" {
+		t.Errorf("expected 413 payload_too_large, got %d %s", rr.Code, rr.Body.String())
+	}
+}
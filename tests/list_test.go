@@ -0,0 +1,83 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/scriptoxin/yandex-liceum-go-calc/internal/handlers"
+	"github.com/scriptoxin/yandex-liceum-go-calc/pkg/db"
+)
+
+type expressionPage struct {
+	Expressions []struct {
+		ID         string `json:"id"`
+		Expression string `json:"expression"`
+		Status     string `json:"status"`
+		DurationMS *int64 `json:"duration_ms"`
+	} `json:"expressions"`
+	Total      int            `json:"total"`
+	Counts     map[string]int `json:"counts"`
+	NextCursor string         `json:"next_cursor"`
+}
+
+func TestGetExpressions_Pagination(t *testing.T) {
+	initDB(t)
+	h := handlers.NewRouter()
+	uid, token := newUser(t, "pager", handlers.RoleUser)
+
+	start := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+	for i := 0; i < 5; i++ {
+		status := "pending"
+		var finished interface{}
+		if i%2 == 0 {
+			status, finished = "done", start.Add(time.Duration(i)*time.Hour+time.Second)
+		}
+		db.Conn.Exec(
+			"INSERT INTO expressions(id, user_id, expression, status, created_at, finished_at) VALUES(?, ?, ?, ?, ?, ?)",
+			string(rune('a'+i)), uid, "x+"+string(rune('0'+i)), status, start.Add(time.Duration(i)*time.Hour), finished,
+		)
+	}
+
+	get := func(query string) expressionPage {
+		t.Helper()
+		rr := doRequest(h, "GET", "/api/v1/expressions"+query, "Bearer "+token, "")
+		if rr.Code != http.StatusOK {
+			t.Fatalf("%s: expected 200, got %d: %s", query, rr.Code, rr.Body.String())
+		}
+		var page expressionPage
+		json.Unmarshal(rr.Body.Bytes(), &page)
+		return page
+	}
+
+	// по умолчанию — новые сначала, страницами по limit
+	var ids []string
+	cursor := ""
+	for pages := 0; pages < 5; pages++ {
+		page := get("?limit=2&cursor=" + cursor)
+		if page.Total != 5 || page.Counts["done"] != 3 {
+			t.Fatalf("unexpected totals: %+v", page)
+		}
+		for _, e := range page.Expressions {
+			ids = append(ids, e.ID)
+		}
+		if cursor = page.NextCursor; cursor == "" {
+			break
+		}
+	}
+	if got := len(ids); got != 5 || ids[0] != "e" || ids[4] != "a" {
+		t.Errorf("expected e..a, got %v", ids)
+	}
+
+	page := get("?status=done&sort=finished_at&since=2026-01-01T01:00:00Z")
+	if len(page.Expressions) != 2 || page.Expressions[0].ID != "c" || page.Expressions[0].DurationMS == nil || *page.Expressions[0].DurationMS != 1000 {
+		t.Errorf("unexpected filtered page: %+v", page)
+	}
+	if page := get("?q=%2B3"); len(page.Expressions) != 1 || page.Expressions[0].Expression != "x+3" {
+		t.Errorf("unexpected search result: %+v", page)
+	}
+	if rr := doRequest(h, "GET", "/api/v1/expressions?sort=result", "Bearer "+token, ""); rr.Code != http.StatusBadRequest {
+		t.Errorf("expected 400 for unknown sort, got %d", rr.Code)
+	}
+}
@@ -2,77 +2,94 @@ package main
 
 import (
 	"bytes"
+	"context"
+	"encoding/json"
 	"net/http"
 	"net/http/httptest"
 	"testing"
 
 	"github.com/scriptoxin/yandex-liceum-go-calc/internal/handlers"
+	"github.com/scriptoxin/yandex-liceum-go-calc/pkg/db"
 )
 
-func TestCalculateHandler_Success(t *testing.T) {
-
-	req, err := http.NewRequest("POST", "/api/v1/calculate", bytes.NewBufferString(`{"expression": "2+2*2"}`))
+// calculateRequest — POST /api/v1/calculate от имени пользователя uid,
+// как его передаёт обработчику AuthMiddleware.
+func calculateRequest(t *testing.T, uid int, body string) *http.Request {
+	t.Helper()
+	req, err := http.NewRequest("POST", "/api/v1/calculate", bytes.NewBufferString(body))
 	if err != nil {
 		t.Fatal(err)
 	}
 	req.Header.Set("Content-Type", "application/json")
+	ctx := context.WithValue(req.Context(), "user_id", uid)
+	ctx = context.WithValue(ctx, "role", handlers.RoleUser)
+	return req.WithContext(ctx)
+}
+
+func TestCalculateHandler_Success(t *testing.T) {
+	initDB(t)
+	uid, _ := newUser(t, "calc", handlers.RoleUser)
 
 	rr := httptest.NewRecorder()
 	handler := http.HandlerFunc(handlers.Calculate)
 
-	handler.ServeHTTP(rr, req)
+	handler.ServeHTTP(rr, calculateRequest(t, uid, `{"expression": "2+2*2"}`))
 
 	if status := rr.Code; status != http.StatusOK {
-		t.Errorf("expected status 200, got %d", status)
+		t.Fatalf("expected status 200, got %d: %s", status, rr.Body.String())
 	}
-
-	expected := `{"result":"6"}`
-	if rr.Body.String() != expected {
-		t.Errorf("expected body %s, got %s", expected, rr.Body.String())
+	var out struct {
+		ID string `json:"id"`
+	}
+	json.Unmarshal(rr.Body.Bytes(), &out)
+	var status string
+	db.Conn.QueryRow("SELECT status FROM expressions WHERE id = ? AND user_id = ?", out.ID, uid).Scan(&status)
+	if out.ID == "" || status != "pending" {
+		t.Errorf("expected a pending expression, got %s (status %q)", rr.Body.String(), status)
 	}
 }
 
 func TestCalculateHandler_InvalidExpression(t *testing.T) {
-
-	req, err := http.NewRequest("POST", "/api/v1/calculate", bytes.NewBufferString(`{"expression": "2++2"}`))
-	if err != nil {
-		t.Fatal(err)
-	}
-	req.Header.Set("Content-Type", "application/json")
+	initDB(t)
+	uid, _ := newUser(t, "calc", handlers.RoleUser)
 
 	rr := httptest.NewRecorder()
 	handler := http.HandlerFunc(handlers.Calculate)
 
-	handler.ServeHTTP(rr, req)
+	handler.ServeHTTP(rr, calculateRequest(t, uid, `{"expression": "2++2"}`))
 
 	if status := rr.Code; status != http.StatusUnprocessableEntity {
 		t.Errorf("expected status 422, got %d", status)
 	}
-
-	expected := `{"error":"Expression is not valid"}`
-	if rr.Body.String() != expected {
-		t.Errorf("expected body %s, got %s", expected, rr.Body.String())
+	var out struct {
+		Code   string `json:"code"`
+		Fields []struct {
+			Field    string `json:"field"`
+			Position int    `json:"position"`
+		} `json:"fields"`
+	}
+	json.Unmarshal(rr.Body.Bytes(), &out)
+	if out.Code != "invalid_expression" || len(out.Fields) != 1 || out.Fields[0].Field != "expression" || out.Fields[0].Position != 3 {
+		t.Errorf("expected invalid_expression at position 3, got %s", rr.Body.String())
 	}
 }
 
+// Деление на ноль теперь не ошибка запроса, а итог выражения (status error),
+// поэтому внутреннюю ошибку вызываем недоступной БД
 func TestCalculateHandler_InternalError(t *testing.T) {
-	req, err := http.NewRequest("POST", "/api/v1/calculate", bytes.NewBufferString(`{"expression": "2/0"}`))
-	if err != nil {
-		t.Fatal(err)
-	}
-	req.Header.Set("Content-Type", "application/json")
+	initDB(t)
+	uid, _ := newUser(t, "calc", handlers.RoleUser)
+	db.Conn.Close()
 
 	rr := httptest.NewRecorder()
 	handler := http.HandlerFunc(handlers.Calculate)
 
-	handler.ServeHTTP(rr, req)
+	handler.ServeHTTP(rr, calculateRequest(t, uid, `{"expression": "2+2"}`))
 
 	if status := rr.Code; status != http.StatusInternalServerError {
 		t.Errorf("expected status 500, got %d", status)
 	}
-
-	expected := `{"error": "Internal server error"}`
-	if rr.Body.String() != expected {
-		t.Errorf("expected body %s, got %s", expected, rr.Body.String())
+	if code := errorCode(t, rr.Body.Bytes()); code != "internal" {
+		t.Errorf("expected code internal, got %s", rr.Body.String())
 	}
 }
@@ -0,0 +1,185 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"net"
+	"testing"
+	"time"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+
+	"github.com/scriptoxin/yandex-liceum-go-calc/internal/evaluator"
+	"github.com/scriptoxin/yandex-liceum-go-calc/internal/orchestrator"
+	"github.com/scriptoxin/yandex-liceum-go-calc/pkg/db"
+	pb "github.com/scriptoxin/yandex-liceum-go-calc/proto"
+)
+
+// initDB создаёт чистую БД во временном каталоге теста.
+func initDB(t *testing.T) {
+	t.Helper()
+	if err := db.Init(t.TempDir() + "/test.db"); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Conn.Close() })
+}
+
+// runAgent выполняет задачи из очереди, пока они не закончатся.
+// Можно запускать в отдельной горутине: ошибки пишутся через t.Errorf.
+func runAgent(t *testing.T, o *orchestrator.Orchestrator) {
+	t.Helper()
+	for {
+		task, err := o.GetTask(context.Background(), &pb.Empty{})
+		if err != nil {
+			return
+		}
+		value, err := evaluator.Calc(task.Expression)
+		if err != nil {
+			t.Errorf("agent got bad task %q: %v", task.Expression, err)
+			return
+		}
+		if _, err := o.SubmitResult(context.Background(), &pb.Result{Id: task.Id, Value: value}); err != nil {
+			t.Error(err)
+			return
+		}
+	}
+}
+
+func TestOrchestrator_Evaluates(t *testing.T) {
+	initDB(t)
+	o := orchestrator.New()
+
+	cases := map[string]float64{
+		"2+2*2":       6,
+		"(1-5)*(2+1)": -12,
+		"-3*2+10/4":   -3.5,
+		"7":           7,
+	}
+	i := 0
+	for expr := range cases {
+		i++
+		id := "e" + string(rune('0'+i))
+		db.Conn.Exec("INSERT INTO expressions(id, user_id, expression, status) VALUES(?, 1, ?, 'pending')", id, expr)
+		if err := o.Submit(id, 1, expr); err != nil {
+			t.Fatalf("submit %q: %v", expr, err)
+		}
+		runAgent(t, o)
+
+		var status string
+		var result float64
+		db.Conn.QueryRow("SELECT status, result FROM expressions WHERE id = ?", id).Scan(&status, &result)
+		if status != "done" || result != cases[expr] {
+			t.Errorf("%q: expected done %v, got %s %v", expr, cases[expr], status, result)
+		}
+	}
+}
+
+func TestOrchestrator_DivisionByZero(t *testing.T) {
+	initDB(t)
+	o := orchestrator.New()
+
+	db.Conn.Exec("INSERT INTO expressions(id, user_id, expression, status) VALUES('z', 1, '1/(2-2)', 'pending')")
+	o.Submit("z", 1, "1/(2-2)")
+	runAgent(t, o)
+
+	var status string
+	db.Conn.QueryRow("SELECT status FROM expressions WHERE id = 'z'").Scan(&status)
+	if status != "error" {
+		t.Errorf("expected status error, got %s", status)
+	}
+}
+
+func TestOrchestrator_CancelRevokesLease(t *testing.T) {
+	initDB(t)
+	o := orchestrator.New()
+
+	db.Conn.Exec("INSERT INTO expressions(id, user_id, expression, status) VALUES('c', 1, '1+2', 'pending')")
+	o.Submit("c", 1, "1+2")
+	task, err := o.GetTask(context.Background(), &pb.Empty{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !o.Cancel("c") {
+		t.Fatal("expected running expression to be cancelled")
+	}
+	_, err = o.SubmitResult(context.Background(), &pb.Result{Id: task.Id, Value: 3})
+	if status.Code(err) != codes.Aborted {
+		t.Errorf("expected Aborted for a cancelled task, got %v", err)
+	}
+	var st string
+	db.Conn.QueryRow("SELECT status FROM expressions WHERE id = 'c'").Scan(&st)
+	if st != orchestrator.StatusCancelled {
+		t.Errorf("expected cancelled, got %s", st)
+	}
+	if len(o.Queue()) != 0 {
+		t.Errorf("expected empty queue, got %+v", o.Queue())
+	}
+}
+
+func TestOrchestrator_LeaseExpires(t *testing.T) {
+	initDB(t)
+	o := orchestrator.New()
+	o.Lease = 10 * time.Millisecond
+
+	db.Conn.Exec("INSERT INTO expressions(id, user_id, expression, status) VALUES('l', 1, '2*5', 'pending')")
+	o.Submit("l", 1, "2*5")
+	stale, _ := o.GetTask(context.Background(), &pb.Empty{})
+	time.Sleep(20 * time.Millisecond)
+
+	retry, err := o.GetTask(context.Background(), &pb.Empty{})
+	if err != nil || retry.Id == stale.Id || retry.Expression != stale.Expression {
+		t.Fatalf("expected the task to be handed out again, got %v %v", retry, err)
+	}
+	if _, err := o.SubmitResult(context.Background(), &pb.Result{Id: stale.Id, Value: 10}); status.Code(err) != codes.Aborted {
+		t.Errorf("expected Aborted for an expired lease, got %v", err)
+	}
+	if _, err := o.SubmitResult(context.Background(), &pb.Result{Id: retry.Id, Value: 10}); err != nil {
+		t.Fatal(err)
+	}
+	var st string
+	db.Conn.QueryRow("SELECT status FROM expressions WHERE id = 'l'").Scan(&st)
+	if st != orchestrator.StatusDone {
+		t.Errorf("expected done, got %s", st)
+	}
+}
+
+func TestOrchestrator_RecordsTiming(t *testing.T) {
+	initDB(t)
+	o := orchestrator.New()
+
+	db.Conn.Exec("INSERT INTO expressions(id, user_id, expression, status, created_at) VALUES('m', 1, '(1+2)*(3+4)', 'pending', ?)", time.Now().UTC())
+	if err := o.Submit("m", 1, "(1+2)*(3+4)"); err != nil {
+		t.Fatal(err)
+	}
+	// два агента по очереди: задачи должны учесться за обоими
+	for i := 0; ; i++ {
+		ctx := peer.NewContext(context.Background(), &peer.Peer{Addr: &net.TCPAddr{IP: net.IPv4(10, 0, 0, byte(1+i%2)), Port: 1}})
+		task, err := o.GetTask(ctx, &pb.Empty{})
+		if err != nil {
+			break
+		}
+		value, _ := evaluator.Calc(task.Expression)
+		if _, err := o.SubmitResult(ctx, &pb.Result{Id: task.Id, Value: value}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	var (
+		tasks             int
+		agents            string
+		started, finished sql.NullTime
+	)
+	db.Conn.QueryRow("SELECT task_count, agents, started_at, finished_at FROM expressions WHERE id = 'm'").
+		Scan(&tasks, &agents, &started, &finished)
+	if tasks != 3 {
+		t.Errorf("expected 3 tasks, got %d", tasks)
+	}
+	if agents != `["10.0.0.1:1","10.0.0.2:1"]` {
+		t.Errorf("unexpected agents: %s", agents)
+	}
+	if !started.Valid || !finished.Valid || finished.Time.Before(started.Time) {
+		t.Errorf("bad timestamps: started %v, finished %v", started, finished)
+	}
+}
@@ -0,0 +1,90 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/scriptoxin/yandex-liceum-go-calc/internal/handlers"
+)
+
+func TestOrgs_SharedWorkspace(t *testing.T) {
+	initDB(t)
+	h := handlers.NewRouter()
+	_, owner := newUser(t, "owner", handlers.RoleUser)
+	_, guest := newUser(t, "guest", handlers.RoleUser)
+
+	rr := doRequest(h, "POST", "/api/v1/orgs", "Bearer "+owner, `{"name":"team"}`)
+	var org struct {
+		ID int `json:"id"`
+	}
+	json.Unmarshal(rr.Body.Bytes(), &org)
+	orgID := strconv.Itoa(org.ID)
+	ws := "/api/v1/expressions?workspace=" + orgID
+
+	doRequest(h, "POST", "/api/v1/calculate?workspace="+orgID, "Bearer "+owner, `{"expression":"1+2"}`)
+	doRequest(h, "POST", "/api/v1/calculate", "Bearer "+owner, `{"expression":"3+4"}`)
+
+	if rr := doRequest(h, "GET", ws, "Bearer "+guest, ""); rr.Code != http.StatusForbidden {
+		t.Fatalf("non-member: expected status 403, got %d", rr.Code)
+	}
+
+	rr = doRequest(h, "POST", "/api/v1/orgs/"+orgID+"/invitations", "Bearer "+owner, `{}`)
+	var inv struct {
+		Code string `json:"code"`
+	}
+	json.Unmarshal(rr.Body.Bytes(), &inv)
+	accept := `{"code":"` + inv.Code + `"}`
+	if rr := doRequest(h, "POST", "/api/v1/invitations/accept", "Bearer "+guest, accept); rr.Code != http.StatusOK {
+		t.Fatalf("accept: expected status 200, got %d: %s", rr.Code, rr.Body.String())
+	}
+	if rr := doRequest(h, "POST", "/api/v1/invitations/accept", "Bearer "+guest, accept); rr.Code != http.StatusNotFound {
+		t.Errorf("reused invitation: expected status 404, got %d", rr.Code)
+	}
+
+	rr = doRequest(h, "GET", ws, "Bearer "+guest, "")
+	var list struct {
+		Expressions []map[string]interface{} `json:"expressions"`
+	}
+	json.Unmarshal(rr.Body.Bytes(), &list)
+	if len(list.Expressions) != 1 {
+		t.Errorf("expected 1 shared expression, got %d: %s", len(list.Expressions), rr.Body.String())
+	}
+
+	// Личное выражение владельца в общем пространстве не видно
+	rr = doRequest(h, "GET", "/api/v1/expressions", "Bearer "+guest, "")
+	if strings.Contains(rr.Body.String(), "id") {
+		t.Errorf("guest personal workspace should be empty, got %s", rr.Body.String())
+	}
+}
+
+// Администратор исключает участников, но не других администраторов
+func TestOrgs_RemoveMember(t *testing.T) {
+	initDB(t)
+	h := handlers.NewRouter()
+	ownerID, owner := newUser(t, "owner", handlers.RoleUser)
+	adminID, admin := newUser(t, "admin", handlers.RoleUser)
+	otherID, _ := newUser(t, "other", handlers.RoleUser)
+	memberID, _ := newUser(t, "member", handlers.RoleUser)
+	org := strconv.Itoa(newOrg(t, map[int]string{
+		ownerID: handlers.OrgOwner, adminID: handlers.OrgAdmin, otherID: handlers.OrgAdmin, memberID: handlers.OrgMember,
+	}))
+	remove := func(token string, uid int) int {
+		return doRequest(h, "DELETE", "/api/v1/orgs/"+org+"/members/"+strconv.Itoa(uid), "Bearer "+token, "").Code
+	}
+
+	if code := remove(admin, otherID); code != http.StatusForbidden {
+		t.Errorf("admin removing an admin: expected status 403, got %d", code)
+	}
+	if code := remove(admin, memberID); code != http.StatusNoContent {
+		t.Errorf("admin removing a member: expected status 204, got %d", code)
+	}
+	if code := remove(owner, otherID); code != http.StatusNoContent {
+		t.Errorf("owner removing an admin: expected status 204, got %d", code)
+	}
+	if code := remove(admin, adminID); code != http.StatusNoContent {
+		t.Errorf("admin leaving: expected status 204, got %d", code)
+	}
+}
@@ -0,0 +1,129 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"sync"
+	"testing"
+
+	"github.com/scriptoxin/yandex-liceum-go-calc/internal/handlers"
+	"github.com/scriptoxin/yandex-liceum-go-calc/pkg/db"
+)
+
+// useQuota на время теста задаёт ограничения роли user и пустой счётчик отправок за минуту.
+func useQuota(t *testing.T, q handlers.Quota) {
+	t.Helper()
+	savedQuotas, savedRate := handlers.Quotas, handlers.SubmitRate
+	handlers.Quotas = map[string]handlers.Quota{handlers.RoleUser: q}
+	handlers.SubmitRate = handlers.NewSubmitCounter()
+	t.Cleanup(func() { handlers.Quotas, handlers.SubmitRate = savedQuotas, savedRate })
+}
+
+func TestQuotas(t *testing.T) {
+	initDB(t)
+	h := handlers.NewRouter()
+	useQuota(t, handlers.Quota{PerMinute: 3, Pending: 5, Stored: 10})
+
+	_, fast := newUser(t, "fast", handlers.RoleUser)
+	for i := 0; i < 3; i++ {
+		rr := doRequest(h, "POST", "/api/v1/calculate", "Bearer "+fast, `{"expression":"1+1"}`)
+		if rr.Code != http.StatusOK {
+			t.Fatalf("expected 200, got %d: %s", rr.Code, rr.Body.String())
+		}
+		if got := rr.Header().Get("X-RateLimit-Remaining"); got != strconv.Itoa(2-i) {
+			t.Errorf("expected X-RateLimit-Remaining %d, got %q", 2-i, got)
+		}
+	}
+	rr := doRequest(h, "POST", "/api/v1/calculate", "Bearer "+fast, `{"expression":"1+1"}`)
+	if rr.Code != http.StatusTooManyRequests || errorCode(t, rr.Body.Bytes()) != "rate_limited" {
+		t.Fatalf("expected 429 rate_limited, got %d: %s", rr.Code, rr.Body.String())
+	}
+	if rr.Header().Get("Retry-After") == "" || rr.Header().Get("X-RateLimit-Limit") != "3" {
+		t.Errorf("expected Retry-After and X-RateLimit-Limit headers, got %v", rr.Header())
+	}
+
+	var usage struct {
+		PerMinute struct {
+			Used  int `json:"used"`
+			Limit int `json:"limit"`
+		} `json:"per_minute"`
+		Pending struct {
+			Used int `json:"used"`
+		} `json:"pending"`
+	}
+	rr = doRequest(h, "GET", "/api/v1/me/usage", "Bearer "+fast, "")
+	json.Unmarshal(rr.Body.Bytes(), &usage)
+	if usage.PerMinute.Used != 3 || usage.PerMinute.Limit != 3 || usage.Pending.Used != 3 {
+		t.Errorf("unexpected usage: %s", rr.Body.String())
+	}
+
+	// пакет из шести выражений не помещается в 5 ожидающих
+	bulkID, bulk := newUser(t, "bulk", handlers.RoleUser)
+	rr = doRequest(h, "POST", "/api/v1/calculate/batch", "Bearer "+bulk,
+		`[{"expression":"1+1"},{"expression":"1+2"},{"expression":"1+3"},{"expression":"1+4"},{"expression":"1+5"},{"expression":"1+6"}]`)
+	if rr.Code != http.StatusTooManyRequests || errorCode(t, rr.Body.Bytes()) != "quota_exceeded" {
+		t.Fatalf("expected 429 quota_exceeded, got %d: %s", rr.Code, rr.Body.String())
+	}
+
+	// досчитанные выражения не ждут, но хранятся: упираемся в stored
+	for i := 0; i < 10; i++ {
+		db.Conn.Exec("INSERT INTO expressions(id, user_id, expression, status) VALUES(?, ?, '1+1', 'done')",
+			"old-"+strconv.Itoa(i), bulkID)
+	}
+	rr = doRequest(h, "POST", "/api/v1/calculate", "Bearer "+bulk, `{"expression":"1+1"}`)
+	if rr.Code != http.StatusTooManyRequests || errorCode(t, rr.Body.Bytes()) != "quota_exceeded" {
+		t.Fatalf("expected 429 quota_exceeded, got %d: %s", rr.Code, rr.Body.String())
+	}
+
+	// администратор снимает ограничение на хранение для пользователя
+	_, admin := newUser(t, "root", handlers.RoleAdmin)
+	rr = doRequest(h, "PUT", "/api/v1/admin/users/"+strconv.Itoa(bulkID)+"/quota", "Bearer "+admin, `{"stored":0}`)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d: %s", rr.Code, rr.Body.String())
+	}
+	rr = doRequest(h, "POST", "/api/v1/calculate", "Bearer "+bulk, `{"expression":"1+1"}`)
+	if rr.Code != http.StatusOK {
+		t.Errorf("expected 200 after override, got %d: %s", rr.Code, rr.Body.String())
+	}
+}
+
+// Одновременные отправки не обходят ограничение: подсчёт и запись идут под одной блокировкой
+func TestQuotas_Concurrent(t *testing.T) {
+	initDB(t)
+	h := handlers.NewRouter()
+	useQuota(t, handlers.Quota{Pending: 3, Stored: 3})
+
+	uid, token := newUser(t, "racer", handlers.RoleUser)
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			doRequest(h, "POST", "/api/v1/calculate", "Bearer "+token, `{"expression":"1+1"}`)
+		}()
+	}
+	wg.Wait()
+	var stored int
+	db.Conn.QueryRow("SELECT COUNT(*) FROM expressions WHERE user_id = ?", uid).Scan(&stored)
+	if stored != 3 {
+		t.Errorf("expected 3 stored expressions, got %d", stored)
+	}
+}
+
+// Выражение, которое не удалось сохранить, не расходует лимит за минуту
+func TestQuotas_RefundOnFailure(t *testing.T) {
+	initDB(t)
+	h := handlers.NewRouter()
+	useQuota(t, handlers.Quota{PerMinute: 1})
+
+	_, token := newUser(t, "unlucky", handlers.RoleUser)
+	db.Conn.Exec("CREATE TRIGGER expressions_fail BEFORE INSERT ON expressions BEGIN SELECT RAISE(ABORT, 'disk full'); END")
+	if rr := doRequest(h, "POST", "/api/v1/calculate", "Bearer "+token, `{"expression":"1+1"}`); rr.Code != http.StatusInternalServerError {
+		t.Fatalf("expected 500, got %d: %s", rr.Code, rr.Body.String())
+	}
+	db.Conn.Exec("DROP TRIGGER expressions_fail")
+	if rr := doRequest(h, "POST", "/api/v1/calculate", "Bearer "+token, `{"expression":"1+1"}`); rr.Code != http.StatusOK {
+		t.Errorf("expected the failed submission to be refunded, got %d: %s", rr.Code, rr.Body.String())
+	}
+}
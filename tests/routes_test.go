@@ -0,0 +1,109 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"regexp"
+	"strings"
+	"testing"
+
+	"github.com/gorilla/mux"
+
+	"github.com/scriptoxin/yandex-liceum-go-calc/internal/handlers"
+)
+
+// selfService — изменяющие маршруты, доступные и readonly: вход и собственный аккаунт.
+var selfService = map[string]bool{
+	"/api/v1/register":       true,
+	"/api/v1/login":          true,
+	"/api/v1/login/2fa":      true,
+	"/api/v1/validate":       true,
+	"/api/v1/me":             true,
+	"/api/v1/me/password":    true,
+	"/api/v1/me/restore":     true,
+	"/api/v1/me/2fa/setup":   true,
+	"/api/v1/me/2fa/enable":  true,
+	"/api/v1/me/2fa/disable": true,
+	"/api/v1/api-keys":       true,
+	"/api/v1/api-keys/{id}":  true,
+}
+
+// Все изменяющие маршруты из NewRouter, кроме selfService, закрыты для readonly
+func TestRoutes_WritersOnly(t *testing.T) {
+	initDB(t)
+	r := handlers.NewRouter()
+	_, token := newUser(t, "reader", handlers.RoleReadonly)
+	vars := regexp.MustCompile(`\{[^}]+\}`)
+
+	checked := 0
+	r.Walk(func(route *mux.Route, _ *mux.Router, _ []*mux.Route) error {
+		path, err := route.GetPathTemplate()
+		if err != nil || selfService[path] {
+			return nil
+		}
+		methods, _ := route.GetMethods()
+		for _, method := range methods {
+			if method == "GET" {
+				continue
+			}
+			url := vars.ReplaceAllString(path, "1")
+			rr := doRequest(r, method, url, "Bearer "+token, "{}")
+			if rr.Code != http.StatusForbidden {
+				t.Errorf("%s %s: expected 403 for readonly, got %d", method, path, rr.Code)
+			}
+			checked++
+		}
+		return nil
+	})
+	if checked == 0 {
+		t.Fatal("no routes checked")
+	}
+}
+
+// Аккаунт и API-ключи нельзя менять с API-ключом, даже со scope submit
+func TestRoutes_AccountNeedsSession(t *testing.T) {
+	initDB(t)
+	r := handlers.NewRouter()
+	_, token := newUser(t, "owner", handlers.RoleUser)
+	rr := doRequest(r, "POST", "/api/v1/api-keys", "Bearer "+token, `{"name":"ci","scope":"submit"}`)
+	if rr.Code != http.StatusCreated {
+		t.Fatalf("expected 201, got %d: %s", rr.Code, rr.Body.String())
+	}
+	var created struct {
+		Key string `json:"key"`
+	}
+	json.Unmarshal(rr.Body.Bytes(), &created)
+	vars := regexp.MustCompile(`\{[^}]+\}`)
+
+	checked := 0
+	r.Walk(func(route *mux.Route, _ *mux.Router, _ []*mux.Route) error {
+		path, err := route.GetPathTemplate()
+		if err != nil {
+			return nil
+		}
+		methods, _ := route.GetMethods()
+		for _, method := range methods {
+			account := strings.HasPrefix(path, "/api/v1/me") && method != "GET"
+			if !account && !strings.HasPrefix(path, "/api/v1/api-keys") {
+				continue
+			}
+			rr := doRequest(r, method, vars.ReplaceAllString(path, "1"), "ApiKey "+created.Key, "{}")
+			if rr.Code != http.StatusForbidden || errorCode(t, rr.Body.Bytes()) != "session_required" {
+				t.Errorf("%s %s: expected 403 session_required with an API key, got %d %s", method, path, rr.Code, rr.Body.String())
+			}
+			checked++
+		}
+		return nil
+	})
+	if checked < 10 {
+		t.Fatalf("expected to check all account routes, checked %d", checked)
+	}
+
+	// с JWT те же маршруты доступны, а ключом можно читать свой профиль
+	if rr := doRequest(r, "GET", "/api/v1/api-keys", "Bearer "+token, ""); rr.Code != http.StatusOK {
+		t.Errorf("expected 200 with JWT, got %d", rr.Code)
+	}
+	if rr := doRequest(r, "GET", "/api/v1/me", "ApiKey "+created.Key, ""); rr.Code != http.StatusOK {
+		t.Errorf("expected GET /me to work with a key, got %d", rr.Code)
+	}
+}
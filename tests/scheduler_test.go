@@ -0,0 +1,148 @@
+package main
+
+import (
+	"context"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/scriptoxin/yandex-liceum-go-calc/internal/evaluator"
+	"github.com/scriptoxin/yandex-liceum-go-calc/internal/orchestrator"
+	pb "github.com/scriptoxin/yandex-liceum-go-calc/proto"
+)
+
+// submitMany отправляет от пользователя n разных выражений с операцией op,
+// чтобы по задаче было видно, чья она (одинаковые задачи объединились бы).
+func submitMany(t *testing.T, o *orchestrator.Orchestrator, uid int, op string, n, priority int) {
+	t.Helper()
+	for i := 0; i < n; i++ {
+		id := strconv.Itoa(uid) + "-" + strconv.Itoa(priority) + "-" + strconv.Itoa(i)
+		if err := o.SubmitPriority(id, uid, strconv.Itoa(i+1)+op+strconv.Itoa(uid*1000), priority); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+// simulate — агенты по очереди берут и сразу выполняют задачи, пока не выдано limit задач.
+// Возвращает выданные задачи по порядку.
+func simulate(t *testing.T, o *orchestrator.Orchestrator, agents, limit int) []string {
+	t.Helper()
+	var handed []string
+	for len(handed) < limit {
+		progress := false
+		for a := 0; a < agents && len(handed) < limit; a++ {
+			task, err := o.GetTask(context.Background(), &pb.Empty{})
+			if err != nil {
+				continue
+			}
+			progress = true
+			handed = append(handed, task.Expression)
+			value, _ := evaluator.Calc(task.Expression)
+			if _, err := o.SubmitResult(context.Background(), &pb.Result{Id: task.Id, Value: value}); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if !progress {
+			break
+		}
+	}
+	return handed
+}
+
+func count(tasks []string, op string) int {
+	n := 0
+	for _, e := range tasks {
+		if strings.Contains(e, op) {
+			n++
+		}
+	}
+	return n
+}
+
+func TestScheduler_NoStarvation(t *testing.T) {
+	initDB(t)
+	o := orchestrator.New()
+
+	// пользователь 1 завалил очередь, пользователь 2 пришёл позже
+	submitMany(t, o, 1, "+", 1000, 0)
+	submitMany(t, o, 2, "*", 10, 0)
+
+	handed := simulate(t, o, 3, 1010)
+	last := 0
+	for i, e := range handed {
+		if strings.Contains(e, "*") {
+			last = i
+		}
+	}
+	// при поочерёдной выдаче десятая задача второго пользователя — примерно двадцатая по счёту
+	if count(handed, "*") != 10 || last > 21 {
+		t.Errorf("user 2 waited too long: last task handed out at position %d", last)
+	}
+	if len(handed) != 1010 {
+		t.Errorf("expected all 1010 tasks to be handed out, got %d", len(handed))
+	}
+}
+
+func TestScheduler_Weights(t *testing.T) {
+	initDB(t)
+	o := orchestrator.New()
+	o.SetUserLimits(1, orchestrator.UserLimits{Weight: 3})
+
+	submitMany(t, o, 1, "+", 100, 0)
+	submitMany(t, o, 2, "*", 100, 0)
+
+	handed := simulate(t, o, 2, 40)
+	if got := count(handed, "+"); got < 28 || got > 32 {
+		t.Errorf("expected about 30 of 40 tasks for the user with weight 3, got %d", got)
+	}
+}
+
+func TestScheduler_Priority(t *testing.T) {
+	initDB(t)
+	o := orchestrator.New()
+
+	submitMany(t, o, 1, "+", 5, 0)
+	submitMany(t, o, 1, "-", 2, 5)
+
+	handed := simulate(t, o, 1, 7)
+	if count(handed[:2], "-") != 2 {
+		t.Errorf("expected high-priority tasks first, got %v", handed)
+	}
+}
+
+func TestScheduler_MaxConcurrent(t *testing.T) {
+	initDB(t)
+	o := orchestrator.New()
+	o.MaxConcurrent = 2
+
+	submitMany(t, o, 1, "+", 10, 0)
+	for i := 0; i < 2; i++ {
+		if _, err := o.GetTask(context.Background(), &pb.Empty{}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// у пользователя 1 уже две задачи в работе — третью не выдаём
+	if _, err := o.GetTask(context.Background(), &pb.Empty{}); err == nil {
+		t.Fatal("expected no task over the per-user limit")
+	}
+	// но другой пользователь своё получает
+	submitMany(t, o, 2, "*", 1, 0)
+	task, err := o.GetTask(context.Background(), &pb.Empty{})
+	if err != nil || !strings.Contains(task.Expression, "*") {
+		t.Fatalf("expected user 2's task, got %v %v", task, err)
+	}
+
+	// своё ограничение пользователя важнее общего
+	o.SetUserLimits(2, orchestrator.UserLimits{Weight: 1, MaxConcurrent: 3})
+	submitMany(t, o, 2, "/", 3, 0)
+	handed := 0
+	for {
+		if _, err := o.GetTask(context.Background(), &pb.Empty{}); err != nil {
+			break
+		}
+		handed++
+	}
+	if handed != 2 {
+		t.Errorf("expected 2 more tasks for user 2 (limit 3), got %d", handed)
+	}
+}
@@ -0,0 +1,175 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/scriptoxin/yandex-liceum-go-calc/internal/handlers"
+	"github.com/scriptoxin/yandex-liceum-go-calc/pkg/cron"
+	"github.com/scriptoxin/yandex-liceum-go-calc/pkg/db"
+)
+
+func TestCron_Next(t *testing.T) {
+	base := time.Date(2025, 3, 14, 10, 17, 30, 0, time.UTC) // пятница
+	cases := map[string]time.Time{
+		"* * * * *":      time.Date(2025, 3, 14, 10, 18, 0, 0, time.UTC),
+		"*/15 * * * *":   time.Date(2025, 3, 14, 10, 30, 0, 0, time.UTC),
+		"@hourly":        time.Date(2025, 3, 14, 11, 0, 0, 0, time.UTC),
+		"0 9-18 * * 1-5": time.Date(2025, 3, 14, 11, 0, 0, 0, time.UTC),
+		"30 8 * * 1":     time.Date(2025, 3, 17, 8, 30, 0, 0, time.UTC),
+		"0 0 1 */3 *":    time.Date(2025, 4, 1, 0, 0, 0, 0, time.UTC),
+		"0 12 29 2 *":    time.Date(2028, 2, 29, 12, 0, 0, 0, time.UTC),
+		"0 0 13 * 5":     time.Date(2025, 3, 21, 0, 0, 0, 0, time.UTC), // 13-е или пятница
+	}
+	for spec, expected := range cases {
+		s, err := cron.Parse(spec)
+		if err != nil {
+			t.Fatalf("%s: %v", spec, err)
+		}
+		if got := s.Next(base); !got.Equal(expected) {
+			t.Errorf("%s: expected %s, got %s", spec, expected, got)
+		}
+	}
+	for _, spec := range []string{"", "* * * *", "60 * * * *", "* * 0 * *", "5-1 * * * *", "*/0 * * * *"} {
+		if _, err := cron.Parse(spec); err == nil {
+			t.Errorf("%q: expected error", spec)
+		}
+	}
+}
+
+// createSchedule создаёт расписание и возвращает его id и время первого запуска.
+func createSchedule(t *testing.T, h http.Handler, token, body string) (string, time.Time) {
+	t.Helper()
+	rr := doRequest(h, "POST", "/api/v1/schedules", "Bearer "+token, body)
+	if rr.Code != http.StatusCreated {
+		t.Fatalf("expected 201, got %d: %s", rr.Code, rr.Body.String())
+	}
+	var out struct {
+		Schedule struct {
+			ID      string    `json:"id"`
+			NextRun time.Time `json:"next_run"`
+		} `json:"schedule"`
+	}
+	json.Unmarshal(rr.Body.Bytes(), &out)
+	return out.Schedule.ID, out.Schedule.NextRun
+}
+
+// scheduleRuns возвращает выражения, созданные по расписанию, от старых к новым.
+func scheduleRuns(t *testing.T, h http.Handler, token, id string) []map[string]interface{} {
+	t.Helper()
+	rr := doRequest(h, "GET", "/api/v1/schedules/"+id+"/runs?sort=scheduled_for", "Bearer "+token, "")
+	var out struct {
+		Expressions []map[string]interface{} `json:"expressions"`
+	}
+	json.Unmarshal(rr.Body.Bytes(), &out)
+	return out.Expressions
+}
+
+func TestSchedules_Runs(t *testing.T) {
+	initDB(t)
+	h := handlers.NewRouter()
+	_, token := newUser(t, "reports", handlers.RoleUser)
+
+	rr := doRequest(h, "POST", "/api/v1/schedules", "Bearer "+token,
+		`{"expression":"a*b+c","variables":{"a":2,"b":3},"interval":"1h"}`)
+	if rr.Code != http.StatusUnprocessableEntity {
+		t.Errorf("expected 422 for unknown variable, got %d: %s", rr.Code, rr.Body.String())
+	}
+
+	id, first := createSchedule(t, h, token,
+		`{"name":"hourly","expression":"a*b+1","variables":{"a":2,"b":-3},"interval":"1h"}`)
+
+	handlers.RunSchedules(first.Add(-time.Second))
+	if runs := scheduleRuns(t, h, token, id); len(runs) != 0 {
+		t.Fatalf("expected no runs before the time, got %d", len(runs))
+	}
+	handlers.RunSchedules(first)
+	handlers.RunSchedules(first.Add(time.Second))
+	runs := scheduleRuns(t, h, token, id)
+	if len(runs) != 1 || runs[0]["expression"] != "(2)*(-3)+1" || runs[0]["label"] != "hourly" {
+		t.Fatalf("expected one run of (2)*(-3)+1, got %v", runs)
+	}
+
+	// новые значения переменных действуют со следующего запуска
+	rr = doRequest(h, "PATCH", "/api/v1/schedules/"+id, "Bearer "+token, `{"variables":{"a":5,"b":5}}`)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d: %s", rr.Code, rr.Body.String())
+	}
+	var updates int
+	db.Conn.QueryRow("SELECT COUNT(*) FROM audit_events WHERE action = ? AND target = ?", handlers.AuditScheduleUpdate, id).Scan(&updates)
+	if updates != 1 {
+		t.Errorf("expected one %s audit event, got %d", handlers.AuditScheduleUpdate, updates)
+	}
+	handlers.RunSchedules(first.Add(time.Hour))
+	runs = scheduleRuns(t, h, token, id)
+	if len(runs) != 2 || runs[1]["expression"] != "(5)*(5)+1" {
+		t.Fatalf("expected second run with new variables, got %v", runs)
+	}
+}
+
+func TestSchedules_MissedRuns(t *testing.T) {
+	initDB(t)
+	h := handlers.NewRouter()
+	_, token := newUser(t, "reports", handlers.RoleUser)
+
+	skip, skipFirst := createSchedule(t, h, token, `{"expression":"1+1","interval":"10m"}`)
+	catchUp, catchUpFirst := createSchedule(t, h, token, `{"expression":"2+2","interval":"10m","missed":"catch_up"}`)
+
+	// оркестратор «лежал» почти час: запуски через 10, 20, ... 50 минут пропущены
+	handlers.RunSchedules(skipFirst.Add(45 * time.Minute))
+
+	if runs := scheduleRuns(t, h, token, skip); len(runs) != 0 {
+		t.Errorf("skip: expected missed runs to be skipped, got %d", len(runs))
+	}
+	runs := scheduleRuns(t, h, token, catchUp)
+	if len(runs) != 5 {
+		t.Fatalf("catch_up: expected 5 runs, got %d", len(runs))
+	}
+	if at, _ := time.Parse(time.RFC3339, runs[0]["scheduled_for"].(string)); !at.Equal(catchUpFirst) {
+		t.Errorf("catch_up: expected first run scheduled for %s, got %v", catchUpFirst, runs[0]["scheduled_for"])
+	}
+
+	// после простоя оба продолжают по расписанию
+	var next time.Time
+	db.Conn.QueryRow("SELECT next_run FROM schedules WHERE id = ?", skip).Scan(&next)
+	if !next.Equal(skipFirst.Add(50 * time.Minute)) {
+		t.Errorf("skip: expected next run at %s, got %s", skipFirst.Add(50*time.Minute), next)
+	}
+	handlers.RunSchedules(next)
+	if runs := scheduleRuns(t, h, token, skip); len(runs) != 1 {
+		t.Errorf("skip: expected 1 run after restart, got %d", len(runs))
+	}
+}
+
+// Запуски расписания подчиняются ограничениям владельца; лишние остаются в истории пропущенными
+func TestSchedules_Quota(t *testing.T) {
+	initDB(t)
+	useQuota(t, handlers.Quota{Pending: 2})
+	h := handlers.NewRouter()
+	_, token := newUser(t, "reports", handlers.RoleUser)
+
+	id, first := createSchedule(t, h, token, `{"expression":"2+2","interval":"10m","missed":"catch_up"}`)
+	handlers.RunSchedules(first.Add(45 * time.Minute))
+
+	// пропущенные запуски не становятся выражениями и не занимают место в квоте Stored
+	runs := scheduleRuns(t, h, token, id)
+	if len(runs) != 2 {
+		t.Fatalf("expected 2 runs in the history, got %v", runs)
+	}
+	rr := doRequest(h, "GET", "/api/v1/schedules/"+id, "Bearer "+token, "")
+	var out struct {
+		Schedule struct {
+			SkippedRuns int `json:"skipped_runs"`
+			LastSkip    struct {
+				Reason string `json:"reason"`
+			} `json:"last_skip"`
+		} `json:"schedule"`
+	}
+	json.Unmarshal(rr.Body.Bytes(), &out)
+	if out.Schedule.SkippedRuns != 3 || !strings.HasPrefix(out.Schedule.LastSkip.Reason, "quota_exceeded") {
+		t.Errorf("expected 3 runs skipped for quota_exceeded, got %s", rr.Body.String())
+	}
+}
@@ -0,0 +1,102 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/scriptoxin/yandex-liceum-go-calc/internal/handlers"
+	"github.com/scriptoxin/yandex-liceum-go-calc/pkg/db"
+)
+
+func TestStats(t *testing.T) {
+	initDB(t)
+	r := handlers.NewRouter()
+
+	uid, token := newUser(t, "stats", handlers.RoleUser)
+	otherID, _ := newUser(t, "other", handlers.RoleUser)
+	now := time.Now().UTC()
+	yesterday := now.AddDate(0, 0, -1)
+
+	// десять досчитанных вчера с задержкой 100, 200, ..., 1000 мс
+	for i := 1; i <= 10; i++ {
+		id := "done-" + strconv.Itoa(i)
+		db.Conn.Exec("INSERT INTO expressions(id, user_id, expression, status, result, created_at, finished_at) VALUES(?, ?, '2+2', 'done', 4, ?, ?)",
+			id, uid, yesterday, yesterday.Add(time.Duration(i)*100*time.Millisecond))
+		db.Conn.Exec("INSERT INTO tasks(id, expression_id, node, op, arg1, arg2, attempt, status, queued_at) VALUES(?, ?, 0, '+', 2, 2, 1, 'done', ?)",
+			id+"-t", id, yesterday)
+	}
+	db.Conn.Exec("INSERT INTO tasks(id, expression_id, node, op, arg1, arg2, attempt, status, queued_at) VALUES('done-1-t2', 'done-1', 1, '*', 2, 2, 1, 'done', ?)", yesterday)
+	for i, errText := range []string{"division by zero", "division by zero", "operand expected at position 3", "agent failed"} {
+		db.Conn.Exec("INSERT INTO expressions(id, user_id, expression, status, error, created_at, finished_at) VALUES(?, ?, '1/0', 'error', ?, ?, ?)",
+			"err-"+strconv.Itoa(i), uid, errText, now, now)
+	}
+	db.Conn.Exec("INSERT INTO expressions(id, user_id, expression, status, created_at) VALUES('wait', ?, '1+1', 'pending', ?)", uid, now)
+	// старые и чужие выражения не учитываются
+	db.Conn.Exec("INSERT INTO expressions(id, user_id, expression, status, created_at) VALUES('old', ?, '1+1', 'done', ?)", uid, now.AddDate(0, 0, -40))
+	db.Conn.Exec("INSERT INTO expressions(id, user_id, expression, status, created_at) VALUES('alien', ?, '1+1', 'done', ?)", otherID, now)
+
+	rr := doRequest(r, "GET", "/api/v1/me/stats", "Bearer "+token, "")
+	if rr.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d: %s", rr.Code, rr.Body.String())
+	}
+	var out struct {
+		Stats struct {
+			Total   int            `json:"total"`
+			Counts  map[string]int `json:"counts"`
+			Latency struct {
+				Count int   `json:"count"`
+				Avg   int64 `json:"avg_ms"`
+				P50   int64 `json:"p50_ms"`
+				P90   int64 `json:"p90_ms"`
+				Max   int64 `json:"max_ms"`
+			} `json:"latency"`
+			Operations []struct {
+				Op    string `json:"op"`
+				Count int    `json:"count"`
+			} `json:"operations"`
+			Errors struct {
+				Rate   float64 `json:"rate"`
+				ByKind map[string]struct {
+					Count int `json:"count"`
+				} `json:"by_kind"`
+			} `json:"errors"`
+			Daily []struct {
+				Date  string `json:"date"`
+				Total int    `json:"total"`
+				Done  int    `json:"done"`
+			} `json:"daily"`
+		} `json:"stats"`
+	}
+	if err := json.Unmarshal(rr.Body.Bytes(), &out); err != nil {
+		t.Fatal(err)
+	}
+	s := out.Stats
+	if s.Total != 15 || s.Counts["done"] != 10 || s.Counts["error"] != 4 || s.Counts["pending"] != 1 {
+		t.Errorf("unexpected counts: %d %v", s.Total, s.Counts)
+	}
+	if s.Latency.Count != 10 || s.Latency.Avg != 550 || s.Latency.P50 != 500 || s.Latency.P90 != 900 || s.Latency.Max != 1000 {
+		t.Errorf("unexpected latency: %+v", s.Latency)
+	}
+	if len(s.Operations) != 2 || s.Operations[0].Op != "+" || s.Operations[0].Count != 10 {
+		t.Errorf("unexpected operations: %+v", s.Operations)
+	}
+	if s.Errors.Rate != 4.0/14 || s.Errors.ByKind["division_by_zero"].Count != 2 ||
+		s.Errors.ByKind["syntax"].Count != 1 || s.Errors.ByKind["other"].Count != 1 {
+		t.Errorf("unexpected errors: %+v", s.Errors)
+	}
+	if len(s.Daily) != 30 {
+		t.Fatalf("expected 30 days, got %d", len(s.Daily))
+	}
+	last, prev := s.Daily[29], s.Daily[28]
+	if last.Date != now.Format("2006-01-02") || last.Total != 5 || prev.Total != 10 || prev.Done != 10 || s.Daily[0].Total != 0 {
+		t.Errorf("unexpected histogram tail: %+v %+v", prev, last)
+	}
+
+	rr = doRequest(r, "GET", "/api/v1/me/stats?days=1000", "Bearer "+token, "")
+	if rr.Code != http.StatusBadRequest {
+		t.Errorf("expected 400 for days=1000, got %d", rr.Code)
+	}
+}
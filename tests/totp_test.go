@@ -0,0 +1,106 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/scriptoxin/yandex-liceum-go-calc/internal/handlers"
+	"github.com/scriptoxin/yandex-liceum-go-calc/pkg/totp"
+)
+
+// Секрет "12345678901234567890" из RFC 6238 в base32.
+const rfcSecret = "GEZDGNBVGY3TQOJQGEZDGNBVGY3TQOJQ"
+
+func TestTOTP_RFCVectors(t *testing.T) {
+	// Последние 6 цифр 8-значных кодов из приложения B RFC 6238 (SHA1).
+	cases := map[int64]string{
+		59:         "287082",
+		1111111109: "081804",
+		1234567890: "005924",
+		2000000000: "279037",
+	}
+	for unix, expected := range cases {
+		code, err := totp.Code(rfcSecret, totp.Step(time.Unix(unix, 0)))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if code != expected {
+			t.Errorf("T=%d: expected %s, got %s", unix, expected, code)
+		}
+	}
+}
+
+func TestTwoFactor_LoginFlow(t *testing.T) {
+	initDB(t)
+	clock := time.Unix(1700000000, 0)
+	totp.Now = func() time.Time { return clock }
+	defer func() { totp.Now = time.Now }()
+
+	r := handlers.NewRouter()
+
+	doRequest(r, "POST", "/api/v1/register", "", `{"login":"mia","password":"secure123"}`)
+	token := login(t, r, `{"login":"mia","password":"secure123"}`)
+
+	var setup struct {
+		Secret string `json:"secret"`
+		URI    string `json:"uri"`
+	}
+	rr := doRequest(r, "POST", "/api/v1/me/2fa/setup", "Bearer "+token, `{"password":"wrong"}`)
+	if rr.Code != http.StatusForbidden || errorCode(t, rr.Body.Bytes()) != "wrong_password" {
+		t.Fatalf("setup with a wrong password: expected wrong_password, got %d %s", rr.Code, rr.Body.String())
+	}
+	rr = doRequest(r, "POST", "/api/v1/me/2fa/setup", "Bearer "+token, `{"password":"secure123"}`)
+	json.Unmarshal(rr.Body.Bytes(), &setup)
+	if setup.Secret == "" || setup.URI == "" {
+		t.Fatalf("setup: %d %s", rr.Code, rr.Body.String())
+	}
+
+	code, _ := totp.Code(setup.Secret, totp.Step(clock))
+	rr = doRequest(r, "POST", "/api/v1/me/2fa/enable", "Bearer "+token, `{"password":"wrong","code":"`+code+`"}`)
+	if rr.Code != http.StatusForbidden || errorCode(t, rr.Body.Bytes()) != "wrong_password" {
+		t.Fatalf("enable with a wrong password: expected wrong_password, got %d %s", rr.Code, rr.Body.String())
+	}
+	rr = doRequest(r, "POST", "/api/v1/me/2fa/enable", "Bearer "+token, `{"password":"secure123","code":"`+code+`"}`)
+	var enabled struct {
+		RecoveryCodes []string `json:"recovery_codes"`
+	}
+	json.Unmarshal(rr.Body.Bytes(), &enabled)
+	if len(enabled.RecoveryCodes) == 0 {
+		t.Fatalf("enable: %d %s", rr.Code, rr.Body.String())
+	}
+
+	challenge := func() string {
+		rr := doRequest(r, "POST", "/api/v1/login", "", `{"login":"mia","password":"secure123"}`)
+		var out struct {
+			Challenge string `json:"challenge"`
+		}
+		json.Unmarshal(rr.Body.Bytes(), &out)
+		if out.Challenge == "" {
+			t.Fatalf("expected challenge, got %s", rr.Body.String())
+		}
+		return out.Challenge
+	}
+
+	// Тот же код повторно не принимается
+	rr = doRequest(r, "POST", "/api/v1/login/2fa", "", `{"challenge":"`+challenge()+`","code":"`+code+`"}`)
+	if rr.Code != http.StatusUnauthorized {
+		t.Errorf("reused code: expected status 401, got %d", rr.Code)
+	}
+
+	clock = clock.Add(totp.Period)
+	code, _ = totp.Code(setup.Secret, totp.Step(clock))
+	rr = doRequest(r, "POST", "/api/v1/login/2fa", "", `{"challenge":"`+challenge()+`","code":"`+code+`"}`)
+	if rr.Code != http.StatusOK {
+		t.Errorf("next code: expected status 200, got %d: %s", rr.Code, rr.Body.String())
+	}
+
+	recovery := `{"challenge":"` + challenge() + `","recovery_code":"` + enabled.RecoveryCodes[0] + `"}`
+	if rr := doRequest(r, "POST", "/api/v1/login/2fa", "", recovery); rr.Code != http.StatusOK {
+		t.Errorf("recovery code: expected status 200, got %d", rr.Code)
+	}
+	if rr := doRequest(r, "POST", "/api/v1/login/2fa", "", recovery); rr.Code != http.StatusUnauthorized {
+		t.Errorf("used recovery code: expected status 401, got %d", rr.Code)
+	}
+}
@@ -0,0 +1,69 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/scriptoxin/yandex-liceum-go-calc/internal/handlers"
+	"github.com/scriptoxin/yandex-liceum-go-calc/internal/orchestrator"
+	"github.com/scriptoxin/yandex-liceum-go-calc/pkg/db"
+	pb "github.com/scriptoxin/yandex-liceum-go-calc/proto"
+)
+
+func TestExpressionTrace(t *testing.T) {
+	initDB(t)
+	o := orchestrator.New()
+	o.Lease = 10 * time.Millisecond
+	r := handlers.NewRouter()
+	uid, token := newUser(t, "tracer", handlers.RoleUser)
+
+	db.Conn.Exec("INSERT INTO expressions(id, user_id, expression, status) VALUES('t', ?, '2*(3+4)', 'pending')", uid)
+	o.Submit("t", uid, "2*(3+4)")
+	// первая попытка теряется: агент не успевает вернуть результат
+	if _, err := o.GetTask(context.Background(), &pb.Empty{}); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(20 * time.Millisecond)
+	o.Lease = time.Minute
+	runAgent(t, o)
+
+	rr := doRequest(r, "GET", "/api/v1/expressions/t/trace", "Bearer "+token, "")
+	if rr.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d: %s", rr.Code, rr.Body.String())
+	}
+	var trace struct {
+		AST struct {
+			Op     string   `json:"op"`
+			Result *float64 `json:"result"`
+		} `json:"ast"`
+		Tasks []struct {
+			Node    int    `json:"node"`
+			Attempt int    `json:"attempt"`
+			Status  string `json:"status"`
+		} `json:"tasks"`
+	}
+	json.Unmarshal(rr.Body.Bytes(), &trace)
+	if trace.AST.Op != "*" || trace.AST.Result == nil || *trace.AST.Result != 14 {
+		t.Errorf("unexpected ast: %s", rr.Body.String())
+	}
+	// (3+4) — узел 2: попытка 1 истекла, попытка 2 выполнена; затем умножение в корне
+	if len(trace.Tasks) != 3 ||
+		trace.Tasks[0].Node != 2 || trace.Tasks[0].Status != "expired" ||
+		trace.Tasks[1].Attempt != 2 || trace.Tasks[1].Status != "done" ||
+		trace.Tasks[2].Node != 0 || trace.Tasks[2].Status != "done" {
+		t.Errorf("unexpected tasks: %+v", trace.Tasks)
+	}
+
+	rr = doRequest(r, "GET", "/api/v1/expressions/t/trace?format=dot", "Bearer "+token, "")
+	if body := rr.Body.String(); !strings.Contains(body, `n0 [label="* = 14", shape=box];`) || !strings.Contains(body, "n0 -> n2;") {
+		t.Errorf("unexpected dot: %s", body)
+	}
+	rr = doRequest(r, "GET", "/api/v1/expressions/t/trace?format=mermaid", "Bearer "+token, "")
+	if body := rr.Body.String(); !strings.HasPrefix(body, "graph TD\n") || !strings.Contains(body, "n2 --> n4") {
+		t.Errorf("unexpected mermaid: %s", body)
+	}
+}
@@ -0,0 +1,59 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+
+	"github.com/scriptoxin/yandex-liceum-go-calc/internal/handlers"
+	"github.com/scriptoxin/yandex-liceum-go-calc/pkg/db"
+	apperrors "github.com/scriptoxin/yandex-liceum-go-calc/pkg/errors"
+)
+
+func TestValidateExpression(t *testing.T) {
+	initDB(t)
+	r := handlers.NewRouter()
+	_, token := newUser(t, "checker", handlers.RoleUser)
+
+	limits := handlers.ExprLimits
+	handlers.ExprLimits.MaxDepth = 3
+	defer func() { handlers.ExprLimits = limits }()
+
+	cases := []struct {
+		expr     string
+		code     string
+		position int
+	}{
+		{"(1+2)*3", "", 0},
+		{"", "invalid_expression", 1},
+		{"2 * abc", "invalid_expression", 5},
+		{"(1+2", "invalid_expression", 1},
+		{"1+2+3+4+5", "expression_too_large", 0},
+	}
+	for _, c := range cases {
+		body, _ := json.Marshal(map[string]string{"expression": c.expr})
+		rr := doRequest(r, "POST", "/api/v1/validate", "Bearer "+token, string(body))
+		if c.code == "" {
+			if rr.Code != http.StatusOK {
+				t.Errorf("%q: expected 200, got %d: %s", c.expr, rr.Code, rr.Body.String())
+			}
+			continue
+		}
+		var out apperrors.AppError
+		json.Unmarshal(rr.Body.Bytes(), &out)
+		if rr.Code != http.StatusUnprocessableEntity || out.Code != c.code ||
+			len(out.Fields) != 1 || out.Fields[0].Position != c.position {
+			t.Errorf("%q: unexpected response %d %s", c.expr, rr.Code, rr.Body.String())
+		}
+	}
+
+	// Calculate проверяет так же и ничего не сохраняет
+	if rr := doRequest(r, "POST", "/api/v1/calculate", "Bearer "+token, `{"expression":"abc"}`); rr.Code != http.StatusUnprocessableEntity {
+		t.Errorf("expected 422 from calculate, got %d", rr.Code)
+	}
+	var n int
+	db.Conn.QueryRow("SELECT COUNT(*) FROM expressions").Scan(&n)
+	if n != 0 {
+		t.Errorf("invalid expression was stored")
+	}
+}
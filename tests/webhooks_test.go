@@ -0,0 +1,262 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/scriptoxin/yandex-liceum-go-calc/internal/handlers"
+	"github.com/scriptoxin/yandex-liceum-go-calc/internal/orchestrator"
+)
+
+// receiver — локальный получатель вебхуков.
+type receiver struct {
+	mu       sync.Mutex
+	status   int
+	requests []*http.Request
+	bodies   [][]byte
+}
+
+func (rc *receiver) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	body, _ := io.ReadAll(r.Body)
+	rc.mu.Lock()
+	defer rc.mu.Unlock()
+	rc.requests = append(rc.requests, r)
+	rc.bodies = append(rc.bodies, body)
+	w.WriteHeader(rc.status)
+}
+
+func (rc *receiver) count() int {
+	rc.mu.Lock()
+	defer rc.mu.Unlock()
+	return len(rc.requests)
+}
+
+func TestWebhooks_SignedDelivery(t *testing.T) {
+	initDB(t)
+	o := orchestrator.New()
+	o.Webhooks = orchestrator.NewWebhooks()
+	o.Webhooks.AllowPrivate = true
+	handlers.Orch = o
+	defer func() { handlers.Orch = nil }()
+	h := handlers.NewRouter()
+	_, token := newUser(t, "hooks", handlers.RoleUser)
+
+	callback := &receiver{status: http.StatusOK}
+	subscribed := &receiver{status: http.StatusNoContent}
+	cbServer, subServer := httptest.NewServer(callback), httptest.NewServer(subscribed)
+	defer cbServer.Close()
+	defer subServer.Close()
+
+	rr := doRequest(h, "POST", "/api/v1/webhooks", "Bearer "+token,
+		`{"url":"`+subServer.URL+`","events":["expression.done"]}`)
+	if rr.Code != http.StatusCreated {
+		t.Fatalf("expected 201, got %d: %s", rr.Code, rr.Body.String())
+	}
+	rr = doRequest(h, "POST", "/api/v1/calculate", "Bearer "+token, `{"expression":"2+2","callback_url":"ftp://x"}`)
+	if rr.Code != http.StatusBadRequest {
+		t.Errorf("expected 400 for ftp callback_url, got %d", rr.Code)
+	}
+	rr = doRequest(h, "POST", "/api/v1/calculate", "Bearer "+token, `{"expression":"2+2","callback_url":"`+cbServer.URL+`"}`)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d: %s", rr.Code, rr.Body.String())
+	}
+	runAgent(t, o)
+	o.Webhooks.Deliver(time.Now())
+
+	if callback.count() != 1 || subscribed.count() != 1 {
+		t.Fatalf("expected one delivery to each receiver, got %d and %d", callback.count(), subscribed.count())
+	}
+
+	var secret struct {
+		Secret string `json:"secret"`
+	}
+	rr = doRequest(h, "GET", "/api/v1/webhooks/secret", "Bearer "+token, "")
+	json.Unmarshal(rr.Body.Bytes(), &secret)
+
+	req, body := callback.requests[0], callback.bodies[0]
+	if req.Header.Get(orchestrator.EventHeader) != "expression.done" {
+		t.Errorf("unexpected event %q", req.Header.Get(orchestrator.EventHeader))
+	}
+	// Проверяем подпись так, как это сделал бы получатель
+	var ts, sig string
+	for _, part := range strings.Split(req.Header.Get(orchestrator.SignatureHeader), ",") {
+		if v, ok := strings.CutPrefix(part, "t="); ok {
+			ts = v
+		} else if v, ok := strings.CutPrefix(part, "v1="); ok {
+			sig = v
+		}
+	}
+	mac := hmac.New(sha256.New, []byte(secret.Secret))
+	mac.Write([]byte(ts + "."))
+	mac.Write(body)
+	if secret.Secret == "" || !hmac.Equal([]byte(sig), []byte(hex.EncodeToString(mac.Sum(nil)))) {
+		t.Errorf("signature %q does not match the body", req.Header.Get(orchestrator.SignatureHeader))
+	}
+	var payload struct {
+		Event      string `json:"event"`
+		Expression struct {
+			Status string  `json:"status"`
+			Result float64 `json:"result"`
+		} `json:"expression"`
+	}
+	json.Unmarshal(body, &payload)
+	if payload.Event != "expression.done" || payload.Expression.Status != "done" || payload.Expression.Result != 4 {
+		t.Errorf("unexpected payload %s", body)
+	}
+}
+
+func TestWebhooks_RetriesAndDeadLetter(t *testing.T) {
+	initDB(t)
+	o := orchestrator.New()
+	o.Webhooks = orchestrator.NewWebhooks()
+	o.Webhooks.AllowPrivate = true
+	o.Webhooks.MaxAttempts = 3
+	o.Webhooks.Backoff = time.Minute
+	handlers.Orch = o
+	defer func() { handlers.Orch = nil }()
+	h := handlers.NewRouter()
+	_, token := newUser(t, "hooks", handlers.RoleUser)
+
+	failing := &receiver{status: http.StatusInternalServerError}
+	server := httptest.NewServer(failing)
+	defer server.Close()
+
+	doRequest(h, "POST", "/api/v1/calculate", "Bearer "+token, `{"expression":"1/0","callback_url":"`+server.URL+`"}`)
+	runAgent(t, o)
+
+	now := time.Now()
+	o.Webhooks.Deliver(now)
+	o.Webhooks.Deliver(now.Add(59 * time.Second)) // пауза после первой неудачи — минута
+	if failing.count() != 1 {
+		t.Fatalf("expected 1 attempt before the backoff ends, got %d", failing.count())
+	}
+	o.Webhooks.Deliver(now.Add(time.Minute))
+	o.Webhooks.Deliver(now.Add(time.Minute + 119*time.Second)) // затем две минуты
+	o.Webhooks.Deliver(now.Add(3 * time.Minute))
+	if failing.count() != 3 {
+		t.Fatalf("expected 3 attempts, got %d", failing.count())
+	}
+	o.Webhooks.Deliver(now.Add(time.Hour))
+	if failing.count() != 3 {
+		t.Errorf("expected no attempts after the dead letter, got %d", failing.count())
+	}
+
+	var list struct {
+		Deliveries []struct {
+			ID       string `json:"id"`
+			Event    string `json:"event"`
+			Attempts int    `json:"attempts"`
+		} `json:"deliveries"`
+	}
+	rr := doRequest(h, "GET", "/api/v1/webhooks/deliveries?status=dead", "Bearer "+token, "")
+	json.Unmarshal(rr.Body.Bytes(), &list)
+	if len(list.Deliveries) != 1 || list.Deliveries[0].Attempts != 3 || list.Deliveries[0].Event != "expression.error" {
+		t.Fatalf("expected one dead expression.error delivery, got %s", rr.Body.String())
+	}
+	id := list.Deliveries[0].ID
+
+	var detail struct {
+		Delivery struct {
+			Log []struct {
+				StatusCode int `json:"status_code"`
+			} `json:"log"`
+		} `json:"delivery"`
+	}
+	rr = doRequest(h, "GET", "/api/v1/webhooks/deliveries/"+id, "Bearer "+token, "")
+	json.Unmarshal(rr.Body.Bytes(), &detail)
+	if len(detail.Delivery.Log) != 3 || detail.Delivery.Log[2].StatusCode != 500 {
+		t.Errorf("expected 3 logged attempts with 500, got %s", rr.Body.String())
+	}
+
+	// получатель починился — повторяем вручную
+	failing.mu.Lock()
+	failing.status = http.StatusOK
+	failing.mu.Unlock()
+	rr = doRequest(h, "POST", "/api/v1/webhooks/deliveries/"+id+"/retry", "Bearer "+token, "")
+	if rr.Code != http.StatusAccepted {
+		t.Fatalf("expected 202, got %d: %s", rr.Code, rr.Body.String())
+	}
+	o.Webhooks.Deliver(time.Now())
+	rr = doRequest(h, "GET", "/api/v1/webhooks/deliveries?status=delivered", "Bearer "+token, "")
+	json.Unmarshal(rr.Body.Bytes(), &list)
+	if len(list.Deliveries) != 1 || failing.count() != 4 {
+		t.Errorf("expected the delivery to succeed after retry, got %s", rr.Body.String())
+	}
+}
+
+// Без AllowPrivate вебхуки во внутреннюю сеть не уходят, перенаправления не выполняются
+func TestWebhooks_PrivateTargets(t *testing.T) {
+	initDB(t)
+	o := orchestrator.New()
+	o.Webhooks = orchestrator.NewWebhooks()
+	o.Webhooks.MaxAttempts = 1
+	handlers.Orch = o
+	defer func() { handlers.Orch = nil }()
+	h := handlers.NewRouter()
+	_, token := newUser(t, "hooks", handlers.RoleUser)
+
+	for _, url := range []string{
+		"http://127.0.0.1:8080/hook", "http://localhost/hook", "http://[::1]/hook", "http://169.254.169.254/",
+		"http://[::ffff:127.0.0.1]/hook", "http://[::ffff:a00:1]/hook", "http://100.64.0.1/hook", "http://198.18.0.1/hook",
+		"http://[fd00::1]/hook", "http://[64:ff9b::a00:1]/hook", "http://[::127.0.0.1]/hook",
+	} {
+		rr := doRequest(h, "POST", "/api/v1/webhooks", "Bearer "+token, `{"url":"`+url+`"}`)
+		if rr.Code != http.StatusBadRequest {
+			t.Errorf("%s: expected 400, got %d", url, rr.Code)
+		}
+	}
+	_, public := newUser(t, "public", handlers.RoleUser)
+	if rr := doRequest(h, "POST", "/api/v1/webhooks", "Bearer "+public, `{"url":"http://93.184.216.34/hook"}`); rr.Code != http.StatusCreated {
+		t.Errorf("public address: expected 201, got %d: %s", rr.Code, rr.Body.String())
+	}
+
+	// Адрес прошёл проверку при создании, но при отправке указывает на loopback,
+	// как после подмены DNS: подключение запрещено
+	internal := &receiver{status: http.StatusOK}
+	intServer := httptest.NewServer(internal)
+	defer intServer.Close()
+	o.Webhooks.AllowPrivate = true
+	rr := doRequest(h, "POST", "/api/v1/calculate", "Bearer "+token, `{"expression":"2+2","callback_url":"`+intServer.URL+`"}`)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d: %s", rr.Code, rr.Body.String())
+	}
+	o.Webhooks.AllowPrivate = false
+	runAgent(t, o)
+	o.Webhooks.Deliver(time.Now())
+	if internal.count() != 0 {
+		t.Errorf("expected no delivery to a loopback address, got %d", internal.count())
+	}
+
+	// Получатель перенаправляет на другой адрес — перенаправление не выполняется
+	o.Webhooks.AllowPrivate = true
+	redirect := httptest.NewServer(http.RedirectHandler(intServer.URL, http.StatusFound))
+	defer redirect.Close()
+	rr = doRequest(h, "POST", "/api/v1/calculate", "Bearer "+token, `{"expression":"3+3","callback_url":"`+redirect.URL+`"}`)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d: %s", rr.Code, rr.Body.String())
+	}
+	runAgent(t, o)
+	o.Webhooks.Deliver(time.Now())
+	if internal.count() != 0 {
+		t.Errorf("expected the redirect not to be followed, got %d", internal.count())
+	}
+	var list struct {
+		Deliveries []struct {
+			Status string `json:"status"`
+		} `json:"deliveries"`
+	}
+	rr = doRequest(h, "GET", "/api/v1/webhooks/deliveries?status=dead", "Bearer "+token, "")
+	json.Unmarshal(rr.Body.Bytes(), &list)
+	if len(list.Deliveries) != 2 {
+		t.Errorf("expected both deliveries to fail, got %s", rr.Body.String())
+	}
+}
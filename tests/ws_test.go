@@ -0,0 +1,208 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+
+	"github.com/scriptoxin/yandex-liceum-go-calc/internal/handlers"
+	"github.com/scriptoxin/yandex-liceum-go-calc/internal/orchestrator"
+	"github.com/scriptoxin/yandex-liceum-go-calc/pkg/db"
+)
+
+type wsFrame struct {
+	Type   string   `json:"type"`
+	Ref    string   `json:"ref"`
+	ID     string   `json:"id"`
+	Status string   `json:"status"`
+	Result *float64 `json:"result"`
+	Code   string   `json:"code"`
+}
+
+// wsURL поднимает сервер с маршрутами API и возвращает адрес для подключения.
+func wsURL(t *testing.T) string {
+	t.Helper()
+	srv := httptest.NewServer(handlers.NewRouter())
+	t.Cleanup(srv.Close)
+	return "ws" + strings.TrimPrefix(srv.URL, "http") + "/api/v1/ws"
+}
+
+// dialWS поднимает сервер с /api/v1/ws и подключается к нему с токеном.
+func dialWS(t *testing.T, token string) *websocket.Conn {
+	t.Helper()
+	conn, resp, err := websocket.DefaultDialer.Dial(wsURL(t), http.Header{"Authorization": {"Bearer " + token}})
+	if err != nil {
+		status := 0
+		if resp != nil {
+			status = resp.StatusCode
+		}
+		t.Fatalf("dial: %v (status %d)", err, status)
+	}
+	t.Cleanup(func() { conn.Close() })
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	return conn
+}
+
+func readFrame(t *testing.T, conn *websocket.Conn) wsFrame {
+	t.Helper()
+	var f wsFrame
+	if err := conn.ReadJSON(&f); err != nil {
+		t.Fatalf("read: %v", err)
+	}
+	return f
+}
+
+func TestWebSocket_Calculate(t *testing.T) {
+	initDB(t)
+	o := orchestrator.New()
+	handlers.Orch = o
+	defer func() { handlers.Orch = nil }()
+	_, token := newUser(t, "ws", handlers.RoleUser)
+	conn := dialWS(t, token)
+
+	conn.WriteJSON(map[string]string{"type": "ping", "ref": "p"})
+	if f := readFrame(t, conn); f.Type != "pong" || f.Ref != "p" {
+		t.Fatalf("expected pong, got %+v", f)
+	}
+
+	conn.WriteJSON(map[string]string{"type": "calculate", "ref": "1", "expression": "(1+2)*4"})
+	accepted := readFrame(t, conn)
+	if accepted.Type != "accepted" || accepted.Ref != "1" || accepted.ID == "" {
+		t.Fatalf("expected accepted, got %+v", accepted)
+	}
+	var audited int
+	db.Conn.QueryRow("SELECT COUNT(*) FROM audit_events WHERE action = ? AND target = ?", handlers.AuditExprSubmit, accepted.ID).Scan(&audited)
+	if audited != 1 {
+		t.Errorf("expected one %s audit event, got %d", handlers.AuditExprSubmit, audited)
+	}
+	go runAgent(t, o)
+
+	for {
+		f := readFrame(t, conn)
+		if f.Type != "status" || f.ID != accepted.ID {
+			t.Fatalf("unexpected frame %+v", f)
+		}
+		if f.Status == orchestrator.StatusDone {
+			if f.Result == nil || *f.Result != 12 {
+				t.Errorf("expected result 12, got %+v", f)
+			}
+			break
+		}
+	}
+
+	conn.WriteMessage(websocket.TextMessage, []byte("not json"))
+	if f := readFrame(t, conn); f.Type != "error" || f.Code != "invalid_json" {
+		t.Errorf("expected invalid_json error, got %+v", f)
+	}
+}
+
+// В пространстве организации приходят статусы выражений других её участников
+func TestWebSocket_Workspace(t *testing.T) {
+	initDB(t)
+	o := orchestrator.New()
+	handlers.Orch = o
+	defer func() { handlers.Orch = nil }()
+	alice, token := newUser(t, "alice", handlers.RoleUser)
+	bob, _ := newUser(t, "bob", handlers.RoleUser)
+	org := newOrg(t, map[int]string{alice: "owner", bob: "member"})
+
+	conn, _, err := websocket.DefaultDialer.Dial(wsURL(t)+"?workspace="+strconv.Itoa(org),
+		http.Header{"Authorization": {"Bearer " + token}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	// ping гарантирует, что подписка уже оформлена
+	conn.WriteJSON(map[string]string{"type": "ping"})
+	readFrame(t, conn)
+
+	db.Conn.Exec("INSERT INTO expressions(id, user_id, expression, status) VALUES('personal', ?, '1+1', 'pending')", bob)
+	o.Submit("personal", bob, "1+1")
+	db.Conn.Exec("INSERT INTO expressions(id, user_id, org_id, expression, status) VALUES('shared', ?, ?, '1+2', 'pending')", bob, org)
+	o.Submit("shared", bob, "1+2")
+	if f := readFrame(t, conn); f.Type != "status" || f.ID != "shared" {
+		t.Errorf("expected the org member's expression, got %+v", f)
+	}
+}
+
+func TestWebSocket_RateLimit(t *testing.T) {
+	initDB(t)
+	handlers.Orch = orchestrator.New()
+	defer func() { handlers.Orch = nil }()
+	_, token := newUser(t, "burst", handlers.RoleUser)
+	conn := dialWS(t, token)
+
+	limited := false
+	for i := 0; i < 30 && !limited; i++ {
+		conn.WriteJSON(map[string]string{"type": "calculate", "expression": "1+1"})
+		for {
+			f := readFrame(t, conn)
+			if f.Type == "error" && f.Code == "rate_limited" {
+				limited = true
+			}
+			if f.Type != "status" {
+				break
+			}
+		}
+	}
+	if !limited {
+		t.Error("expected rate_limited after a burst of submissions")
+	}
+}
+
+func TestWebSocket_RequiresAuth(t *testing.T) {
+	initDB(t)
+	handlers.Orch = orchestrator.New()
+	defer func() { handlers.Orch = nil }()
+
+	_, resp, err := websocket.DefaultDialer.Dial(wsURL(t), nil)
+	if err == nil || resp == nil || resp.StatusCode != http.StatusUnauthorized {
+		t.Errorf("expected 401 without token, got %v", err)
+	}
+}
+
+// Браузер не может поставить Authorization и передаёт токен подпротоколом
+func TestWebSocket_ProtocolToken(t *testing.T) {
+	initDB(t)
+	handlers.Orch = orchestrator.New()
+	defer func() { handlers.Orch = nil }()
+	_, token := newUser(t, "browser", handlers.RoleUser)
+	url := wsURL(t)
+
+	dialer := websocket.Dialer{Subprotocols: []string{"bearer", token}}
+	conn, _, err := dialer.Dial(url, nil)
+	if err != nil {
+		t.Fatalf("dial with protocol token: %v", err)
+	}
+	defer conn.Close()
+	if conn.Subprotocol() != "bearer" {
+		t.Errorf("expected subprotocol bearer, got %q", conn.Subprotocol())
+	}
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	conn.WriteJSON(map[string]string{"type": "ping", "ref": "p"})
+	if f := readFrame(t, conn); f.Type != "pong" {
+		t.Errorf("expected pong, got %+v", f)
+	}
+
+	dialer.Subprotocols = []string{"bearer", "not-a-token"}
+	_, resp, err := dialer.Dial(url, nil)
+	if err == nil || resp == nil || resp.StatusCode != http.StatusUnauthorized {
+		t.Errorf("expected 401 with a bad protocol token, got %v", err)
+	}
+
+	// На обычных маршрутах подпротокол токеном не считается
+	r := handlers.NewRouter()
+	req := httptest.NewRequest("GET", "/api/v1/expressions", nil)
+	req.Header.Set("Sec-WebSocket-Protocol", "bearer, "+token)
+	rr := httptest.NewRecorder()
+	r.ServeHTTP(rr, req)
+	if rr.Code != http.StatusUnauthorized {
+		t.Errorf("expected 401 outside /ws, got %d", rr.Code)
+	}
+}